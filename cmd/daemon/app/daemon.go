@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/MR5356/syncer/pkg/daemon"
+	gitConfig "github.com/MR5356/syncer/pkg/domain/git/config"
+	imageConfig "github.com/MR5356/syncer/pkg/domain/image/config"
+	"github.com/MR5356/syncer/pkg/utils/cmdutil"
+	"github.com/MR5356/syncer/pkg/utils/structutil"
+	"github.com/MR5356/syncer/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+const defaultRetries = 3
+
+var (
+	configFile       string
+	procNum, retries int
+	runNow           bool
+	flags            cmdutil.Flags
+
+	defaultProcNum = runtime.NumCPU()
+)
+
+func NewDaemonCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the image and git syncs on schedules",
+		Long: `Run the image and git syncs as a long-running process.
+
+Each mapping of the config is synced on its own schedule, a mapping is never run twice at the same time.
+
+Complete code is available at https://github.com/Mr5356/syncer`,
+		Version: version.Version,
+		Run: func(cmd *cobra.Command, args []string) {
+			stop := flags.Setup()
+			defer stop()
+			d, err := daemon.New(loadConfig())
+			if err != nil {
+				logrus.Fatalf("start daemon failed: %s", err)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			d.Start(runNow)
+
+			var server *http.Server
+			if listen := d.Config().API.Listen; listen != "" {
+				server = &http.Server{Addr: listen, Handler: d.Handler()}
+				go func() {
+					logrus.Infof("serve api on %s", listen)
+					if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+						logrus.Fatalf("serve api failed: %s", err)
+					}
+				}()
+			}
+
+			<-ctx.Done()
+			logrus.Infof("stopping daemon, waiting for the running syncs")
+			if server != nil {
+				// 日志跟随请求会一直保持连接，不等待它们结束
+				_ = server.Close()
+			}
+			d.Stop()
+		},
+	}
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file path")
+	cmd.PersistentFlags().IntVarP(&procNum, "proc", "p", defaultProcNum, "process num")
+	cmd.PersistentFlags().IntVarP(&retries, "retries", "r", defaultRetries, "retries num")
+	flags.AddFlags(cmd, false)
+	cmd.Flags().BoolVar(&runNow, "run-now", false, "also run every mapping once on start")
+	return cmd
+}
+
+// loadConfig reads the config file and applies the flags to the image and git sections
+func loadConfig() *daemon.Config {
+	if configFile == "" {
+		logrus.Fatalf("config file can not be empty")
+	}
+	cfg, err := daemon.NewConfigFromFile(configFile)
+	if err != nil {
+		logrus.Fatalf("error parse config file: %s", err)
+	}
+
+	if cfg.Image.Proc == 0 || procNum != defaultProcNum {
+		cfg.Image.With(imageConfig.WithProc(procNum))
+	}
+	if cfg.Image.Retries == 0 || retries != defaultRetries {
+		cfg.Image.With(imageConfig.WithRetries(retries))
+	}
+	if cfg.Git.Proc == 0 || procNum != defaultProcNum {
+		cfg.Git.With(gitConfig.WithProc(procNum))
+	}
+	if cfg.Git.Retries == 0 || retries != defaultRetries {
+		cfg.Git.With(gitConfig.WithRetries(retries))
+	}
+	logrus.Debugf("run with config: \n%s", structutil.Struct2String(cfg))
+	return cfg
+}
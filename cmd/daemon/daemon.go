@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/MR5356/syncer/cmd/daemon/app"
+	_ "github.com/MR5356/syncer/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	if err := app.NewDaemonCommand().Execute(); err != nil {
+		logrus.Fatal(err)
+	}
+}
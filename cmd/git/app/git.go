@@ -1,21 +1,30 @@
 package app
 
 import (
+	"context"
 	"github.com/MR5356/syncer/pkg/domain/git/client"
 	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/notify"
+	"github.com/MR5356/syncer/pkg/utils/cmdutil"
 	"github.com/MR5356/syncer/pkg/utils/structutil"
 	"github.com/MR5356/syncer/pkg/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"syscall"
+	"time"
 )
 
 const defaultRetries = 3
 
 var (
-	debug                                          bool
+	sshAgent, strictHostKeyChecking                bool
 	configFile, privateKeyFile, privateKeyPassword string
 	retries, procNum                               int
+	flags                                          cmdutil.Flags
 
 	defaultProcNum = runtime.NumCPU()
 )
@@ -29,30 +38,15 @@ func NewGitCommand() *cobra.Command {
 Complete code is available at https://github.com/Mr5356/syncer`,
 		Version: version.Version,
 		Run: func(cmd *cobra.Command, args []string) {
-			if debug {
-				logrus.SetLevel(logrus.DebugLevel)
-			}
-			cfg := config.NewConfig()
-			if configFile == "" {
-				logrus.Fatalf("config file can not be empty")
-			}
-			cfg = config.NewConfigFromFile(configFile)
-
-			if cfg.Proc == 0 || procNum != defaultProcNum {
-				cfg.With(config.WithProc(procNum))
-			}
-			if cfg.Retries == 0 || retries != defaultRetries {
-				cfg.With(config.WithRetries(retries))
-			}
-			if cfg.PrivateKeyFile == "" {
-				cfg.With(config.WithPrivateKeyFile(privateKeyFile))
-			}
-			if privateKeyPassword != "" {
-				cfg.With(config.WithPrivateKeyPassword(privateKeyPassword))
-			}
-			logrus.Debugf("run with config: \n%s", structutil.Struct2String(cfg))
+			stop := flags.Setup()
+			cfg := loadConfig()
 			cli := client.NewClient(cfg)
-			if err := cli.Run(); err != nil {
+			start := time.Now()
+			err := cli.Run()
+			notify.Send(cfg.Notifications, notify.NewSummary("git", configFile, cli, start, err), cfg.StateDir)
+			flags.WriteMetrics("git", cli.Tasks(), cli.Failed())
+			stop()
+			if err != nil {
 				logrus.Fatalf("run git sync failed: %+v", err)
 			}
 		},
@@ -63,8 +57,112 @@ Complete code is available at https://github.com/Mr5356/syncer`,
 	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file path")
 	cmd.PersistentFlags().StringVar(&privateKeyFile, "privateKeyFile", "", "private key file")
 	cmd.PersistentFlags().StringVar(&privateKeyPassword, "privateKeyPassword", "", "private key file password")
+	cmd.PersistentFlags().BoolVar(&sshAgent, "sshAgent", false, "use keys of the ssh agent")
+	cmd.PersistentFlags().BoolVar(&strictHostKeyChecking, "strictHostKeyChecking", false, "fail on unknown ssh hosts")
 	cmd.PersistentFlags().IntVarP(&procNum, "proc", "p", defaultProcNum, "process num")
 	cmd.PersistentFlags().IntVarP(&retries, "retries", "r", defaultRetries, "retries num")
-	cmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug mode")
+	flags.AddFlags(cmd, true)
+	cmd.AddCommand(
+		newExportCommand(),
+		newImportCommand(),
+	)
+	return cmd
+}
+
+func newExportCommand() *cobra.Command {
+	var output, stateFile string
+	var full bool
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the git repos to bundle files",
+		Long: `Export every repo in the config to a git bundle file for air-gapped transfer.
+
+Bundles are incremental since the refs recorded in the state file by the last export,
+use --full to export the whole history.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			stop := flags.Setup()
+			cfg := loadConfig()
+			if output == "" {
+				logrus.Fatalf("output dir can not be empty")
+			}
+			if stateFile == "" {
+				stateFile = filepath.Join(output, "state.json")
+			}
+			cli := client.NewClient(cfg)
+			start := time.Now()
+			// 中断时跳过剩余的任务，已写入的 bundle 仍记录到清单与状态文件
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			err := cli.ExportContext(ctx, output, stateFile, full)
+			stop()
+			notify.Send(cfg.Notifications, notify.NewSummary("git", configFile+" export", cli, start, err), cfg.StateDir)
+			flags.WriteMetrics("git", cli.Tasks(), cli.Failed())
+			stop()
+			if err != nil {
+				logrus.Fatalf("run git export failed: %+v", err)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "bundle output dir")
+	cmd.Flags().StringVar(&stateFile, "state", "", "state file of the exported refs (default <output>/state.json)")
+	cmd.Flags().BoolVar(&full, "full", false, "export the whole history instead of the changes since the last export")
+	return cmd
+}
+
+func newImportCommand() *cobra.Command {
+	var input string
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import the bundle files to the destination repos",
+		Long:  `Push the bundle files written by git export to the destinations of their repos in the config.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			stop := flags.Setup()
+			cfg := loadConfig()
+			if input == "" {
+				logrus.Fatalf("input dir can not be empty")
+			}
+			cli := client.NewClient(cfg)
+			start := time.Now()
+			err := cli.Import(input)
+			notify.Send(cfg.Notifications, notify.NewSummary("git", configFile+" import", cli, start, err), cfg.StateDir)
+			flags.WriteMetrics("git", cli.Tasks(), cli.Failed())
+			stop()
+			if err != nil {
+				logrus.Fatalf("run git import failed: %+v", err)
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", "", "bundle input dir")
 	return cmd
 }
+
+// loadConfig reads the config file and applies the flags
+func loadConfig() *config.Config {
+	if configFile == "" {
+		logrus.Fatalf("config file can not be empty")
+	}
+	cfg := config.NewConfigFromFile(configFile)
+
+	if cfg.Proc == 0 || procNum != defaultProcNum {
+		cfg.With(config.WithProc(procNum))
+	}
+	if cfg.Retries == 0 || retries != defaultRetries {
+		cfg.With(config.WithRetries(retries))
+	}
+	if cfg.PrivateKeyFile == "" {
+		cfg.With(config.WithPrivateKeyFile(privateKeyFile))
+	}
+	if privateKeyPassword != "" {
+		cfg.With(config.WithPrivateKeyPassword(privateKeyPassword))
+	}
+	if sshAgent {
+		cfg.With(config.WithSSHAgent(sshAgent))
+	}
+	if strictHostKeyChecking {
+		cfg.With(config.WithStrictHostKeyChecking(strictHostKeyChecking))
+	}
+	if err := notify.Validate(cfg.Notifications); err != nil {
+		logrus.Fatalf("invalid notifications: %s", err)
+	}
+	logrus.Debugf("run with config: \n%s", structutil.Struct2String(cfg))
+	return cfg
+}
@@ -1,24 +1,36 @@
 package app
 
 import (
+	"context"
 	"github.com/MR5356/syncer/pkg/domain/image/client"
 	"github.com/MR5356/syncer/pkg/domain/image/config"
+	"github.com/MR5356/syncer/pkg/log"
+	"github.com/MR5356/syncer/pkg/notify"
+	"github.com/MR5356/syncer/pkg/progress"
+	"github.com/MR5356/syncer/pkg/utils/cmdutil"
 	"github.com/MR5356/syncer/pkg/utils/structutil"
 	"github.com/MR5356/syncer/pkg/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"io"
+	"os"
 	"runtime"
+	"time"
 )
 
 const (
 	defaultRetries = 3
+
+	// progressInterval is the refresh interval of the progress view
+	progressInterval = 200 * time.Millisecond
 )
 
 var (
 	configFile       string
 	procNum, retries int
-
-	debug bool
+	showProgress     bool
+	flags            cmdutil.Flags
 
 	defaultProcNum = runtime.NumCPU()
 )
@@ -32,9 +44,7 @@ func NewImageCommand() *cobra.Command {
 Complete code is available at https://github.com/Mr5356/syncer`,
 		Version: version.Version,
 		Run: func(cmd *cobra.Command, args []string) {
-			if debug {
-				logrus.SetLevel(logrus.DebugLevel)
-			}
+			stop := flags.Setup()
 			cfg := config.NewConfig()
 			if configFile != "" {
 				cfg = config.NewConfigFromFile(configFile)
@@ -47,9 +57,19 @@ Complete code is available at https://github.com/Mr5356/syncer`,
 			if cfg.Retries == 0 || retries != defaultRetries {
 				cfg.With(config.WithRetries(retries))
 			}
+			if err := notify.Validate(cfg.Notifications); err != nil {
+				logrus.Fatalf("invalid notifications: %s", err)
+			}
 			logrus.Debugf("run with config: \n%s", structutil.Struct2String(cfg))
 			cli := client.NewClient(cfg)
-			if err := cli.Run(); err != nil {
+			start := time.Now()
+			ctx, stopProgress := startProgress()
+			err := cli.RunContext(ctx)
+			stopProgress()
+			notify.Send(cfg.Notifications, notify.NewSummary("image", configFile, cli, start, err), cfg.StateDir)
+			flags.WriteMetrics("image", cli.Tasks(), cli.Failed())
+			stop()
+			if err != nil {
 				logrus.Fatalf("run image sync failed: %s", err)
 			}
 		},
@@ -59,6 +79,25 @@ Complete code is available at https://github.com/Mr5356/syncer`,
 	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file path")
 	cmd.PersistentFlags().IntVarP(&procNum, "proc", "p", defaultProcNum, "process num")
 	cmd.PersistentFlags().IntVarP(&retries, "retries", "r", defaultRetries, "retries num")
-	cmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug mode")
+	cmd.PersistentFlags().BoolVar(&showProgress, "progress", true, "show the live progress when stderr is a terminal")
+	flags.AddFlags(cmd, true)
 	return cmd
 }
+
+// startProgress shows the live progress of the run when stderr is a terminal and the logs are text, the returned
+// context carries the tracker of the progress and the returned function stops the view
+func startProgress() (context.Context, func()) {
+	ctx := context.Background()
+	fd := int(os.Stderr.Fd())
+	if !showProgress || flags.LogFormat == log.FormatJson || !term.IsTerminal(fd) {
+		return ctx, func() {}
+	}
+	width, _, _ := term.GetSize(fd)
+	tracker := progress.New()
+	display := progress.NewDisplay(os.Stderr, tracker, width)
+	// 日志由 hook 输出到进度上方
+	logrus.SetOutput(io.Discard)
+	logrus.AddHook(progress.NewLogHook(display, flags.Debug))
+	display.Start(progressInterval)
+	return progress.WithTracker(ctx, tracker), display.Stop
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	daemonApp "github.com/MR5356/syncer/cmd/daemon/app"
 	gitApp "github.com/MR5356/syncer/cmd/git/app"
 	imageApp "github.com/MR5356/syncer/cmd/image/app"
 	_ "github.com/MR5356/syncer/pkg/log"
@@ -23,6 +24,7 @@ func NewSyncCommand() *cobra.Command {
 	cmd.AddCommand(
 		imageApp.NewImageCommand(),
 		gitApp.NewGitCommand(),
+		daemonApp.NewDaemonCommand(),
 	)
 	cmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "enable debug mode")
 	return cmd
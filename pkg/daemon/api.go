@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+const apiPrefix = "/api/v1/"
+
+// SyncRequest triggers the mapping of source, or an ad-hoc sync of source to destination if destination is set
+type SyncRequest struct {
+	Domain      string `json:"domain"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// Handler returns the http handler of the control api:
+//
+//	GET    /api/v1/mappings          list the mappings with their last results
+//	POST   /api/v1/runs              trigger a mapping or an ad-hoc sync, see SyncRequest
+//	GET    /api/v1/runs              list the running and the last finished runs
+//	GET    /api/v1/runs/{id}         get a run
+//	DELETE /api/v1/runs/{id}         cancel a run
+//	GET    /api/v1/runs/{id}/log     get the log of a run, ?follow=true streams it until the run finishes
+//	GET    /metrics                  the prometheus metrics if enabled
+//	POST   /api/v1/webhooks/{name}   receive the events of a webhook, validated by its own secret instead of the token
+func (d *Daemon) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+		parts := strings.Split(path, "/")
+		if len(parts) == 2 && parts[0] == "webhooks" && strings.HasPrefix(r.URL.Path, apiPrefix) {
+			d.handleWebhook(w, r, parts[1])
+			return
+		}
+		if !d.authorized(r) {
+			writeError(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/metrics" && d.cfg.API.Metrics:
+			metrics.Handler().ServeHTTP(w, r)
+		case !strings.HasPrefix(r.URL.Path, apiPrefix):
+			writeError(w, http.StatusNotFound, ErrNotFound)
+		case path == "mappings":
+			d.handleMappings(w, r)
+		case path == "runs":
+			d.handleRuns(w, r)
+		case len(parts) == 2 && parts[0] == "runs":
+			d.handleRun(w, r, parts[1])
+		case len(parts) == 3 && parts[0] == "runs" && parts[2] == "log":
+			d.handleLog(w, r, parts[1])
+		default:
+			writeError(w, http.StatusNotFound, ErrNotFound)
+		}
+	})
+}
+
+// authorized checks the bearer token if the api requires one
+func (d *Daemon) authorized(r *http.Request) bool {
+	token := d.cfg.API.Token.Value()
+	if token == "" {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func (d *Daemon) handleMappings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, d.Mappings())
+}
+
+func (d *Daemon) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, d.Runs())
+	case http.MethodPost:
+		req := new(SyncRequest)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %s", err))
+			return
+		}
+		if req.Domain == "" || req.Source == "" {
+			writeError(w, http.StatusBadRequest, errors.New("domain and source are required"))
+			return
+		}
+
+		var run *Run
+		var err error
+		if req.Destination == "" {
+			run, err = d.Trigger(req.Domain, req.Source)
+		} else {
+			run, err = d.SyncPair(req.Domain, req.Source, req.Destination)
+		}
+		switch {
+		case errors.Is(err, ErrNotFound):
+			writeError(w, http.StatusNotFound, err)
+		case errors.Is(err, ErrRunning):
+			writeError(w, http.StatusConflict, err)
+		case errors.Is(err, ErrForbidden):
+			writeError(w, http.StatusForbidden, err)
+		case errors.Is(err, ErrStopped):
+			writeError(w, http.StatusServiceUnavailable, err)
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err)
+		default:
+			writeJSON(w, http.StatusAccepted, run.Info())
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (d *Daemon) handleRun(w http.ResponseWriter, r *http.Request, id string) {
+	run := d.Run(id)
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s: %w", id, ErrNotFound))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, run.Info())
+	case http.MethodDelete:
+		if run.Done() {
+			writeError(w, http.StatusConflict, fmt.Errorf("run %s is already %s", id, run.Info().State))
+			return
+		}
+		logrus.Infof("cancel run %s", id)
+		run.Cancel()
+		writeJSON(w, http.StatusAccepted, run.Info())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (d *Daemon) handleLog(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	run := d.Run(id)
+	if run == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s: %w", id, ErrNotFound))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	// 客户端断开后 r.Context() 会被取消
+	_ = run.Log(r.Context(), w, r.URL.Query().Get("follow") == "true")
+}
+
+func (d *Daemon) handleWebhook(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookSize)
+	res, err := d.Webhook(name, r)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, errUnauthorized):
+		logrus.Warnf("webhook %s: invalid signature or secret from %s", name, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, http.StatusAccepted, res)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
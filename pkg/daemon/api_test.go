@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDaemon_Handler(t *testing.T) {
+	release := make(chan struct{})
+	d := newTestDaemon(t, release)
+	defer d.Stop()
+	d.cfg.API.Token = "secret"
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+
+	do := func(method, path, body string, out any) int {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	resp, err := http.Get(server.URL + "/api/v1/mappings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("request without token: %d", resp.StatusCode)
+	}
+
+	var mappings []*Status
+	if code := do(http.MethodGet, "/api/v1/mappings", "", &mappings); code != http.StatusOK || len(mappings) != 3 {
+		t.Fatalf("list mappings: %d %+v", code, mappings)
+	}
+
+	var run RunInfo
+	if code := do(http.MethodPost, "/api/v1/runs", `{"domain":"git","source":"git@github.com:MR5356/syncer.git"}`, &run); code != http.StatusAccepted || run.State != RunRunning {
+		t.Fatalf("trigger: %d %+v", code, run)
+	}
+	if code := do(http.MethodPost, "/api/v1/runs", `{"domain":"git","source":"git@github.com:MR5356/syncer.git"}`, nil); code != http.StatusConflict {
+		t.Errorf("trigger running mapping: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/v1/runs", `{"domain":"git","source":"git@github.com:MR5356/unknown.git"}`, nil); code != http.StatusNotFound {
+		t.Errorf("trigger unknown mapping: %d", code)
+	}
+	if code := do(http.MethodPost, "/api/v1/runs", `{"domain":"svn","source":"a","destination":"b"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("sync invalid domain: %d", code)
+	}
+
+	// 跟随日志直到运行结束
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/v1/runs/"+run.Id+"/log?follow=true", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, "start git git@github.com:MR5356/syncer.git") {
+		t.Errorf("first log line = %q, %v", line, err)
+	}
+
+	var canceled RunInfo
+	if code := do(http.MethodDelete, "/api/v1/runs/"+run.Id, "", &canceled); code != http.StatusAccepted {
+		t.Errorf("cancel: %d", code)
+	}
+	// 运行结束后日志流随之结束
+	rest := new(strings.Builder)
+	_, _ = bufio.NewReader(resp.Body).WriteTo(rest)
+	_ = resp.Body.Close()
+	if !strings.Contains(rest.String(), "canceled") {
+		t.Errorf("log does not end with the cancellation:\n%s", rest)
+	}
+
+	if code := do(http.MethodGet, "/api/v1/runs/"+run.Id, "", &run); code != http.StatusOK || run.State != RunCanceled {
+		t.Errorf("get run: %d %+v", code, run)
+	}
+	if code := do(http.MethodDelete, "/api/v1/runs/"+run.Id, "", nil); code != http.StatusConflict {
+		t.Errorf("cancel finished run: %d", code)
+	}
+	var runs []RunInfo
+	if code := do(http.MethodGet, "/api/v1/runs", "", &runs); code != http.StatusOK || len(runs) != 1 {
+		t.Errorf("list runs: %d %+v", code, runs)
+	}
+	if code := do(http.MethodGet, "/api/v1/runs/42", "", nil); code != http.StatusNotFound {
+		t.Errorf("get unknown run: %d", code)
+	}
+}
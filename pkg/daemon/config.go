@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"fmt"
+	gitConfig "github.com/MR5356/syncer/pkg/domain/git/config"
+	imageConfig "github.com/MR5356/syncer/pkg/domain/image/config"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/mcuadros/go-defaults"
+	"github.com/robfig/cron/v3"
+	"strings"
+	"time"
+)
+
+const (
+	DomainImage = "image"
+	DomainGit   = "git"
+)
+
+// Config is the config of the daemon, the image and git sections are the configs of syncer image and syncer git
+type Config struct {
+	// Schedule is the default schedule of the mappings, a cron expression like */30 * * * *,
+	// a descriptor like @hourly or an interval like @every 1h or 1h
+	Schedule string `json:"schedule" yaml:"schedule" default:"@every 1h"`
+	// Schedules overrides the schedule of single mappings, keyed by domain and then by the source of the mapping
+	Schedules map[string]map[string]string `json:"schedules" yaml:"schedules"`
+
+	// API serves the control api when its listen address is set
+	API API `json:"api" yaml:"api"`
+	// Webhooks receive the push events of registries and git forges at /api/v1/webhooks/{name}, keyed by name
+	Webhooks map[string]*Webhook `json:"webhooks" yaml:"webhooks"`
+
+	Image *imageConfig.Config `json:"image" yaml:"image"`
+	Git   *gitConfig.Config   `json:"git" yaml:"git"`
+}
+
+type API struct {
+	// Listen is the address of the api, e.g. :8080
+	Listen string `json:"listen" yaml:"listen"`
+	// Token is required as a bearer token by the api when set
+	Token configutil.Secret `json:"token" yaml:"token"`
+	// Metrics serves the prometheus metrics at /metrics, the token is required too when set
+	Metrics bool `json:"metrics" yaml:"metrics"`
+}
+
+// Webhook is a receiver of push events, the pushed image tags and git refs are synced at once
+type Webhook struct {
+	// Type is the sender of the events, one of distribution, harbor, github, gitlab and gitea
+	Type string `json:"type" yaml:"type"`
+	// Secret validates the events: the signing secret of github and gitea, the secret token of gitlab,
+	// or the Authorization header of distribution and harbor, with or without the Bearer prefix
+	Secret configutil.Secret `json:"secret" yaml:"secret"`
+	// Registry overrides the registry host of the image events, e.g. when the registry is behind a proxy
+	Registry string `json:"registry" yaml:"registry"`
+}
+
+func NewConfig() *Config {
+	config := &Config{
+		Schedules: make(map[string]map[string]string),
+		Webhooks:  make(map[string]*Webhook),
+		Image:     imageConfig.NewConfig(),
+		Git:       gitConfig.NewConfig(),
+	}
+	defaults.SetDefaults(config)
+	return config
+}
+
+func NewConfigFromFile(cf string) (*Config, error) {
+	config := NewConfig()
+	if err := configutil.NewConfigFromFile(cf, config); err != nil {
+		return nil, err
+	}
+	// 配置文件中为空的部分会被置为 nil
+	if config.Image == nil {
+		config.Image = imageConfig.NewConfig()
+	}
+	if config.Git == nil {
+		config.Git = gitConfig.NewConfig()
+	}
+	return config, nil
+}
+
+// GetSchedule returns the schedule of the mapping of source in domain
+func (c *Config) GetSchedule(domain, source string) string {
+	if s, ok := c.Schedules[domain][source]; ok && s != "" {
+		return s
+	}
+	return c.Schedule
+}
+
+// ParseSchedule parses a cron expression of 5 fields, a descriptor like @daily, or an interval like @every 10m or 10m
+func ParseSchedule(s string) (cron.Schedule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %s: interval should be positive", s)
+		}
+		return cron.Every(d), nil
+	}
+	schedule, err := cron.ParseStandard(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %s", s, err)
+	}
+	return schedule, nil
+}
@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewConfigFromFile(t *testing.T) {
+	cfg, err := NewConfigFromFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Image.Proc != 8 || len(cfg.Image.Images) != 1 || len(cfg.Git.Repos) != 2 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+	// 未配置的部分保留各自的默认值
+	if cfg.Git.StateDir != ".syncer" {
+		t.Errorf("StateDir = %s, want .syncer", cfg.Git.StateDir)
+	}
+	if got := cfg.GetSchedule(DomainGit, "git@github.com:MR5356/syncer.git"); got != "0 */6 * * *" {
+		t.Errorf("GetSchedule() = %s", got)
+	}
+	if got := cfg.GetSchedule(DomainGit, "git@github.com:MR5356/aurora.git"); got != "@every 30m" {
+		t.Errorf("GetSchedule() = %s", got)
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2024, 1, 1, 10, 7, 0, 0, time.Local)
+	tests := []struct {
+		schedule string
+		want     time.Time
+		wantErr  bool
+	}{
+		{schedule: "*/15 * * * *", want: time.Date(2024, 1, 1, 10, 15, 0, 0, time.Local)},
+		{schedule: "@daily", want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)},
+		{schedule: "@every 1h", want: from.Add(time.Hour)},
+		{schedule: "10m", want: from.Add(10 * time.Minute)},
+		{schedule: "", wantErr: true},
+		{schedule: "-10m", wantErr: true},
+		{schedule: "* * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			got, err := ParseSchedule(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if next := got.Next(from); !next.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", next, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,626 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gitClient "github.com/MR5356/syncer/pkg/domain/git/client"
+	gitConfig "github.com/MR5356/syncer/pkg/domain/git/config"
+	gitTask "github.com/MR5356/syncer/pkg/domain/git/task"
+	imageClient "github.com/MR5356/syncer/pkg/domain/image/client"
+	imageTask "github.com/MR5356/syncer/pkg/domain/image/task"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/notify"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRuns is the number of finished runs kept for inspection
+const maxRuns = 100
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrRunning  = errors.New("the mapping is already running")
+	ErrStopped  = errors.New("the daemon is stopped")
+	// ErrForbidden is returned for the ad-hoc syncs when the api has no token, since they run with the credentials
+	// of the config
+	ErrForbidden = errors.New("ad-hoc syncs require the token of the api")
+)
+
+// client is the common part of the image and git clients
+type client interface {
+	RunContext(ctx context.Context) error
+	Tasks() *task.List
+	Failed() *task.List
+	Err(t task.Task) error
+}
+
+// Mapping is a source of the image or git config with its destinations, synced on its own schedule
+type Mapping struct {
+	Domain   string
+	Source   string
+	Schedule string
+	// Destination is set for the ad-hoc mappings run through the api
+	Destination string
+	// Ref is set for the ad-hoc mappings of a git ref pushed to a webhook
+	Ref string
+
+	schedule  cron.Schedule
+	newClient func() client
+	entry     cron.EntryID
+
+	running atomic.Bool
+	lock    sync.Mutex
+	last    *Result
+	run     string
+	// again runs the mapping once more after the running run, set by the webhooks arriving meanwhile
+	again bool
+	// key is the key of an ad-hoc mapping in Daemon.adhoc
+	key string
+	// parent is the configured mapping of the source of an ad-hoc mapping, whose running guard the ad-hoc runs
+	// take, so that they never overlap the runs of the configured mapping
+	parent *Mapping
+}
+
+// guard returns the mapping whose running flag guards the runs of m
+func (m *Mapping) guard() *Mapping {
+	if m.parent != nil {
+		return m.parent
+	}
+	return m
+}
+
+func (m *Mapping) Name() string {
+	name := fmt.Sprintf("%s %s", m.Domain, configutil.RedactUrl(m.Source))
+	if m.Ref != "" {
+		name += " " + m.Ref
+	}
+	if m.Destination != "" {
+		name += " -> " + configutil.RedactUrl(m.Destination)
+	}
+	return name
+}
+
+// Result is the result of a run of a mapping
+type Result struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	// Error is the error of the run itself, like failing to generate the tasks
+	Error  string        `json:"error,omitempty"`
+	Total  int           `json:"total"`
+	Failed int           `json:"failed"`
+	Tasks  []*TaskResult `json:"tasks"`
+}
+
+type TaskResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+	// Destinations are the results of each destination of git tasks
+	Destinations []*DestinationResult `json:"destinations,omitempty"`
+}
+
+type DestinationResult struct {
+	Destination string   `json:"destination"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+	Withheld    []string `json:"withheld,omitempty"`
+}
+
+// Status is a snapshot of a mapping
+type Status struct {
+	Domain   string    `json:"domain"`
+	Source   string    `json:"source"`
+	Schedule string    `json:"schedule"`
+	Running  bool      `json:"running"`
+	Next     time.Time `json:"next"`
+	// Run is the id of the running or the last run
+	Run  string  `json:"run,omitempty"`
+	Last *Result `json:"last,omitempty"`
+}
+
+// Daemon runs each mapping of the config on its schedule, a mapping is never run twice at the same time
+type Daemon struct {
+	cfg      *Config
+	cron     *cron.Cron
+	mappings []*Mapping
+
+	lock    sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+	// runs holds the running runs and the last finished ones by id, order holds their ids in start order
+	runs   map[string]*Run
+	order  []string
+	nextId int
+	// adhoc holds the running ad-hoc mappings, so that a pair is not run twice at the same time
+	adhoc map[string]*Mapping
+}
+
+func New(cfg *Config) (*Daemon, error) {
+	d := &Daemon{
+		cfg:      cfg,
+		cron:     cron.New(),
+		mappings: make([]*Mapping, 0),
+		runs:     make(map[string]*Run),
+		order:    make([]string, 0),
+		adhoc:    make(map[string]*Mapping),
+	}
+
+	for source, value := range cfg.Image.Images {
+		m, err := d.imageMapping(source, value)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.add(m); err != nil {
+			return nil, err
+		}
+	}
+	for source, value := range cfg.Git.Repos {
+		m, err := d.gitMapping(source, value)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.add(m); err != nil {
+			return nil, err
+		}
+	}
+
+	// 无 token 的 API 可以读取日志、取消运行，只允许监听本机地址
+	if cfg.API.Listen != "" && cfg.API.Token.Value() == "" && !isLoopback(cfg.API.Listen) {
+		return nil, fmt.Errorf("the api listening on %s requires a token, or listen on a loopback address like 127.0.0.1:8080", cfg.API.Listen)
+	}
+	if len(cfg.Webhooks) > 0 && cfg.API.Listen == "" {
+		return nil, fmt.Errorf("webhooks require the listen address of the api")
+	}
+	for name, hook := range cfg.Webhooks {
+		if err := validateWebhook(name, hook); err != nil {
+			return nil, err
+		}
+	}
+	if err := notify.Validate(cfg.Image.Notifications); err != nil {
+		return nil, fmt.Errorf("invalid image notifications: %s", err)
+	}
+	if err := notify.Validate(cfg.Git.Notifications); err != nil {
+		return nil, fmt.Errorf("invalid git notifications: %s", err)
+	}
+
+	// 覆盖调度的映射必须存在，避免拼写错误的映射静默地使用默认调度
+	for domain, schedules := range cfg.Schedules {
+		if domain != DomainImage && domain != DomainGit {
+			return nil, fmt.Errorf("invalid schedules domain %s, should be %s or %s", domain, DomainImage, DomainGit)
+		}
+		for source := range schedules {
+			if d.mapping(domain, source) == nil {
+				return nil, fmt.Errorf("schedule of %s %s: mapping not found", domain, configutil.RedactUrl(source))
+			}
+		}
+	}
+
+	sort.Slice(d.mappings, func(i, j int) bool {
+		if d.mappings[i].Domain != d.mappings[j].Domain {
+			return d.mappings[i].Domain < d.mappings[j].Domain
+		}
+		return d.mappings[i].Source < d.mappings[j].Source
+	})
+	return d, nil
+}
+
+// isLoopback reports whether the listen address only accepts the connections of the local host
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// imageMapping returns the mapping syncing source to the destinations in value, which is a string or a list
+func (d *Daemon) imageMapping(source string, value any) (*Mapping, error) {
+	c := *d.cfg.Image
+	c.Images = map[string]any{source: value}
+	// 提前校验映射，生成镜像任务不会访问网络
+	if _, err := imageTask.GenerateSyncTaskList(&c, nil); err != nil {
+		return nil, err
+	}
+	return &Mapping{
+		Domain:    DomainImage,
+		Source:    source,
+		newClient: func() client { return imageClient.NewClient(&c) },
+	}, nil
+}
+
+// gitMapping returns the mapping syncing source as configured by value, see config.ParseRepo
+func (d *Daemon) gitMapping(source string, value any) (*Mapping, error) {
+	if _, err := gitConfig.ParseRepo(source, value); err != nil {
+		return nil, err
+	}
+	c := *d.cfg.Git
+	c.Repos = map[string]any{source: value}
+	return &Mapping{
+		Domain:    DomainGit,
+		Source:    source,
+		newClient: func() client { return gitClient.NewClient(&c) },
+	}, nil
+}
+
+func (d *Daemon) Config() *Config {
+	return d.cfg
+}
+
+func (d *Daemon) add(m *Mapping) error {
+	m.Schedule = d.cfg.GetSchedule(m.Domain, m.Source)
+	schedule, err := ParseSchedule(m.Schedule)
+	if err != nil {
+		return fmt.Errorf("schedule of %s: %s", m.Name(), err)
+	}
+	m.schedule = schedule
+	d.mappings = append(d.mappings, m)
+	return nil
+}
+
+func (d *Daemon) mapping(domain, source string) *Mapping {
+	for _, m := range d.mappings {
+		if m.Domain == domain && m.Source == source {
+			return m
+		}
+	}
+	return nil
+}
+
+// Start schedules the mappings, with runNow the mappings are also run immediately
+func (d *Daemon) Start(runNow bool) {
+	logrus.AddHook(&logHook{d: d})
+	for _, m := range d.mappings {
+		m := m
+		m.entry = d.cron.Schedule(m.schedule, cron.FuncJob(func() {
+			r, err := d.start(m, TriggerSchedule)
+			if err != nil {
+				logrus.Warnf("skip %s: %s", m.Name(), err)
+				return
+			}
+			d.execute(m, r)
+		}))
+	}
+	d.cron.Start()
+	logrus.Infof("daemon started with %d mappings", len(d.mappings))
+	for _, m := range d.mappings {
+		logrus.Infof("schedule %s: %s, next run at %s", m.Name(), m.Schedule, d.cron.Entry(m.entry).Next.Format(time.RFC3339))
+	}
+
+	if runNow {
+		for _, m := range d.mappings {
+			if _, err := d.Trigger(m.Domain, m.Source); err != nil {
+				logrus.Warnf("skip %s: %s", m.Name(), err)
+			}
+		}
+	}
+}
+
+// Stop stops scheduling the mappings and waits for the running ones to finish
+func (d *Daemon) Stop() {
+	d.lock.Lock()
+	d.stopped = true
+	d.lock.Unlock()
+
+	<-d.cron.Stop().Done()
+	d.wg.Wait()
+	logrus.Infof("daemon stopped")
+}
+
+// Mappings returns the status of every mapping
+func (d *Daemon) Mappings() []*Status {
+	res := make([]*Status, 0, len(d.mappings))
+	for _, m := range d.mappings {
+		m.lock.Lock()
+		res = append(res, &Status{
+			Domain:   m.Domain,
+			Source:   configutil.RedactUrl(m.Source),
+			Schedule: m.Schedule,
+			Running:  m.running.Load(),
+			Next:     d.cron.Entry(m.entry).Next,
+			Run:      m.run,
+			Last:     m.last,
+		})
+		m.lock.Unlock()
+	}
+	return res
+}
+
+// Trigger runs the mapping of source in domain now, unless it is already running
+func (d *Daemon) Trigger(domain, source string) (*Run, error) {
+	m := d.mapping(domain, source)
+	if m == nil {
+		return nil, fmt.Errorf("mapping %s %s: %w", domain, configutil.RedactUrl(source), ErrNotFound)
+	}
+	r, err := d.start(m, TriggerApi)
+	if err != nil {
+		return nil, err
+	}
+	go d.execute(m, r)
+	return r, nil
+}
+
+// SyncPair runs an ad-hoc sync of source to destination with the auth and settings of the domain config,
+// the pair is not run twice at the same time. It requires the token of the api, otherwise anyone reaching the api
+// could copy the sources readable by the config to any destination
+func (d *Daemon) SyncPair(domain, source, destination string) (*Run, error) {
+	if d.cfg.API.Token.Value() == "" {
+		return nil, ErrForbidden
+	}
+	var m *Mapping
+	var err error
+	switch domain {
+	case DomainImage:
+		m, err = d.imageMapping(source, destination)
+	case DomainGit:
+		m, err = d.gitMapping(source, destination)
+	default:
+		return nil, fmt.Errorf("invalid domain %s, should be %s or %s", domain, DomainImage, DomainGit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Destination = destination
+	m.parent = d.mapping(domain, source)
+
+	m = d.register(domain+"\x00"+source+"\x00"+destination, m)
+	r, err := d.start(m, TriggerApi)
+	if err != nil {
+		return nil, err
+	}
+	go d.execute(m, r)
+	return r, nil
+}
+
+// register returns the ad-hoc mapping of key if it is running, otherwise m is registered for key
+func (d *Daemon) register(key string, m *Mapping) *Mapping {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if running, ok := d.adhoc[key]; ok {
+		return running
+	}
+	m.key = key
+	d.adhoc[key] = m
+	return m
+}
+
+// enqueue runs the mapping for a webhook. When the mapping or its configured mapping is running, the configured
+// mapping is run once more after the running run so that the pushed change is not missed, and the returned run
+// is nil
+func (d *Daemon) enqueue(m *Mapping) (*Run, error) {
+	g := m.guard()
+	for {
+		r, err := d.start(m, TriggerWebhook)
+		if err == nil {
+			go d.execute(m, r)
+			return r, nil
+		}
+		if !errors.Is(err, ErrRunning) {
+			return nil, err
+		}
+		// execute 在同一把锁中清除运行状态，因此运行中的映射一定会看到 again
+		g.lock.Lock()
+		if g.running.Load() {
+			g.again = true
+			g.lock.Unlock()
+			return nil, nil
+		}
+		g.lock.Unlock()
+	}
+}
+
+// Runs returns the running runs and the last finished ones in start order
+func (d *Daemon) Runs() []RunInfo {
+	d.lock.Lock()
+	runs := make([]*Run, 0, len(d.order))
+	for _, id := range d.order {
+		runs = append(runs, d.runs[id])
+	}
+	d.lock.Unlock()
+
+	res := make([]RunInfo, 0, len(runs))
+	for _, r := range runs {
+		res = append(res, r.Info())
+	}
+	return res
+}
+
+// Run returns the run of id, nil if it is unknown or has been dropped from the history
+func (d *Daemon) Run(id string) *Run {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.runs[id]
+}
+
+// start registers a run of the mapping unless the mapping is running or the daemon is stopped, the caller
+// executes it. Nothing is logged while holding the lock, since the log hook takes it too.
+func (d *Daemon) start(m *Mapping, trigger string) (*Run, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopped {
+		return nil, ErrStopped
+	}
+	g := m.guard()
+	if !g.running.CompareAndSwap(false, true) {
+		return nil, ErrRunning
+	}
+	if g != m {
+		m.running.Store(true)
+	}
+
+	d.nextId++
+	r := newRun(strconv.Itoa(d.nextId), m, trigger)
+	r.ctx = task.WithLogger(r.ctx, logrus.WithField(runField, r.info.Id))
+	d.runs[r.info.Id] = r
+	d.order = append(d.order, r.info.Id)
+	d.wg.Add(1)
+
+	m.lock.Lock()
+	m.run = r.info.Id
+	m.lock.Unlock()
+	return r, nil
+}
+
+func (d *Daemon) execute(m *Mapping, r *Run) {
+	defer d.wg.Done()
+
+	log := task.Logger(r.ctx)
+	log.Infof("start %s", m.Name())
+	info := r.Info()
+	ctx, span := tracing.Start(r.ctx, "daemon.run", attribute.String("run", info.Id), attribute.String("mapping", m.Name()),
+		attribute.String("trigger", info.Trigger))
+	res := m.sync(ctx)
+	span.SetAttributes(attribute.Int("tasks", res.Total), attribute.Int("failed", res.Failed))
+	tracing.End(span, res.err())
+	// 临时映射不记录最后成功时间，避免每个推送的标签、临时同步都新增指标
+	if m.key == "" {
+		metrics.ObserveMapping(m.Domain, m.Source, res.err())
+	}
+	m.lock.Lock()
+	m.last = res
+	m.lock.Unlock()
+
+	switch {
+	case r.ctx.Err() != nil:
+		log.Warnf("run %s canceled, %d/%d task failed, cost %s", m.Name(), res.Failed, res.Total, res.Duration)
+	case res.Error != "":
+		log.Errorf("run %s failed: %s", m.Name(), res.Error)
+	default:
+		log.Infof("run %s finished, %d/%d task failed, cost %s", m.Name(), res.Failed, res.Total, res.Duration)
+	}
+	r.finish(res)
+	// 通知在映射结束运行前发送，同一映射的通知按运行的顺序发送
+	d.notify(m, info, res)
+	g := m.guard()
+	g.lock.Lock()
+	again := g.again
+	g.again = false
+	m.running.Store(false)
+	g.running.Store(false)
+	g.lock.Unlock()
+	d.trim()
+
+	if m.key != "" {
+		d.lock.Lock()
+		if !m.running.Load() && d.adhoc[m.key] == m {
+			delete(d.adhoc, m.key)
+		}
+		d.lock.Unlock()
+	}
+	// 运行期间收到的 webhook 再运行一次配置的映射
+	if again {
+		if next, err := d.start(g, TriggerWebhook); err == nil {
+			d.execute(g, next)
+		}
+	}
+}
+
+// notify posts the result of the run to the notifications of the domain of the mapping
+func (d *Daemon) notify(m *Mapping, info RunInfo, res *Result) {
+	ns, stateDir := d.cfg.Image.Notifications, d.cfg.Image.StateDir
+	if m.Domain == DomainGit {
+		ns, stateDir = d.cfg.Git.Notifications, d.cfg.Git.StateDir
+	}
+	if len(ns) == 0 {
+		return
+	}
+	s := &notify.Summary{
+		Domain:   m.Domain,
+		Name:     m.Name(),
+		Run:      info.Id,
+		Trigger:  info.Trigger,
+		Error:    res.Error,
+		Total:    res.Total,
+		Failures: make([]*notify.Failure, 0),
+		Start:    res.Start,
+		End:      res.End,
+	}
+	for _, t := range res.Tasks {
+		if t.Error != "" {
+			s.Failures = append(s.Failures, &notify.Failure{Task: t.Name, Error: t.Error})
+		}
+	}
+	s.Complete()
+	notify.Send(ns, s, stateDir)
+}
+
+// trim drops the oldest finished runs exceeding maxRuns
+func (d *Daemon) trim() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	finished := 0
+	for _, id := range d.order {
+		if d.runs[id].Done() {
+			finished++
+		}
+	}
+	order := make([]string, 0, len(d.order))
+	for _, id := range d.order {
+		if finished > maxRuns && d.runs[id].Done() {
+			delete(d.runs, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	d.order = order
+}
+
+// err returns the error of the result, a run with failed tasks is failed
+func (r *Result) err() error {
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	if r.Failed > 0 {
+		return fmt.Errorf("%d/%d task failed", r.Failed, r.Total)
+	}
+	return nil
+}
+
+// sync runs the tasks of the mapping and collects their results
+func (m *Mapping) sync(ctx context.Context) *Result {
+	res := &Result{Start: time.Now(), Tasks: make([]*TaskResult, 0)}
+	c := m.newClient()
+	if err := c.RunContext(ctx); err != nil {
+		res.Error = err.Error()
+	}
+	res.End = time.Now()
+	res.Duration = res.End.Sub(res.Start).String()
+
+	for t := range c.Tasks().Iterator() {
+		tr := &TaskResult{Name: t.Name()}
+		if err := c.Err(t); err != nil {
+			tr.Error = err.Error()
+		}
+		if rt, ok := t.(interface{ Results() []*gitTask.Result }); ok {
+			for _, r := range rt.Results() {
+				dr := &DestinationResult{Destination: r.Destination, Status: r.Status, Withheld: r.Withheld}
+				if r.Error != nil {
+					dr.Error = r.Error.Error()
+				}
+				tr.Destinations = append(tr.Destinations, dr)
+			}
+		}
+		res.Tasks = append(res.Tasks, tr)
+	}
+	sort.Slice(res.Tasks, func(i, j int) bool {
+		return res.Tasks[i].Name < res.Tasks[j].Name
+	})
+	res.Total = len(res.Tasks)
+	res.Failed = c.Failed().Length()
+	return res
+}
@@ -0,0 +1,308 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/notify"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTask struct {
+	name string
+	err  error
+}
+
+func (t *fakeTask) Name() string { return t.name }
+
+func (t *fakeTask) Run() error { return t.err }
+
+// fakeClient runs its tasks once release is closed, or fails them when ctx is canceled first
+type fakeClient struct {
+	release chan struct{}
+	tasks   *task.List
+	failed  *task.List
+	errs    sync.Map
+}
+
+func (c *fakeClient) RunContext(ctx context.Context) error {
+	task.Logger(ctx).Infof("waiting for release")
+	select {
+	case <-c.release:
+	case <-ctx.Done():
+	}
+	for t := range c.tasks.Iterator() {
+		err := ctx.Err()
+		if err == nil {
+			err = t.Run()
+		}
+		if err != nil {
+			task.Logger(ctx).Errorf("run %s failed: %s", t.Name(), err)
+			c.failed.Add(t)
+			c.errs.Store(t.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) Tasks() *task.List { return c.tasks }
+
+func (c *fakeClient) Failed() *task.List { return c.failed }
+
+func (c *fakeClient) Err(t task.Task) error {
+	if err, ok := c.errs.Load(t.Name()); ok {
+		return err.(error)
+	}
+	return nil
+}
+
+// newTestDaemon starts a daemon of testdata/config.yaml whose mappings run fake clients blocking on release
+func newTestDaemon(t *testing.T, release chan struct{}) *Daemon {
+	cfg, err := NewConfigFromFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range d.mappings {
+		m := m
+		m.newClient = func() client {
+			c := &fakeClient{release: release, tasks: task.NewTaskList(), failed: task.NewTaskList()}
+			c.tasks.Add(&fakeTask{name: m.Source + " -> a"})
+			c.tasks.Add(&fakeTask{name: m.Source + " -> b", err: errors.New("unauthorized")})
+			return c
+		}
+	}
+	d.Start(false)
+	return d
+}
+
+func waitRun(t *testing.T, r *Run) RunInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// 日志在运行结束时关闭
+	if err := r.Log(ctx, new(strings.Builder), true); err != nil {
+		t.Fatalf("wait for run %s: %s", r.Info().Id, err)
+	}
+	return r.Info()
+}
+
+func TestDaemon_Trigger(t *testing.T) {
+	release := make(chan struct{})
+	d := newTestDaemon(t, release)
+
+	r, err := d.Trigger(DomainImage, "nginx:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 上一次运行尚未结束，不会重复运行
+	if _, err := d.Trigger(DomainImage, "nginx:latest"); !errors.Is(err, ErrRunning) {
+		t.Errorf("overlapping run error = %v, want %v", err, ErrRunning)
+	}
+	if _, err := d.Trigger(DomainImage, "busybox:latest"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown mapping error = %v, want %v", err, ErrNotFound)
+	}
+	close(release)
+
+	info := waitRun(t, r)
+	if info.State != RunFailed || info.Trigger != TriggerApi {
+		t.Errorf("unexpected run: %+v", info)
+	}
+	status := d.Mappings()[2]
+	if status.Source != "nginx:latest" || status.Run != info.Id || status.Running {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	last := status.Last
+	if last == nil || last.Total != 2 || last.Failed != 1 {
+		t.Fatalf("unexpected last result: %+v", last)
+	}
+	if last.Tasks[1].Error != "unauthorized" || last.Tasks[0].Error != "" {
+		t.Errorf("unexpected task results: %+v %+v", last.Tasks[0], last.Tasks[1])
+	}
+
+	log := new(strings.Builder)
+	_ = r.Log(context.Background(), log, false)
+	for _, want := range []string{"[INFO] start image nginx:latest", "[INFO] waiting for release", "[ERROR] run nginx:latest -> b failed: unauthorized"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, log)
+		}
+	}
+
+	d.Stop()
+	if _, err := d.Trigger(DomainImage, "nginx:latest"); !errors.Is(err, ErrStopped) {
+		t.Errorf("run after stop error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestDaemon_Cancel(t *testing.T) {
+	d := newTestDaemon(t, make(chan struct{}))
+	defer d.Stop()
+
+	// 没有 token 时拒绝临时同步
+	if _, err := d.SyncPair(DomainGit, "git@github.com:MR5356/syncer.git", "git@attacker.com:MR5356/syncer.git"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("pair without token error = %v, want %v", err, ErrForbidden)
+	}
+	d.cfg.API.Token = "token"
+
+	// 临时同步使用真实的客户端，git 配置的 proc 为 0，任务在取消前不会开始
+	r, err := d.SyncPair(DomainGit, "git@github.com:MR5356/syncer.git", "git@test.com:MR5356/syncer.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.SyncPair(DomainGit, "git@github.com:MR5356/syncer.git", "git@test.com:MR5356/syncer.git"); !errors.Is(err, ErrRunning) {
+		t.Errorf("overlapping pair error = %v, want %v", err, ErrRunning)
+	}
+	r.Cancel()
+	info := waitRun(t, r)
+	if info.State != RunCanceled || info.Destination != "git@test.com:MR5356/syncer.git" {
+		t.Errorf("unexpected run: %+v", info)
+	}
+	if info.Result.Failed != 1 || info.Result.Tasks[0].Error != context.Canceled.Error() {
+		t.Errorf("unexpected result: %+v", info.Result)
+	}
+	if len(d.Runs()) != 1 {
+		t.Errorf("Runs() = %+v", d.Runs())
+	}
+
+	if _, err := d.SyncPair(DomainImage, "nginx:latest", ""); err == nil {
+		t.Errorf("empty destination accepted")
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg, err := NewConfigFromFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.mappings) != 3 || d.mappings[0].Domain != DomainGit || d.mappings[2].Domain != DomainImage {
+		t.Fatalf("unexpected mappings: %+v", d.mappings)
+	}
+
+	cfg.Webhooks = map[string]*Webhook{"harbor": {Type: WebhookHarbor, Secret: "secret"}}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("webhooks without api accepted")
+	}
+	cfg.API.Listen = ":8080"
+	if _, err := New(cfg); err == nil {
+		t.Errorf("api on all addresses without token accepted")
+	}
+	for _, listen := range []string{"127.0.0.1:8080", "localhost:8080", "[::1]:8080"} {
+		cfg.API.Listen = listen
+		if _, err := New(cfg); err != nil {
+			t.Errorf("api on %s without token: %v", listen, err)
+		}
+	}
+	cfg.API.Listen = ":8080"
+	cfg.API.Token = "token"
+	if _, err := New(cfg); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+	cfg.Webhooks["harbor"].Type = "svn"
+	if _, err := New(cfg); err == nil {
+		t.Errorf("invalid webhook type accepted")
+	}
+	cfg.Webhooks = nil
+
+	cfg.Schedules[DomainImage] = map[string]string{"busybox:latest": "@hourly"}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("schedule of unknown mapping accepted")
+	}
+	cfg.Schedules[DomainImage] = map[string]string{"nginx:latest": "every hour"}
+	if _, err := New(cfg); err == nil {
+		t.Errorf("invalid schedule accepted")
+	}
+}
+
+func TestDaemon_Notify(t *testing.T) {
+	received := make(chan *notify.Summary, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := new(notify.Summary)
+		_ = json.NewDecoder(r.Body).Decode(s)
+		received <- s
+	}))
+	defer server.Close()
+
+	release := make(chan struct{})
+	d := newTestDaemon(t, release)
+	defer d.Stop()
+	d.cfg.Image.Notifications = []*notify.Notification{{URL: configutil.Secret(server.URL), On: notify.OnFailure}}
+	d.cfg.Image.StateDir = t.TempDir()
+
+	r, err := d.Trigger(DomainImage, "nginx:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	info := waitRun(t, r)
+
+	select {
+	case s := <-received:
+		if s.Run != info.Id || s.Trigger != TriggerApi || s.Status != notify.StatusFailed || s.Total != 2 || s.Succeeded != 1 {
+			t.Errorf("unexpected summary: %+v", s)
+		}
+		if len(s.Failures) != 1 || s.Failures[0].Task != "nginx:latest -> b" || s.Failures[0].Error != "unauthorized" {
+			t.Errorf("unexpected failures: %+v", s.Failures)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("notification is not sent")
+	}
+}
+
+func TestDaemon_Metrics(t *testing.T) {
+	release := make(chan struct{})
+	d := newTestDaemon(t, release)
+	defer d.Stop()
+	d.cfg.API.Token = "token"
+	m := d.mapping(DomainImage, "nginx:latest")
+	m.newClient = func() client {
+		c := &fakeClient{release: release, tasks: task.NewTaskList(), failed: task.NewTaskList()}
+		c.tasks.Add(&fakeTask{name: "nginx:latest -> test/nginx:latest"})
+		return c
+	}
+	close(release)
+
+	r, err := d.Trigger(DomainImage, "nginx:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, r)
+	// 临时同步使用真实的客户端，不会访问网络；取消后结束
+	r, err = d.SyncPair(DomainGit, "git@github.com:MR5356/other.git", "git@test.com:MR5356/other.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Cancel()
+	waitRun(t, r)
+
+	// 最后成功时间按配置的映射记录，临时同步不新增指标
+	file := filepath.Join(t.TempDir(), "syncer.prom")
+	if err := metrics.WriteTextfile(file); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `syncer_last_success_timestamp_seconds{domain="image",mapping="nginx:latest"}`) {
+		t.Errorf("last success of the mapping is not recorded:\n%s", content)
+	}
+	if strings.Contains(string(content), "other.git") || strings.Contains(string(content), "test/nginx") {
+		t.Errorf("last success is recorded by task or for the ad-hoc sync:\n%s", content)
+	}
+}
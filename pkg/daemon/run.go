@@ -0,0 +1,194 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunCanceled  = "canceled"
+
+	TriggerSchedule = "schedule"
+	TriggerApi      = "api"
+	TriggerWebhook  = "webhook"
+
+	// maxLogSize limits the log kept of a run, later entries are dropped
+	maxLogSize = 4 << 20
+	// runField is the log field carrying the id of the run, the entries with it are captured to the log of the run
+	runField = "run"
+)
+
+// RunInfo is a snapshot of a run
+type RunInfo struct {
+	Id     string `json:"id"`
+	Domain string `json:"domain"`
+	Source string `json:"source"`
+	// Destination is set for ad-hoc runs of a source and destination pair not configured as a mapping
+	Destination string `json:"destination,omitempty"`
+	// Ref is set for the runs of a git ref pushed to a webhook
+	Ref     string    `json:"ref,omitempty"`
+	Trigger string    `json:"trigger"`
+	State   string    `json:"state"`
+	Start   time.Time `json:"start"`
+	Result  *Result   `json:"result,omitempty"`
+}
+
+// Run is a run of a mapping, it can be canceled and its log can be read while it is running
+type Run struct {
+	lock sync.Mutex
+	info RunInfo
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *runLog
+}
+
+func newRun(id string, m *Mapping, trigger string) *Run {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Run{
+		info: RunInfo{
+			Id:          id,
+			Domain:      m.Domain,
+			Source:      m.Source,
+			Destination: m.Destination,
+			Ref:         m.Ref,
+			Trigger:     trigger,
+			State:       RunRunning,
+			Start:       time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		log:    newRunLog(),
+	}
+}
+
+func (r *Run) Info() RunInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.info
+}
+
+func (r *Run) Done() bool {
+	return r.Info().State != RunRunning
+}
+
+// Cancel stops the tasks of the run, the run finishes as canceled once the running tasks return
+func (r *Run) Cancel() {
+	r.cancel()
+}
+
+func (r *Run) finish(res *Result) {
+	r.lock.Lock()
+	r.info.Result = res
+	switch {
+	case r.ctx.Err() != nil:
+		r.info.State = RunCanceled
+	case res.Error != "" || res.Failed > 0:
+		r.info.State = RunFailed
+	default:
+		r.info.State = RunSucceeded
+	}
+	r.lock.Unlock()
+	r.cancel()
+	r.log.close()
+}
+
+// Log writes the log of the run to w, with follow it keeps writing until the run finishes or ctx is canceled
+func (r *Run) Log(ctx context.Context, w io.Writer, follow bool) error {
+	return r.log.copy(ctx, w, follow)
+}
+
+// runLog is an append only log read by any number of followers
+type runLog struct {
+	lock    sync.Mutex
+	buf     []byte
+	closed  bool
+	dropped bool
+	// changed is closed and replaced on every write
+	changed chan struct{}
+}
+
+func newRunLog() *runLog {
+	return &runLog{changed: make(chan struct{})}
+}
+
+func (l *runLog) write(p []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed || l.dropped {
+		return
+	}
+	if len(l.buf)+len(p) > maxLogSize {
+		l.dropped = true
+		p = []byte(fmt.Sprintf("log exceeds %d bytes, the later entries are dropped\n", maxLogSize))
+	}
+	l.buf = append(l.buf, p...)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *runLog) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return
+	}
+	l.closed = true
+	close(l.changed)
+}
+
+func (l *runLog) copy(ctx context.Context, w io.Writer, follow bool) error {
+	offset := 0
+	for {
+		l.lock.Lock()
+		chunk, closed, changed := l.buf[offset:], l.closed, l.changed
+		l.lock.Unlock()
+
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				return err
+			}
+			if f, ok := w.(interface{ Flush() }); ok {
+				f.Flush()
+			}
+			offset += len(chunk)
+			continue
+		}
+		if closed || !follow {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// logHook captures the log entries of the runs of a daemon
+type logHook struct {
+	d *Daemon
+}
+
+func (h *logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logHook) Fire(e *logrus.Entry) error {
+	id, ok := e.Data[runField].(string)
+	if !ok {
+		return nil
+	}
+	if r := h.d.Run(id); r != nil {
+		r.log.write([]byte(fmt.Sprintf("%s [%s] %s\n", e.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(e.Level.String()), strings.TrimSpace(e.Message))))
+	}
+	return nil
+}
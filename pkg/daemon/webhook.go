@@ -0,0 +1,473 @@
+package daemon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gitConfig "github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"github.com/MR5356/syncer/pkg/utils/imageutil"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	WebhookDistribution = "distribution"
+	WebhookHarbor       = "harbor"
+	WebhookGithub       = "github"
+	WebhookGitlab       = "gitlab"
+	WebhookGitea        = "gitea"
+
+	// maxWebhookSize is the largest payload accepted, the same as the limit of github
+	maxWebhookSize = 25 << 20
+)
+
+// WebhookResult lists the runs started for the events of a webhook, and the mappings which were running and
+// run again once they finish
+type WebhookResult struct {
+	Runs   []RunInfo `json:"runs"`
+	Queued []string  `json:"queued"`
+}
+
+// pushEvent is a pushed image tag or git ref
+type pushEvent struct {
+	domain string
+	// image is the repository of an image like registry.example.com/library/nginx
+	image string
+	// urls are the urls of a git repository
+	urls []string
+	// ref is the tag of an image or the branch or tag of a git repository
+	ref string
+}
+
+func validateWebhook(name string, hook *Webhook) error {
+	if hook == nil {
+		return fmt.Errorf("empty webhook %s", name)
+	}
+	switch hook.Type {
+	case WebhookDistribution, WebhookHarbor, WebhookGithub, WebhookGitlab, WebhookGitea:
+	default:
+		return fmt.Errorf("invalid type %s of webhook %s, should be one of %s", hook.Type, name,
+			strings.Join([]string{WebhookDistribution, WebhookHarbor, WebhookGithub, WebhookGitlab, WebhookGitea}, ", "))
+	}
+	if hook.Secret.Value() == "" {
+		return fmt.Errorf("empty secret of webhook %s", name)
+	}
+	return nil
+}
+
+// Webhook syncs the image tags and git refs pushed in the events received by the webhook of name
+func (d *Daemon) Webhook(name string, r *http.Request) (*WebhookResult, error) {
+	hook, ok := d.cfg.Webhooks[name]
+	if !ok {
+		return nil, fmt.Errorf("webhook %s: %w", name, ErrNotFound)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read payload failed: %w", err)
+	}
+	if !hook.validate(r.Header, body) {
+		return nil, errUnauthorized
+	}
+	events, err := hook.parse(r.Header, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+
+	res := &WebhookResult{Runs: make([]RunInfo, 0), Queued: make([]string, 0)}
+	for _, e := range events {
+		logrus.Infof("webhook %s: push of %s %s", name, e.repo(), e.ref)
+		for _, m := range d.match(e) {
+			run, err := d.enqueue(m)
+			switch {
+			case err != nil:
+				logrus.Warnf("webhook %s: skip %s: %s", name, m.Name(), err)
+			case run == nil:
+				logrus.Infof("webhook %s: %s is running, run %s again once it finishes", name, m.Name(), m.guard().Name())
+				res.Queued = append(res.Queued, m.guard().Name())
+			default:
+				res.Runs = append(res.Runs, run.Info())
+			}
+		}
+	}
+	return res, nil
+}
+
+func (e *pushEvent) repo() string {
+	if e.domain == DomainImage {
+		return e.image
+	}
+	return configutil.RedactUrl(e.urls[0])
+}
+
+// match returns the mappings syncing the pushed tag or ref: the mappings of a single image tag and the ones
+// whose options do not allow syncing a single git ref are run as a whole, the others are narrowed to an
+// ad-hoc mapping of the tag or ref, which never runs at the same time as the configured mapping
+func (d *Daemon) match(e *pushEvent) []*Mapping {
+	res := make([]*Mapping, 0)
+	switch e.domain {
+	case DomainImage:
+		info, _ := imageutil.ParseImageInfo(e.image)
+		for _, source := range sortedKeys(d.cfg.Image.Images) {
+			src, _ := imageutil.ParseImageInfo(source)
+			if !strings.EqualFold(src.Registry, info.Registry) || src.GetRepo() != info.GetRepo() {
+				continue
+			}
+			if src.TagOrDigest != "" {
+				if !strings.Contains(source, "@") && src.TagOrDigest == e.ref {
+					res = append(res, d.mapping(DomainImage, source))
+				}
+				continue
+			}
+			if m := d.imageTagMapping(source, e.ref); m != nil {
+				res = append(res, m)
+			}
+		}
+	case DomainGit:
+		for _, source := range sortedKeys(d.cfg.Git.Repos) {
+			if !matchGitSource(source, e.urls) {
+				continue
+			}
+			value := d.cfg.Git.Repos[source]
+			repo, err := gitConfig.ParseRepo(source, value)
+			switch {
+			case err != nil:
+				continue
+			case repo.Ref != "":
+				if repo.Ref == e.ref {
+					res = append(res, d.mapping(DomainGit, source))
+				}
+			case forge.IsSource(source), repo.Bidirectional, repo.SingleBranch:
+				res = append(res, d.mapping(DomainGit, source))
+			default:
+				if m := d.gitRefMapping(source, value, e.ref); m != nil {
+					res = append(res, m)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// imageTagMapping returns the ad-hoc mapping syncing a tag of a source without tag to its destinations
+func (d *Daemon) imageTagMapping(source, tag string) *Mapping {
+	dests := make([]any, 0)
+	switch v := d.cfg.Image.Images[source].(type) {
+	case string:
+		dests = append(dests, v)
+	case []any:
+		dests = append(dests, v...)
+	}
+	names := make([]string, 0, len(dests))
+	for i, dest := range dests {
+		s, _ := dest.(string)
+		info, _ := imageutil.ParseImageInfo(s)
+		dests[i] = fmt.Sprintf("%s/%s:%s", info.Registry, info.GetRepo(), tag)
+		names = append(names, dests[i].(string))
+	}
+
+	m, err := d.imageMapping(fmt.Sprintf("%s:%s", source, tag), dests)
+	if err != nil {
+		logrus.Warnf("skip tag %s of %s: %s", tag, source, err)
+		return nil
+	}
+	m.Destination = strings.Join(names, ", ")
+	m.parent = d.mapping(DomainImage, source)
+	return d.register(DomainImage+"\x00"+m.Source, m)
+}
+
+// gitRefMapping returns the ad-hoc mapping syncing a ref of source, with the other options of the mapping
+func (d *Daemon) gitRefMapping(source string, value any, ref string) *Mapping {
+	opts := map[string]any{"ref": ref}
+	switch v := value.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if key != "ref" {
+				opts[key] = val
+			}
+		}
+	default:
+		opts["destinations"] = v
+	}
+
+	m, err := d.gitMapping(source, opts)
+	if err != nil {
+		logrus.Warnf("skip %s of %s: %s", ref, configutil.RedactUrl(source), err)
+		return nil
+	}
+	m.Ref = ref
+	m.parent = d.mapping(DomainGit, source)
+	return d.register(DomainGit+"\x00"+source+"\x00"+ref, m)
+}
+
+// matchGitSource reports whether the source of a mapping is one of the urls, the urls match regardless of
+// the protocol, credentials and .git suffix. A forge source matches the repositories it selects by name.
+func matchGitSource(source string, urls []string) bool {
+	var src *forge.Source
+	var host, repoPath string
+	if forge.IsSource(source) {
+		var err error
+		if src, err = forge.ParseSource(source); err != nil {
+			return false
+		}
+		host = src.Host
+		if host == "" {
+			host = forge.DefaultHost(src.Type)
+		}
+	} else {
+		var ok bool
+		if host, repoPath, ok = splitGitUrl(source); !ok {
+			return false
+		}
+	}
+
+	for _, u := range urls {
+		h, p, ok := splitGitUrl(u)
+		if !ok || !strings.EqualFold(h, host) {
+			continue
+		}
+		switch {
+		case src == nil:
+			if strings.EqualFold(p, repoPath) {
+				return true
+			}
+		case src.Recursive():
+			if strings.HasPrefix(strings.ToLower(p), strings.ToLower(src.Owner)+"/") {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(src.Pattern, path.Base(p)); ok && strings.EqualFold(path.Dir(p), src.Owner) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// splitGitUrl returns the host and the repository path without .git of a remote git url
+func splitGitUrl(raw string) (string, string, bool) {
+	u, err := gitutil.ParseUrl(raw)
+	if err != nil || u.Host == "" {
+		return "", "", false
+	}
+	return u.Host, strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), true
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// validate checks the signature or the secret token of the payload
+func (h *Webhook) validate(header http.Header, body []byte) bool {
+	secret := h.Secret.Value()
+	switch h.Type {
+	case WebhookGithub:
+		sig, ok := strings.CutPrefix(header.Get("X-Hub-Signature-256"), "sha256=")
+		return ok && validHmac(secret, body, sig)
+	case WebhookGitea:
+		return validHmac(secret, body, header.Get("X-Gitea-Signature"))
+	case WebhookGitlab:
+		return equalSecret(header.Get("X-Gitlab-Token"), secret)
+	default:
+		auth := header.Get("Authorization")
+		return equalSecret(auth, secret) || equalSecret(auth, "Bearer "+secret)
+	}
+}
+
+func validHmac(secret string, body []byte, sig string) bool {
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func equalSecret(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// parse returns the pushes of the payload, the other events like pulls, pings and deletions are ignored
+func (h *Webhook) parse(header http.Header, body []byte) ([]*pushEvent, error) {
+	switch h.Type {
+	case WebhookDistribution:
+		return h.parseDistribution(body)
+	case WebhookHarbor:
+		return h.parseHarbor(body)
+	case WebhookGithub:
+		if header.Get("X-GitHub-Event") != "push" {
+			return nil, nil
+		}
+		// 以表单形式发送时负载位于 payload 字段
+		if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return nil, err
+			}
+			body = []byte(form.Get("payload"))
+		}
+		return parseGitPush(body)
+	case WebhookGitea:
+		if header.Get("X-Gitea-Event") != "push" {
+			return nil, nil
+		}
+		return parseGitPush(body)
+	case WebhookGitlab:
+		switch header.Get("X-Gitlab-Event") {
+		case "Push Hook", "Tag Push Hook":
+			return parseGitPush(body)
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown webhook type %s", h.Type)
+}
+
+// distributionEnvelope is the notification of docker distribution, see
+// https://distribution.github.io/distribution/about/notifications/
+type distributionEnvelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+			Url        string `json:"url"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+func (h *Webhook) parseDistribution(body []byte) ([]*pushEvent, error) {
+	envelope := new(distributionEnvelope)
+	if err := json.Unmarshal(body, envelope); err != nil {
+		return nil, err
+	}
+	res := make([]*pushEvent, 0)
+	for _, e := range envelope.Events {
+		// 推送 blob 与按 digest 推送的清单没有标签
+		if e.Action != "push" || e.Target.Tag == "" || e.Target.Repository == "" {
+			continue
+		}
+		host := h.Registry
+		if host == "" {
+			host = e.Request.Host
+		}
+		if host == "" {
+			if u, err := url.Parse(e.Target.Url); err == nil {
+				host = u.Host
+			}
+		}
+		if host == "" {
+			return nil, fmt.Errorf("unknown registry of %s, set the registry of the webhook", e.Target.Repository)
+		}
+		res = append(res, &pushEvent{domain: DomainImage, image: host + "/" + e.Target.Repository, ref: e.Target.Tag})
+	}
+	return res, nil
+}
+
+// harborPayload is the http webhook payload of harbor
+type harborPayload struct {
+	Type      string `json:"type"`
+	EventData struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			ResourceUrl string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+func (h *Webhook) parseHarbor(body []byte) ([]*pushEvent, error) {
+	payload := new(harborPayload)
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, err
+	}
+	// 旧版本的推送事件类型为 pushImage
+	if payload.Type != "PUSH_ARTIFACT" && payload.Type != "pushImage" {
+		return nil, nil
+	}
+	repo := payload.EventData.Repository.RepoFullName
+	res := make([]*pushEvent, 0)
+	for _, r := range payload.EventData.Resources {
+		if r.Tag == "" || repo == "" {
+			continue
+		}
+		host := h.Registry
+		if host == "" {
+			host, _, _ = strings.Cut(r.ResourceUrl, "/")
+		}
+		if host == "" {
+			return nil, fmt.Errorf("unknown registry of %s, set the registry of the webhook", repo)
+		}
+		res = append(res, &pushEvent{domain: DomainImage, image: host + "/" + repo, ref: r.Tag})
+	}
+	return res, nil
+}
+
+// gitPush is the push payload of github, gitea and gitlab
+type gitPush struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+	// Repository is sent by github and gitea
+	Repository struct {
+		CloneUrl string `json:"clone_url"`
+		SshUrl   string `json:"ssh_url"`
+		HtmlUrl  string `json:"html_url"`
+	} `json:"repository"`
+	// Project is sent by gitlab
+	Project struct {
+		GitHttpUrl string `json:"git_http_url"`
+		GitSshUrl  string `json:"git_ssh_url"`
+		WebUrl     string `json:"web_url"`
+	} `json:"project"`
+}
+
+func parseGitPush(body []byte) ([]*pushEvent, error) {
+	push := new(gitPush)
+	if err := json.Unmarshal(body, push); err != nil {
+		return nil, err
+	}
+	// 删除引用的推送不会被镜像
+	if push.Deleted || (push.After != "" && strings.Trim(push.After, "0") == "") {
+		return nil, nil
+	}
+	if !strings.HasPrefix(push.Ref, "refs/heads/") && !strings.HasPrefix(push.Ref, "refs/tags/") {
+		return nil, nil
+	}
+	urls := make([]string, 0)
+	for _, u := range []string{
+		push.Repository.CloneUrl, push.Repository.SshUrl, push.Repository.HtmlUrl,
+		push.Project.GitHttpUrl, push.Project.GitSshUrl, push.Project.WebUrl,
+	} {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("no repository url")
+	}
+	return []*pushEvent{{domain: DomainGit, urls: urls, ref: push.Ref}}, nil
+}
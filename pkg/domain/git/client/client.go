@@ -1,21 +1,36 @@
 package client
 
 import (
+	"context"
+	"fmt"
 	"github.com/MR5356/syncer/pkg/domain/git/config"
 	task2 "github.com/MR5356/syncer/pkg/domain/git/task"
+	log2 "github.com/MR5356/syncer/pkg/log"
+	"github.com/MR5356/syncer/pkg/metrics"
 	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
 	"github.com/avast/retry-go"
 	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// domain is the domain label of the metrics
+const domain = "git"
+
 type Client struct {
 	taskList *task.List
 
 	failedTaskList  *task.List
 	succeedTaskList *task.List
 
+	// errors holds the last error of each failed task
+	errors sync.Map
+
 	config *config.Config
 }
 
@@ -31,56 +46,208 @@ func NewClient(cfg *config.Config) *Client {
 }
 
 func (c *Client) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext runs the sync tasks with the logger of ctx, canceling ctx stops the running tasks and skips the
+// remaining ones
+func (c *Client) RunContext(ctx context.Context) (err error) {
+	// 一次运行的所有任务属于同一个 trace
+	ctx, span := tracing.Start(ctx, "git.Client.Run")
+	defer func() { tracing.End(span, err) }()
 	start := time.Now()
+	log := task.Logger(ctx)
 
 	var ch = make(chan struct{}, c.config.Proc)
-	var wg = sync.WaitGroup{}
 
 	taskList, err := task2.GenerateSyncTaskList(c.config, ch)
 	if err != nil {
-		logrus.Fatalf("error generate sync task list: %+v", err)
+		return fmt.Errorf("error generate sync task list: %+v", err)
+	}
+
+	c.run(ctx, taskList, ch)
+	c.report(log)
+	log.Infof("git sync finished, %d/%d task failed, cost %s", c.failedTaskList.Length(), c.taskList.Length(), time.Since(start).String())
+	return nil
+}
+
+// Export writes a git bundle of every source in the repos to dir, incremental since the refs recorded in
+// stateFile unless full is set, and a manifest listing the bundles for Import
+func (c *Client) Export(dir, stateFile string, full bool) error {
+	return c.ExportContext(context.Background(), dir, stateFile, full)
+}
+
+// ExportContext exports with the logger of ctx, canceling ctx skips the remaining tasks, the manifest and state
+// still record the bundles written before
+func (c *Client) ExportContext(ctx context.Context, dir, stateFile string, full bool) error {
+	start := time.Now()
+	log := task.Logger(ctx)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	state, err := task2.LoadBundleState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	var ch = make(chan struct{}, c.config.Proc)
+	stamp := start.UTC().Format("20060102T150405Z")
+	taskList, err := task2.GenerateExportTaskList(c.config, dir, stamp, state, full)
+	if err != nil {
+		return fmt.Errorf("error generate export task list: %w", err)
+	}
+
+	c.run(ctx, taskList, ch)
+
+	manifest := &task2.BundleManifest{Created: start, Bundles: make([]*task2.BundleEntry, 0)}
+	for t := range c.succeedTaskList.Iterator() {
+		if e := t.(*task2.ExportTask).Entry(); e != nil {
+			manifest.Bundles = append(manifest.Bundles, e)
+		}
+	}
+	sort.Slice(manifest.Bundles, func(i, j int) bool {
+		return manifest.Bundles[i].Source < manifest.Bundles[j].Source
+	})
+	if len(manifest.Bundles) > 0 {
+		if err := manifest.Save(filepath.Join(dir, task2.ManifestFile(stamp))); err != nil {
+			return err
+		}
+	}
+	if err := state.Save(stateFile); err != nil {
+		return err
+	}
+
+	c.report(log)
+	log.Infof("git export finished, %d bundles written, %d/%d task failed, cost %s", len(manifest.Bundles), c.failedTaskList.Length(), c.taskList.Length(), time.Since(start).String())
+	return nil
+}
+
+// Import pushes the bundles written by Export in dir to the destinations of their mappings
+func (c *Client) Import(dir string) error {
+	start := time.Now()
+
+	var ch = make(chan struct{}, c.config.Proc)
+	taskList, err := task2.GenerateImportTaskList(c.config, dir)
+	if err != nil {
+		return err
 	}
 
+	c.run(context.Background(), taskList, ch)
+	c.report(task.Logger(context.Background()))
+	logrus.Infof("git import finished, %d/%d task failed, cost %s", c.failedTaskList.Length(), c.taskList.Length(), time.Since(start).String())
+	return nil
+}
+
+// run runs the tasks, the tasks not started yet when ctx is canceled fail with the error of ctx
+func (c *Client) run(ctx context.Context, taskList *task.List, ch chan struct{}) {
+	var wg = sync.WaitGroup{}
+	log := task.Logger(ctx)
+
 	c.taskList = taskList
 
-	logrus.Infof("run sync task with %d processes", c.config.Proc)
+	log.Infof("run sync task with %d processes", c.config.Proc)
 
 	for t := range c.taskList.Iterator() {
-		ch <- struct{}{}
+		select {
+		case ch <- struct{}{}:
+		case <-ctx.Done():
+			c.errors.Store(t.Name(), ctx.Err())
+			c.failedTaskList.Add(t)
+			continue
+		}
 		wg.Add(1)
 		t := t
 		go func() {
-			logrus.Infof("start sync task: %s", t.Name())
-
-			if err := retry.Do(
-				t.Run,
+			// 任务内的日志携带任务的字段
+			log := log.WithFields(logrus.Fields{task.FieldDomain: domain, task.FieldTask: t.Name()})
+			log.Infof("start sync task: %s", t.Name())
+
+			start := time.Now()
+			attempt := 0
+			err := retry.Do(
+				func() error {
+					attempt++
+					return task.Run(task.WithLogger(ctx, log.WithField(task.FieldAttempt, attempt)), t)
+				},
+				retry.Context(ctx),
+				// 取消后不再重试
+				retry.RetryIf(func(error) bool { return ctx.Err() == nil }),
 				retry.Attempts(uint(c.config.Retries)),
 				retry.Delay(0),
 				retry.LastErrorOnly(true),
 				retry.DelayType(retry.DefaultDelayType),
 				retry.OnRetry(func(n uint, err error) {
-					logrus.Warnf("%d/%d: retry %s with error %s", n+1, c.config.Retries, t.Name(), err)
+					log.Warnf("%d/%d: retry %s with error %s", n+1, c.config.Retries, t.Name(), err)
+					// 最后一次失败后也会调用 OnRetry，但不再重试
+					if int(n)+1 < c.config.Retries {
+						metrics.TaskRetries.WithLabelValues(domain).Inc()
+					}
 				}),
-			); err != nil {
-				logrus.Errorf("run sync task %s failed: %+v", t.Name(), err)
+			)
+			metrics.ObserveTask(domain, start, err)
+			if err != nil {
+				log.Errorf("run sync task %s failed: %+v", t.Name(), err)
+				c.errors.Store(t.Name(), err)
 				c.failedTaskList.Add(t)
 			} else {
-				logrus.Infof("run sync task %s succeed", t.Name())
+				log.Infof("run sync task %s succeed", t.Name())
 				c.succeedTaskList.Add(t)
 			}
+			// 任务结束后关闭任务的日志文件
+			log2.CloseTask(t.Name())
 			<-ch
 			wg.Done()
 		}()
 	}
 
 	wg.Wait()
+}
+
+// Tasks returns the tasks of the last run
+func (c *Client) Tasks() *task.List {
+	return c.taskList
+}
 
-	cost := time.Since(start).String()
+// Failed returns the tasks failed in the last run
+func (c *Client) Failed() *task.List {
+	return c.failedTaskList
+}
+
+// Succeeded returns the tasks succeeded in the last run
+func (c *Client) Succeeded() *task.List {
+	return c.succeedTaskList
+}
+
+// Err returns the last error of a failed task
+func (c *Client) Err(t task.Task) error {
+	if err, ok := c.errors.Load(t.Name()); ok {
+		return err.(error)
+	}
+	return nil
+}
+
+// report logs the failed tasks and the result of each destination
+func (c *Client) report(log *logrus.Entry) {
 	if c.failedTaskList.Length() > 0 {
 		for t := range c.failedTaskList.Iterator() {
-			logrus.Warnf("task %s failed", t.Name())
+			log.Warnf("task %s failed", t.Name())
+		}
+	}
+	for t := range c.taskList.Iterator() {
+		rt, ok := t.(interface{ Results() []*task2.Result })
+		if !ok {
+			continue
+		}
+		for _, r := range rt.Results() {
+			if r.Error != nil {
+				log.Warnf("%s: %s %s: %s", t.Name(), r.Destination, r.Status, r.Error)
+			} else {
+				log.Infof("%s: %s %s", t.Name(), r.Destination, r.Status)
+			}
+			if len(r.Withheld) > 0 {
+				log.Warnf("%s: %s withheld %d unverified refs: %s", t.Name(), r.Destination, len(r.Withheld), strings.Join(r.Withheld, ", "))
+			}
 		}
 	}
-	logrus.Infof("git sync finished, %d/%d task failed, cost %s", c.failedTaskList.Length(), c.taskList.Length(), cost)
-	return nil
 }
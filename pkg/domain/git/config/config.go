@@ -1,23 +1,164 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/notify"
 	"github.com/MR5356/syncer/pkg/utils/configutil"
 	"github.com/mcuadros/go-defaults"
 	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+const (
+	BackendGoGit = "go-git"
+	BackendGit   = "git"
 )
 
 type Config struct {
-	Proc               int    `json:"proc" yaml:"proc"`
-	Retries            int    `json:"retries" yaml:"retries"`
-	PrivateKeyFile     string `json:"privateKeyFile" yaml:"privateKeyFile"`
-	PrivateKeyPassword string `json:"privateKeyPassword" yaml:"privateKeyPassword"`
+	Proc               int               `json:"proc" yaml:"proc"`
+	Retries            int               `json:"retries" yaml:"retries"`
+	PrivateKeyFile     string            `json:"privateKeyFile" yaml:"privateKeyFile"`
+	PrivateKeyPassword configutil.Secret `json:"privateKeyPassword" yaml:"privateKeyPassword"`
+	// Auth holds http credentials keyed by host or url prefix, e.g. github.com or https://gitlab.com/group/
+	Auth map[string]*Auth `json:"auth" yaml:"auth"`
+	// SSH configures ssh authentication and host key verification
+	SSH SSH `json:"ssh" yaml:"ssh"`
+	// CreateRepo creates missing destination repositories through the forge api before pushing
+	CreateRepo bool `json:"createRepo" yaml:"createRepo"`
+	// Backend is go-git (default) or git, which drives the system git binary for fetch and push
+	Backend string `json:"backend" yaml:"backend"`
+	// StateDir holds the state kept between runs, like the commit id maps of stripped repositories
+	StateDir string `json:"stateDir" yaml:"stateDir" default:".syncer"`
+
+	// Notifications post the summary of each run to webhooks
+	Notifications []*notify.Notification `json:"notifications" yaml:"notifications"`
+
+	// Forges holds the api settings of forges keyed by host
+	Forges map[string]*Forge `json:"forges" yaml:"forges"`
 
 	Repos map[string]any `json:"repos" yaml:"repos"`
 }
 
+// Auth is a http credential, a token with a username is sent as the basic auth password
+// which GitHub requires, a token without username is sent as a bearer token
+type Auth struct {
+	Username string            `json:"username" yaml:"username"`
+	Password configutil.Secret `json:"password" yaml:"password"`
+	Token    configutil.Secret `json:"token" yaml:"token"`
+	// TokenFile reads the token from a file
+	TokenFile string `json:"tokenFile" yaml:"tokenFile"`
+	// TokenEnv reads the token from an environment variable
+	TokenEnv string `json:"tokenEnv" yaml:"tokenEnv"`
+}
+
+// GetToken returns the token of the auth, read from TokenFile or TokenEnv if configured
+func (a *Auth) GetToken() (string, error) {
+	switch {
+	case a.Token != "":
+		return a.Token.Value(), nil
+	case a.TokenFile != "":
+		bs, err := os.ReadFile(a.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("read token file failed: %s", err)
+		}
+		return strings.TrimSpace(string(bs)), nil
+	case a.TokenEnv != "":
+		token := os.Getenv(a.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is empty", a.TokenEnv)
+		}
+		return token, nil
+	}
+	return "", nil
+}
+
+// GetAuth returns the credential with the longest key matching the url, nil if none matches
+func (c *Config) GetAuth(url string) *Auth {
+	target := trimScheme(url)
+	var best *Auth
+	bestLen := -1
+	for key, auth := range c.Auth {
+		if strings.Contains(key, "://") && !strings.HasPrefix(url, key[:strings.Index(key, "://")+3]) {
+			continue
+		}
+		prefix := strings.TrimSuffix(trimScheme(key), "/")
+		if !strings.HasPrefix(target, prefix) {
+			continue
+		}
+		if len(target) > len(prefix) && !strings.ContainsRune("/:", rune(target[len(prefix)])) {
+			continue
+		}
+		if len(prefix) > bestLen {
+			best, bestLen = auth, len(prefix)
+		}
+	}
+	return best
+}
+
+// trimScheme removes the scheme and the user info of an url
+func trimScheme(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+	host, rest, _ := strings.Cut(url, "/")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if rest == "" {
+		return host
+	}
+	return host + "/" + rest
+}
+
+type SSH struct {
+	// Agent uses the keys of the ssh-agent listening on SSH_AUTH_SOCK
+	Agent bool `json:"agent" yaml:"agent"`
+	// StrictHostKeyChecking fails on unknown hosts instead of trusting and recording their keys
+	StrictHostKeyChecking bool `json:"strictHostKeyChecking" yaml:"strictHostKeyChecking"`
+	// KnownHostsFiles defaults to SSH_KNOWN_HOSTS or ~/.ssh/known_hosts, new keys are recorded in the first one
+	KnownHostsFiles []string `json:"knownHostsFiles" yaml:"knownHostsFiles"`
+	// Hosts holds per host settings keyed by host or host:port
+	Hosts map[string]*SSHHost `json:"hosts" yaml:"hosts"`
+}
+
+type SSHHost struct {
+	User               string            `json:"user" yaml:"user"`
+	PrivateKeyFile     string            `json:"privateKeyFile" yaml:"privateKeyFile"`
+	PrivateKeyPassword configutil.Secret `json:"privateKeyPassword" yaml:"privateKeyPassword"`
+	// Fingerprints pins the accepted host keys, e.g. SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU
+	Fingerprints []string `json:"fingerprints" yaml:"fingerprints"`
+}
+
+// GetHost returns the settings of host:port, falling back to the settings of host
+func (s *SSH) GetHost(host string, port int) *SSHHost {
+	if h, ok := s.Hosts[fmt.Sprintf("%s:%d", host, port)]; ok {
+		return h
+	}
+	if h, ok := s.Hosts[host]; ok {
+		return h
+	}
+	return new(SSHHost)
+}
+
+type Forge struct {
+	// Type is one of github, gitlab and gitea
+	Type string `json:"type" yaml:"type"`
+	// Url is the api url, defaults to the public api of the forge type at its host
+	Url      string            `json:"url" yaml:"url"`
+	Token    configutil.Secret `json:"token" yaml:"token"`
+	Insecure bool              `json:"insecure" yaml:"insecure"`
+}
+
 func NewConfig(cfg ...Cfg) *Config {
 	config := &Config{
-		Repos: make(map[string]any),
+		SSH: SSH{
+			Hosts: make(map[string]*SSHHost),
+		},
+		Auth:   make(map[string]*Auth),
+		Forges: make(map[string]*Forge),
+		Repos:  make(map[string]any),
 	}
 
 	defaults.SetDefaults(config)
@@ -38,12 +179,51 @@ func NewConfigFromFile(cf string) *Config {
 	return config
 }
 
+// MarshalJSON hides the credentials embedded in the repo urls, secrets are hidden by configutil.Secret
+func (c *Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	cp := plain(*c)
+	if repos, ok := configutil.RedactUrls(map[string]any(c.Repos)).(map[string]any); ok {
+		cp.Repos = repos
+	}
+	return json.Marshal(&cp)
+}
+
 func (c *Config) With(cs ...Cfg) {
 	for _, cfg := range cs {
 		cfg(c)
 	}
 }
 
+// GetForge returns the forge configured for host, if host is empty the only forge of the given type is returned
+func (c *Config) GetForge(typ, host string) (string, *Forge, error) {
+	if host != "" {
+		f, ok := c.Forges[host]
+		if !ok {
+			return host, &Forge{Type: typ}, nil
+		}
+		if f.Type != "" && typ != "" && f.Type != typ {
+			return "", nil, fmt.Errorf("forge %s is configured as %s, not %s", host, f.Type, typ)
+		}
+		return host, f, nil
+	}
+
+	var found string
+	for h, f := range c.Forges {
+		if f.Type != typ {
+			continue
+		}
+		if found != "" {
+			return "", nil, fmt.Errorf("more than one %s forge configured, specify the host in the source", typ)
+		}
+		found = h
+	}
+	if found == "" {
+		return "", nil, fmt.Errorf("no %s forge configured", typ)
+	}
+	return found, c.Forges[found], nil
+}
+
 type Cfg func(config *Config)
 
 func WithProc(proc int) Cfg {
@@ -66,6 +246,18 @@ func WithPrivateKeyFile(privateKeyFile string) Cfg {
 
 func WithPrivateKeyPassword(privateKeyPassword string) Cfg {
 	return func(config *Config) {
-		config.PrivateKeyPassword = privateKeyPassword
+		config.PrivateKeyPassword = configutil.Secret(privateKeyPassword)
+	}
+}
+
+func WithSSHAgent(agent bool) Cfg {
+	return func(config *Config) {
+		config.SSH.Agent = agent
+	}
+}
+
+func WithStrictHostKeyChecking(strict bool) Cfg {
+	return func(config *Config) {
+		config.SSH.StrictHostKeyChecking = strict
 	}
 }
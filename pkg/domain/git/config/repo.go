@@ -0,0 +1,373 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/docker/go-units"
+	"github.com/mcuadros/go-defaults"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Repo is the parsed value of an entry in repos, it can be written as a
+// destination string, a list of destinations or an object with options.
+type Repo struct {
+	Destinations []string `json:"destinations" yaml:"destinations"`
+
+	Submodules *Submodules `json:"submodules,omitempty" yaml:"submodules,omitempty"`
+	// CreateRepo creates missing destination repositories, it overrides Config.CreateRepo when set
+	CreateRepo *bool `json:"createRepo,omitempty" yaml:"createRepo,omitempty"`
+
+	// Filter and Protocol apply to forge sources like github:org/*, the destinations are templates
+	Filter   *Filter `json:"filter,omitempty" yaml:"filter,omitempty"`
+	Protocol string  `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+
+	// Backend overrides Config.Backend for this mapping
+	Backend string `json:"backend,omitempty" yaml:"backend,omitempty"`
+
+	// Depth and Since limit the fetched history, the result is pushed as a shallow mirror which requires the
+	// git backend and destinations accepting shallow pushes. Since is a date like 2024-01-01 or a RFC3339 time.
+	Depth int    `json:"depth,omitempty" yaml:"depth,omitempty"`
+	Since string `json:"since,omitempty" yaml:"since,omitempty"`
+	// SingleBranch only mirrors the default branch of the source, without tags
+	SingleBranch bool `json:"singleBranch,omitempty" yaml:"singleBranch,omitempty"`
+	// Ref only mirrors a single branch or tag like refs/heads/main or refs/tags/v1.0, the webhooks of the
+	// daemon sync the pushed ref with it
+	Ref string `json:"ref,omitempty" yaml:"ref,omitempty"`
+
+	// Bidirectional syncs the source and its only destination both ways, refs advanced on one side are
+	// fast-forwarded to the other and diverged refs are reported as conflicts instead of being overwritten
+	Bidirectional bool `json:"bidirectional,omitempty" yaml:"bidirectional,omitempty"`
+	// ParkConflicts pushes the diverged refs of each side to refs/conflicts/<side>/* of the other side
+	ParkConflicts bool `json:"parkConflicts,omitempty" yaml:"parkConflicts,omitempty"`
+
+	// Rename rewrites the branch and tag names pushed to the destinations, the rules apply in order
+	Rename []*RenameRule `json:"rename,omitempty" yaml:"rename,omitempty"`
+
+	// Strip rewrites the history pushed to the destinations without the excluded paths and large blobs
+	Strip *Strip `json:"strip,omitempty" yaml:"strip,omitempty"`
+
+	// Verify withholds the branches and tags whose tips are not signed by the trusted keys
+	Verify *Verify `json:"verify,omitempty" yaml:"verify,omitempty"`
+
+	// Releases mirrors the releases and their assets, the source and destinations should be forges
+	Releases *Releases `json:"releases,omitempty" yaml:"releases,omitempty"`
+}
+
+// Releases creates the releases missing at the destinations for the mirrored tags and uploads the missing assets
+type Releases struct {
+	// MaxAssetSize skips the assets larger than the size, e.g. 500MB, empty for no limit
+	MaxAssetSize string `json:"maxAssetSize" yaml:"maxAssetSize"`
+}
+
+// AssetSizeLimit returns MaxAssetSize in bytes, 0 for no limit
+func (r *Releases) AssetSizeLimit() (int64, error) {
+	if r.MaxAssetSize == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(r.MaxAssetSize)
+}
+
+// Verify requires the tips of the mirrored branches and tags to be signed by trusted gpg or ssh keys, an
+// annotated tag is trusted when either the tag or the commit it points to is signed
+type Verify struct {
+	// GPGKeys are files of armored gpg public keys
+	GPGKeys []string `json:"gpgKeys" yaml:"gpgKeys"`
+	// SSHKeys are files of ssh public keys in authorized_keys or allowed_signers format
+	SSHKeys []string `json:"sshKeys" yaml:"sshKeys"`
+	// Type limits the verification to heads or tags, empty for both
+	Type string `json:"type" yaml:"type"`
+}
+
+// Strip excludes paths and large blobs from every commit pushed to the destinations, the ids of the
+// rewritten commits are kept in CommitMap so that later runs only rewrite the new commits
+type Strip struct {
+	// Paths are excluded files and directories relative to the repository root, glob patterns without a
+	// slash like *.key match the names in any directory
+	Paths []string `json:"paths" yaml:"paths"`
+	// MaxBlobSize excludes the files larger than the size, e.g. 50MB
+	MaxBlobSize string `json:"maxBlobSize" yaml:"maxBlobSize"`
+	// CommitMap is the file of the commit id map, defaults to <stateDir>/commit-map/<repo>.json
+	CommitMap string `json:"commitMap" yaml:"commitMap"`
+}
+
+// BlobSizeLimit returns MaxBlobSize in bytes, 0 for no limit
+func (s *Strip) BlobSizeLimit() (int64, error) {
+	if s.MaxBlobSize == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(s.MaxBlobSize)
+}
+
+const (
+	RefTypeHeads = "heads"
+	RefTypeTags  = "tags"
+)
+
+// RenameRule rewrites the short name of branches or tags, e.g. master -> main or v1.0 -> vendor-v1.0
+type RenameRule struct {
+	// Type is heads or tags, empty for both
+	Type string `json:"type" yaml:"type"`
+	// Match is a regular expression, the rule only applies to the names it matches, empty for all
+	Match string `json:"match" yaml:"match"`
+	// Replace replaces the matched part of the name, supports $1 style groups
+	Replace string `json:"replace" yaml:"replace"`
+	// Prefix is prepended to the name after the replacement
+	Prefix string `json:"prefix" yaml:"prefix"`
+
+	// re is the compiled Match, set by Compile
+	re *regexp.Regexp
+}
+
+// Compile compiles the match of the rule, the rules of a mapping are compiled by ParseRepo
+func (r *RenameRule) Compile() error {
+	if r.Match == "" {
+		return nil
+	}
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return err
+	}
+	r.re = re
+	return nil
+}
+
+// Apply returns the short name of a branch or tag rewritten by the rule, ok is false when the rule does not
+// match the name. A rule with a match is only applied once compiled
+func (r *RenameRule) Apply(short string) (_ string, ok bool) {
+	if r.Match != "" {
+		if r.re == nil || !r.re.MatchString(short) {
+			return short, false
+		}
+		short = r.re.ReplaceAllString(short, r.Replace)
+	}
+	return r.Prefix + short, true
+}
+
+// Filter selects the repositories expanded from a forge source
+type Filter struct {
+	// Archived includes archived repositories
+	Archived bool `json:"archived" yaml:"archived"`
+	// Forks includes forked repositories
+	Forks bool `json:"forks" yaml:"forks"`
+	// Visibility is a list of public, private and internal, empty for all
+	Visibility []string `json:"visibility" yaml:"visibility"`
+	// Exclude is a list of glob patterns matching repository paths to skip
+	Exclude []string `json:"exclude" yaml:"exclude"`
+}
+
+// Submodules enables recursive mirroring of the submodules referenced by .gitmodules
+type Submodules struct {
+	// Template renders the destination of a submodule, e.g. git@git.internal:mirror/{{.Name}}.git
+	Template string `json:"template" yaml:"template"`
+	// Rewrite pushes branches with .gitmodules pointing to the mirrored submodules
+	Rewrite      bool   `json:"rewrite" yaml:"rewrite"`
+	BranchPrefix string `json:"branchPrefix" yaml:"branchPrefix" default:"mirror/"`
+}
+
+func ParseRepo(source string, value any) (*Repo, error) {
+	repo := new(Repo)
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil, fmt.Errorf("empty destination for source: %s", source)
+		}
+		repo.Destinations = []string{v}
+	case []any:
+		dests, err := parseDestinations(source, v)
+		if err != nil {
+			return nil, err
+		}
+		repo.Destinations = dests
+	case map[string]any:
+		var dests []string
+		var err error
+		switch d := v["destinations"].(type) {
+		case string:
+			if d != "" {
+				dests = []string{d}
+			}
+		case []any:
+			dests, err = parseDestinations(source, d)
+			if err != nil {
+				return nil, err
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("invalid destinations, should be string or []string for source: %s", source)
+		}
+		opts := make(map[string]any, len(v))
+		for key, val := range v {
+			if key != "destinations" {
+				opts[key] = val
+			}
+		}
+		bs, err := json.Marshal(opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(bs, repo); err != nil {
+			return nil, fmt.Errorf("invalid options for source %s: %s", source, err)
+		}
+		if len(dests) == 0 {
+			return nil, fmt.Errorf("empty destination for source: %s", source)
+		}
+		repo.Destinations = dests
+	default:
+		return nil, fmt.Errorf("invalid destination, should be string or []string for source: %s", source)
+	}
+
+	switch repo.Protocol {
+	case "", "https", "ssh":
+	default:
+		return nil, fmt.Errorf("invalid protocol %s for source %s, should be https or ssh", repo.Protocol, source)
+	}
+
+	switch repo.Backend {
+	case "", BackendGoGit, BackendGit:
+	default:
+		return nil, fmt.Errorf("invalid backend %s for source %s, should be %s or %s", repo.Backend, source, BackendGoGit, BackendGit)
+	}
+
+	if repo.Submodules != nil {
+		if repo.Submodules.Template == "" {
+			return nil, fmt.Errorf("empty submodules template for source: %s", source)
+		}
+		defaults.SetDefaults(repo.Submodules)
+	}
+
+	if repo.Depth < 0 {
+		return nil, fmt.Errorf("invalid depth %d for source %s", repo.Depth, source)
+	}
+	if repo.Since != "" {
+		if _, err := ParseSince(repo.Since); err != nil {
+			return nil, fmt.Errorf("invalid since %s for source %s, should be a date like 2024-01-01", repo.Since, source)
+		}
+	}
+	if repo.Depth > 0 && repo.Since != "" {
+		return nil, fmt.Errorf("depth and since of source %s can not be used together", source)
+	}
+	if repo.Shallow() && repo.Backend == BackendGoGit {
+		return nil, fmt.Errorf("depth and since of source %s require the git backend", source)
+	}
+
+	if repo.Ref != "" {
+		switch {
+		case !strings.HasPrefix(repo.Ref, "refs/heads/") && !strings.HasPrefix(repo.Ref, "refs/tags/"):
+			return nil, fmt.Errorf("invalid ref %s for source %s, should be a branch or a tag like refs/heads/main", repo.Ref, source)
+		case repo.SingleBranch:
+			return nil, fmt.Errorf("ref and singleBranch of source %s can not be used together", source)
+		}
+	}
+
+	if repo.Bidirectional {
+		switch {
+		case len(repo.Destinations) != 1:
+			return nil, fmt.Errorf("bidirectional source %s should have exactly one destination", source)
+		case repo.Submodules != nil, len(repo.Rename) > 0:
+			return nil, fmt.Errorf("bidirectional source %s does not support submodules and rename", source)
+		case repo.Shallow(), repo.SingleBranch, repo.Ref != "":
+			return nil, fmt.Errorf("bidirectional source %s does not support depth, since, singleBranch and ref", source)
+		case repo.Strip != nil, repo.Verify != nil:
+			return nil, fmt.Errorf("bidirectional source %s does not support strip and verify", source)
+		}
+	}
+
+	if repo.Strip != nil {
+		if err := validateStrip(source, repo); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := repo.Verify; v != nil {
+		if len(v.GPGKeys) == 0 && len(v.SSHKeys) == 0 {
+			return nil, fmt.Errorf("empty verify keys for source %s, should have gpgKeys or sshKeys", source)
+		}
+		switch v.Type {
+		case "", RefTypeHeads, RefTypeTags:
+		default:
+			return nil, fmt.Errorf("invalid verify type %s for source %s, should be heads or tags", v.Type, source)
+		}
+	}
+
+	if repo.Releases != nil {
+		if _, err := repo.Releases.AssetSizeLimit(); err != nil {
+			return nil, fmt.Errorf("invalid maxAssetSize %s for source %s: %s", repo.Releases.MaxAssetSize, source, err)
+		}
+	}
+
+	for _, r := range repo.Rename {
+		switch r.Type {
+		case "", RefTypeHeads, RefTypeTags:
+		default:
+			return nil, fmt.Errorf("invalid rename type %s for source %s, should be heads or tags", r.Type, source)
+		}
+		if err := r.Compile(); err != nil {
+			return nil, fmt.Errorf("invalid rename match %s for source %s: %s", r.Match, source, err)
+		}
+		if r.Match == "" && r.Prefix == "" {
+			return nil, fmt.Errorf("empty rename rule for source %s, should have match or prefix", source)
+		}
+	}
+	return repo, nil
+}
+
+// Shallow reports whether the mapping limits the fetched history
+func (r *Repo) Shallow() bool {
+	return r.Depth > 0 || r.Since != ""
+}
+
+// CreatesRepo reports whether the missing destinations of the mapping are created, global is Config.CreateRepo
+func (r *Repo) CreatesRepo(global bool) bool {
+	if r.CreateRepo != nil {
+		return *r.CreateRepo
+	}
+	return global
+}
+
+// ParseSince parses a date like 2024-01-01 or a RFC3339 time
+func ParseSince(since string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", since); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, since)
+}
+
+func validateStrip(source string, repo *Repo) error {
+	strip := repo.Strip
+	if len(strip.Paths) == 0 && strip.MaxBlobSize == "" {
+		return fmt.Errorf("empty strip for source %s, should have paths or maxBlobSize", source)
+	}
+	if repo.Shallow() {
+		return fmt.Errorf("strip of source %s can not be used with depth and since", source)
+	}
+	for _, p := range strip.Paths {
+		if strings.Trim(p, "/") == "" {
+			return fmt.Errorf("empty strip path for source %s", source)
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid strip path %s for source %s: %s", p, source, err)
+		}
+	}
+	if _, err := strip.BlobSizeLimit(); err != nil {
+		return fmt.Errorf("invalid maxBlobSize %s for source %s: %s", strip.MaxBlobSize, source, err)
+	}
+	return nil
+}
+
+func parseDestinations(source string, list []any) ([]string, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("empty destination for source: %s", source)
+	}
+	dests := make([]string, 0, len(list))
+	for _, d := range list {
+		destStr, ok := d.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid destination type: %T", d)
+		}
+		dests = append(dests, destStr)
+	}
+	return dests, nil
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseRepo(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    *Repo
+		wantErr bool
+	}{
+		{
+			name:  "test string",
+			value: "git@test.com:MR5356/syncer.git",
+			want:  &Repo{Destinations: []string{"git@test.com:MR5356/syncer.git"}},
+		},
+		{
+			name:  "test list",
+			value: []any{"git@test1.com:MR5356/syncer.git", "git@test2.com:MR5356/syncer.git"},
+			want:  &Repo{Destinations: []string{"git@test1.com:MR5356/syncer.git", "git@test2.com:MR5356/syncer.git"}},
+		},
+		{
+			name: "test object",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"submodules": map[string]any{
+					"template": "git@test.com:mirror/{{.Name}}.git",
+					"rewrite":  true,
+				},
+				"createRepo": false,
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				CreateRepo:   new(bool),
+				Submodules: &Submodules{
+					Template:     "git@test.com:mirror/{{.Name}}.git",
+					Rewrite:      true,
+					BranchPrefix: "mirror/",
+				},
+			},
+		},
+		{
+			name: "test rename",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"rename": []any{
+					map[string]any{"type": "heads", "match": "^master$", "replace": "main"},
+					map[string]any{"type": "tags", "prefix": "vendor-"},
+				},
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				Rename: []*RenameRule{
+					{Type: RefTypeHeads, Match: "^master$", Replace: "main", re: regexp.MustCompile("^master$")},
+					{Type: RefTypeTags, Prefix: "vendor-"},
+				},
+			},
+		},
+		{
+			name: "test invalid rename",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"rename":       []any{map[string]any{"match": "("}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test bidirectional",
+			value: map[string]any{
+				"destinations":  "git@test.com:MR5356/syncer.git",
+				"bidirectional": true,
+				"parkConflicts": true,
+			},
+			want: &Repo{
+				Destinations:  []string{"git@test.com:MR5356/syncer.git"},
+				Bidirectional: true,
+				ParkConflicts: true,
+			},
+		},
+		{
+			name: "test bidirectional with many destinations",
+			value: map[string]any{
+				"destinations":  []any{"git@test1.com:MR5356/syncer.git", "git@test2.com:MR5356/syncer.git"},
+				"bidirectional": true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test shallow",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"since":        "2024-01-01",
+				"singleBranch": true,
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				Since:        "2024-01-01",
+				SingleBranch: true,
+			},
+		},
+		{
+			name: "test ref",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"ref":          "refs/tags/v1.0.0",
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				Ref:          "refs/tags/v1.0.0",
+			},
+		},
+		{
+			name: "test invalid ref",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"ref":          "main",
+			},
+			wantErr: true,
+		},
+		{
+			name: "test depth with since",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"depth":        1,
+				"since":        "2024-01-01",
+			},
+			wantErr: true,
+		},
+		{
+			name: "test invalid since",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"since":        "last year",
+			},
+			wantErr: true,
+		},
+		{
+			name: "test shallow with go-git backend",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"depth":        1,
+				"backend":      BackendGoGit,
+			},
+			wantErr: true,
+		},
+		{
+			name: "test strip",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"strip": map[string]any{
+					"paths":       []any{"/internal", "*.key"},
+					"maxBlobSize": "50MB",
+				},
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				Strip:        &Strip{Paths: []string{"/internal", "*.key"}, MaxBlobSize: "50MB"},
+			},
+		},
+		{
+			name: "test invalid strip size",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"strip":        map[string]any{"maxBlobSize": "large"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test empty strip",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"strip":        map[string]any{},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test verify",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"verify": map[string]any{
+					"sshKeys": []any{"/etc/syncer/allowed_signers"},
+					"type":    "tags",
+				},
+			},
+			want: &Repo{
+				Destinations: []string{"git@test.com:MR5356/syncer.git"},
+				Verify:       &Verify{SSHKeys: []string{"/etc/syncer/allowed_signers"}, Type: RefTypeTags},
+			},
+		},
+		{
+			name: "test verify without keys",
+			value: map[string]any{
+				"destinations": "git@test.com:MR5356/syncer.git",
+				"verify":       map[string]any{"type": "heads"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test releases",
+			value: map[string]any{
+				"destinations": "https://gitea.example.com/mirror/syncer.git",
+				"releases":     map[string]any{"maxAssetSize": "500MB"},
+			},
+			want: &Repo{
+				Destinations: []string{"https://gitea.example.com/mirror/syncer.git"},
+				Releases:     &Releases{MaxAssetSize: "500MB"},
+			},
+		},
+		{
+			name:    "test empty string",
+			value:   "",
+			wantErr: true,
+		},
+		{
+			name:    "test object without destinations",
+			value:   map[string]any{},
+			wantErr: true,
+		},
+		{
+			name:    "test invalid type",
+			value:   1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRepo("git@github.com:MR5356/syncer.git", tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRepo() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRepo() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepo_CreatesRepo(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name   string
+		repo   *Repo
+		global bool
+		want   bool
+	}{
+		{name: "test global", repo: &Repo{}, global: true, want: true},
+		{name: "test enabled", repo: &Repo{CreateRepo: &enabled}, want: true},
+		{name: "test disabled", repo: &Repo{CreateRepo: &disabled}, global: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.repo.CreatesRepo(tt.global); got != tt.want {
+				t.Errorf("CreatesRepo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
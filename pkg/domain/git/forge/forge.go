@@ -0,0 +1,343 @@
+package forge
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	TypeGithub = "github"
+	TypeGitlab = "gitlab"
+	TypeGitea  = "gitea"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+
+	isSource = regexp.MustCompile(`^(github|gitlab|gitea)(@[-\w.:]+)?:([-\w./]+)/([^/]*\*[^/]*)$`)
+	linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+)
+
+type Repository struct {
+	Name string `json:"name"`
+	// Path is the full path of the repository, e.g. MR5356/syncer or group/sub/project
+	Path          string `json:"path"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"defaultBranch"`
+	// Visibility is one of public, private and internal
+	Visibility string `json:"visibility"`
+	Archived   bool   `json:"archived"`
+	Fork       bool   `json:"fork"`
+	HttpUrl    string `json:"httpUrl"`
+	SshUrl     string `json:"sshUrl"`
+}
+
+// Release is a release of a repository, Id is empty on gitlab where releases are identified by tags
+type Release struct {
+	Id         int64  `json:"id"`
+	TagName    string `json:"tagName"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	// UploadUrl is the asset upload url returned by github
+	UploadUrl string   `json:"uploadUrl"`
+	Assets    []*Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release, Size is 0 if the forge does not report it
+type Asset struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Url is the url the content is downloaded from
+	Url string `json:"url"`
+}
+
+type Forge interface {
+	// ListRepos lists the repositories owned by an organization, group or user,
+	// recursive includes the repositories of subgroups where supported
+	ListRepos(owner string, recursive bool) ([]*Repository, error)
+	// GetRepo returns ErrNotFound if the repository does not exist
+	GetRepo(path string) (*Repository, error)
+	// CreateRepo creates a repository at repo.Path with its description and visibility
+	CreateRepo(repo *Repository) error
+	SetDefaultBranch(path, branch string) error
+
+	// ListReleases lists the releases of a repository with their assets
+	ListReleases(path string) ([]*Release, error)
+	// CreateRelease creates a release for an existing tag and returns the created release
+	CreateRelease(path string, release *Release) (*Release, error)
+	// DownloadAsset opens the content of a release asset
+	DownloadAsset(path string, asset *Asset) (io.ReadCloser, error)
+	// UploadAsset uploads the content of r as an asset of the release
+	UploadAsset(path string, release *Release, name string, size int64, r io.Reader) error
+}
+
+func NewForge(typ, apiUrl, token string, insecure bool) (Forge, error) {
+	c := &client{
+		apiUrl: strings.TrimSuffix(apiUrl, "/"),
+		http: &http.Client{
+			Timeout: 5 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+	switch typ {
+	case TypeGithub:
+		if token != "" {
+			c.header = http.Header{"Authorization": {"Bearer " + token}}
+		}
+		return &github{c}, nil
+	case TypeGitlab:
+		if token != "" {
+			c.header = http.Header{"PRIVATE-TOKEN": {token}}
+		}
+		return &gitlab{c}, nil
+	case TypeGitea:
+		if token != "" {
+			c.header = http.Header{"Authorization": {"token " + token}}
+		}
+		return &gitea{c}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forge type: %s", typ)
+	}
+}
+
+// DefaultApiUrl returns the api url of a forge served at host
+func DefaultApiUrl(typ, host string) string {
+	switch typ {
+	case TypeGithub:
+		if host == "github.com" {
+			return "https://api.github.com"
+		}
+		return fmt.Sprintf("https://%s/api/v3", host)
+	case TypeGitlab:
+		return fmt.Sprintf("https://%s/api/v4", host)
+	case TypeGitea:
+		return fmt.Sprintf("https://%s/api/v1", host)
+	}
+	return ""
+}
+
+// DefaultHost returns the public host of a forge type, empty if there is none
+func DefaultHost(typ string) string {
+	switch typ {
+	case TypeGithub:
+		return "github.com"
+	case TypeGitlab:
+		return "gitlab.com"
+	}
+	return ""
+}
+
+// Source is a repos entry selecting many repositories of a forge, written as
+// <type>[@<host>]:<owner>/<pattern>, e.g. github:MR5356/*, gitlab:group/** or gitea@git.example.com:owner/app-*
+type Source struct {
+	Type    string
+	Host    string
+	Owner   string
+	Pattern string
+}
+
+// IsSource reports whether s is a forge source, the last path element must contain a wildcard
+func IsSource(s string) bool {
+	return isSource.MatchString(s)
+}
+
+func ParseSource(s string) (*Source, error) {
+	fields := isSource.FindStringSubmatch(s)
+	if fields == nil {
+		return nil, fmt.Errorf("invalid forge source: %s", s)
+	}
+	return &Source{
+		Type:    fields[1],
+		Host:    strings.TrimPrefix(fields[2], "@"),
+		Owner:   fields[3],
+		Pattern: fields[4],
+	}, nil
+}
+
+// Recursive reports whether the source selects repositories of subgroups too
+func (s *Source) Recursive() bool {
+	return s.Pattern == "**"
+}
+
+// splitPath splits a repository path into the owner and the name
+func splitPath(path string) (owner, name string) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+type client struct {
+	apiUrl string
+	header http.Header
+	http   *http.Client
+}
+
+// isUser reports whether owner is the user of the token, the private repositories of a user are only listed
+// to the user itself
+func (c *client) isUser(owner string) (bool, error) {
+	if c.header == nil {
+		return false, nil
+	}
+	user := struct {
+		Login string `json:"login"`
+	}{}
+	if _, err := c.do(http.MethodGet, c.apiUrl+"/user", nil, &user); err != nil {
+		return false, err
+	}
+	return strings.EqualFold(user.Login, owner), nil
+}
+
+// list gets all pages of a list api following the next link
+func list[T any](c *client, path string) ([]T, error) {
+	res := make([]T, 0)
+	next := c.apiUrl + path
+	for next != "" {
+		var page []T
+		resp, err := c.do(http.MethodGet, next, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, page...)
+
+		next = ""
+		if m := linkNext.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+	}
+	return res, nil
+}
+
+// download opens the content of url, accept selects the raw content on apis serving json by default
+func (c *client) download(url, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("GET %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// upload posts the content of r with the content type, the response is decoded into out
+func (c *client) upload(url, contentType string, size int64, r io.Reader, out any) error {
+	req, err := http.NewRequest(http.MethodPost, url, r)
+	if err != nil {
+		return err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("POST %s: decode response failed: %s", url, err)
+		}
+	}
+	return nil
+}
+
+// uploadForm uploads the content of r as the file field of a multipart form, the form is streamed
+// so that large assets are not buffered in memory
+func (c *client) uploadForm(url, field, name string, r io.Reader, out any) error {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile(field, name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	err := c.upload(url, form.FormDataContentType(), -1, pr, out)
+	_ = pr.Close()
+	return err
+}
+
+func (c *client) do(method, url string, body any, out any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp, fmt.Errorf("%s %s: %w", method, url, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, fmt.Errorf("%s %s: %s: %s", method, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("%s %s: decode response failed: %s", method, url, err)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,345 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    *Source
+		wantErr bool
+	}{
+		{
+			name:   "test github org",
+			source: "github:MR5356/*",
+			want:   &Source{Type: TypeGithub, Owner: "MR5356", Pattern: "*"},
+		},
+		{
+			name:   "test gitlab subgroups",
+			source: "gitlab:group/sub/**",
+			want:   &Source{Type: TypeGitlab, Owner: "group/sub", Pattern: "**"},
+		},
+		{
+			name:   "test gitea with host",
+			source: "gitea@git.example.com:owner/app-*",
+			want:   &Source{Type: TypeGitea, Host: "git.example.com", Owner: "owner", Pattern: "app-*"},
+		},
+		{
+			name:    "test ssh alias",
+			source:  "github:MR5356/syncer.git",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if IsSource(tt.source) == tt.wantErr {
+				t.Errorf("IsSource() = %v, want %v", !tt.wantErr, tt.wantErr)
+			}
+			got, err := ParseSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSource() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubListRepos(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/orgs/MR5356/repos":
+			w.WriteHeader(http.StatusNotFound)
+		case "/user":
+			_, _ = w.Write([]byte(`{"login":"someone"}`))
+		case "/users/MR5356/repos":
+			if r.URL.Query().Get("page") == "" {
+				w.Header().Set("Link", fmt.Sprintf(`<%s/users/MR5356/repos?page=2>; rel="next"`, server.URL))
+				_, _ = w.Write([]byte(`[{"name":"syncer","full_name":"MR5356/syncer","private":false,"clone_url":"https://github.com/MR5356/syncer.git"}]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"name":"app","full_name":"MR5356/app","private":true,"fork":true,"clone_url":"https://github.com/MR5356/app.git"}]`))
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGithub, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.ListRepos("MR5356", false)
+	if err != nil {
+		t.Fatalf("ListRepos() error = %v", err)
+	}
+	want := []*Repository{
+		{Name: "syncer", Path: "MR5356/syncer", Visibility: "public", HttpUrl: "https://github.com/MR5356/syncer.git"},
+		{Name: "app", Path: "MR5356/app", Visibility: "private", Fork: true, HttpUrl: "https://github.com/MR5356/app.git"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRepos() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestGithubListRepos_User(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/MR5356/repos":
+			w.WriteHeader(http.StatusNotFound)
+		case "/user":
+			_, _ = w.Write([]byte(`{"login":"mr5356"}`))
+		case "/user/repos":
+			// 令牌所属用户的仓库包括私有仓库
+			if q := r.URL.Query(); q.Get("affiliation") != "owner" || q.Get("visibility") != "all" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"name":"app","full_name":"MR5356/app","private":true,"clone_url":"https://github.com/MR5356/app.git"}]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGithub, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.ListRepos("MR5356", false)
+	if err != nil {
+		t.Fatalf("ListRepos() error = %v", err)
+	}
+	want := []*Repository{
+		{Name: "app", Path: "MR5356/app", Visibility: "private", HttpUrl: "https://github.com/MR5356/app.git"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRepos() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestGiteaListRepos_User(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/someone/repos":
+			w.WriteHeader(http.StatusNotFound)
+		case "/user":
+			_, _ = w.Write([]byte(`{"login":"someone"}`))
+		case "/user/repos":
+			_, _ = w.Write([]byte(`[{"name":"app","full_name":"someone/app","private":true},{"name":"lib","full_name":"org/lib"}]`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGitea, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.ListRepos("someone", false)
+	if err != nil {
+		t.Fatalf("ListRepos() error = %v", err)
+	}
+	want := []*Repository{{Name: "app", Path: "someone/app", Visibility: "private"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRepos() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestGitlabListRepos(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "token" || r.URL.RawPath != "/groups/group%2Fsub/projects" || r.URL.Query().Get("include_subgroups") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"path":"lib","path_with_namespace":"group/sub/deep/lib","visibility":"internal","forked_from_project":{"id":1},"http_url_to_repo":"https://gitlab.com/group/sub/deep/lib.git"}]`))
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGitlab, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.ListRepos("group/sub", true)
+	if err != nil {
+		t.Fatalf("ListRepos() error = %v", err)
+	}
+	want := []*Repository{
+		{Name: "lib", Path: "group/sub/deep/lib", Visibility: "internal", Fork: true, HttpUrl: "https://gitlab.com/group/sub/deep/lib.git"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRepos() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestGiteaCreateRepo(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/someone/syncer":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && r.URL.Path == "/orgs/someone/repos":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && r.URL.Path == "/user/repos":
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGitea, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.GetRepo("someone/syncer"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRepo() error = %v, want %v", err, ErrNotFound)
+	}
+	err = f.CreateRepo(&Repository{Path: "someone/syncer", Description: "desc", Visibility: "internal", DefaultBranch: "main"})
+	if err != nil {
+		t.Fatalf("CreateRepo() error = %v", err)
+	}
+	want := map[string]any{"name": "syncer", "description": "desc", "private": true, "default_branch": "main"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CreateRepo() body = %+v, want %+v", got, want)
+	}
+}
+
+func TestGithubCreateRepo(t *testing.T) {
+	bodies := make(map[string]map[string]any)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies[r.URL.Path] = body
+		switch r.URL.Path {
+		case "/orgs/org/repos", "/user/repos":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGithub, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 组织仓库保留 internal，个人仓库按私有仓库创建
+	for _, p := range []string{"org/syncer", "someone/syncer"} {
+		if err := f.CreateRepo(&Repository{Path: p, Visibility: "internal"}); err != nil {
+			t.Fatalf("CreateRepo() error = %v", err)
+		}
+	}
+	if got := bodies["/orgs/org/repos"]["visibility"]; got != "internal" {
+		t.Errorf("visibility of the organization repo = %v, want internal", got)
+	}
+	if got := bodies["/user/repos"]["private"]; got != true {
+		t.Errorf("private of the user repo = %v, want true", got)
+	}
+}
+
+func TestGithubReleases(t *testing.T) {
+	var uploaded string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/MR5356/syncer/releases":
+			_, _ = fmt.Fprintf(w, `[{"id":1,"tag_name":"v1.0.0","name":"v1.0.0","body":"notes","assets":[{"id":2,"name":"syncer.tar.gz","size":6,"url":"%s/repos/MR5356/syncer/releases/assets/2"}]}]`, server.URL)
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/MR5356/syncer/releases/assets/2":
+			if r.Header.Get("Accept") != "application/octet-stream" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte("binary"))
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/mirror/syncer/releases":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, `{"id":3,"tag_name":"v1.0.0","upload_url":"%s/uploads/3/assets{?name,label}"}`, server.URL)
+		case r.Method == http.MethodPost && r.URL.Path == "/uploads/3/assets":
+			body, _ := io.ReadAll(r.Body)
+			uploaded = r.URL.Query().Get("name") + ":" + string(body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGithub, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releases, err := f.ListReleases("MR5356/syncer")
+	if err != nil {
+		t.Fatalf("ListReleases() error = %v", err)
+	}
+	if len(releases) != 1 || releases[0].Body != "notes" || len(releases[0].Assets) != 1 {
+		t.Fatalf("ListReleases() got = %+v", releases)
+	}
+	rc, err := f.DownloadAsset("MR5356/syncer", releases[0].Assets[0])
+	if err != nil {
+		t.Fatalf("DownloadAsset() error = %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	_ = rc.Close()
+
+	created, err := f.CreateRelease("mirror/syncer", releases[0])
+	if err != nil {
+		t.Fatalf("CreateRelease() error = %v", err)
+	}
+	if err := f.UploadAsset("mirror/syncer", created, "syncer.tar.gz", int64(len(content)), bytes.NewReader(content)); err != nil {
+		t.Fatalf("UploadAsset() error = %v", err)
+	}
+	if uploaded != "syncer.tar.gz:binary" {
+		t.Errorf("UploadAsset() uploaded %q, want syncer.tar.gz:binary", uploaded)
+	}
+}
+
+func TestGiteaUploadAsset(t *testing.T) {
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/repos/mirror/syncer/releases/3/assets" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("attachment")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(file)
+		uploaded = header.Filename + ":" + string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	f, err := NewForge(TypeGitea, server.URL, "token", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.UploadAsset("mirror/syncer", &Release{Id: 3}, "syncer.tar.gz", 6, strings.NewReader("binary")); err != nil {
+		t.Fatalf("UploadAsset() error = %v", err)
+	}
+	if uploaded != "syncer.tar.gz:binary" {
+		t.Errorf("UploadAsset() uploaded %q, want syncer.tar.gz:binary", uploaded)
+	}
+}
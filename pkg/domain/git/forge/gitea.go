@@ -0,0 +1,185 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type gitea struct {
+	*client
+}
+
+type giteaRepo struct {
+	Name          string `json:"name"`
+	FullName      string `json:"full_name"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	Internal      bool   `json:"internal"`
+	Archived      bool   `json:"archived"`
+	Fork          bool   `json:"fork"`
+	CloneUrl      string `json:"clone_url"`
+	SshUrl        string `json:"ssh_url"`
+}
+
+func (r *giteaRepo) toRepository() *Repository {
+	visibility := "public"
+	if r.Private {
+		visibility = "private"
+	} else if r.Internal {
+		visibility = "internal"
+	}
+	return &Repository{
+		Name:          r.Name,
+		Path:          r.FullName,
+		Description:   r.Description,
+		DefaultBranch: r.DefaultBranch,
+		Visibility:    visibility,
+		Archived:      r.Archived,
+		Fork:          r.Fork,
+		HttpUrl:       r.CloneUrl,
+		SshUrl:        r.SshUrl,
+	}
+}
+
+func (g *gitea) ListRepos(owner string, _ bool) ([]*Repository, error) {
+	repos, err := list[giteaRepo](g.client, fmt.Sprintf("/orgs/%s/repos?limit=50", url.PathEscape(owner)))
+	if errors.Is(err, ErrNotFound) {
+		repos, err = g.listUserRepos(owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Repository, 0, len(repos))
+	for i := range repos {
+		res = append(res, repos[i].toRepository())
+	}
+	return res, nil
+}
+
+// listUserRepos lists the repositories of a user, /users/{owner}/repos only lists the public repositories
+func (g *gitea) listUserRepos(owner string) ([]giteaRepo, error) {
+	self, err := g.isUser(owner)
+	if err != nil {
+		return nil, err
+	}
+	if !self {
+		return list[giteaRepo](g.client, fmt.Sprintf("/users/%s/repos?limit=50", url.PathEscape(owner)))
+	}
+	// /user/repos 还包括用户有权限访问的其他仓库
+	repos, err := list[giteaRepo](g.client, "/user/repos?limit=50")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]giteaRepo, 0, len(repos))
+	for _, r := range repos {
+		if o, _ := splitPath(r.FullName); strings.EqualFold(o, owner) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (g *gitea) GetRepo(path string) (*Repository, error) {
+	repo := new(giteaRepo)
+	if _, err := g.do(http.MethodGet, fmt.Sprintf("%s/repos/%s", g.apiUrl, path), nil, repo); err != nil {
+		return nil, err
+	}
+	return repo.toRepository(), nil
+}
+
+// CreateRepo creates the repository private for the private and internal visibilities, gitea has no internal
+// repositories, a repository is only internal through the visibility of its owner
+func (g *gitea) CreateRepo(repo *Repository) error {
+	owner, name := splitPath(repo.Path)
+	body := map[string]any{
+		"name":        name,
+		"description": repo.Description,
+		// internal 是组织的可见性，仓库只能是公开或私有
+		"private": repo.Visibility != "public",
+	}
+	if repo.DefaultBranch != "" {
+		body["default_branch"] = repo.DefaultBranch
+	}
+	_, err := g.do(http.MethodPost, fmt.Sprintf("%s/orgs/%s/repos", g.apiUrl, url.PathEscape(owner)), body, nil)
+	if errors.Is(err, ErrNotFound) {
+		_, err = g.do(http.MethodPost, fmt.Sprintf("%s/user/repos", g.apiUrl), body, nil)
+	}
+	return err
+}
+
+func (g *gitea) SetDefaultBranch(path, branch string) error {
+	_, err := g.do(http.MethodPatch, fmt.Sprintf("%s/repos/%s", g.apiUrl, path), map[string]any{"default_branch": branch}, nil)
+	return err
+}
+
+type giteaRelease struct {
+	Id         int64  `json:"id"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	Assets     []struct {
+		Id                 int64  `json:"id"`
+		Name               string `json:"name"`
+		Size               int64  `json:"size"`
+		BrowserDownloadUrl string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *giteaRelease) toRelease() *Release {
+	res := &Release{
+		Id:         r.Id,
+		TagName:    r.TagName,
+		Name:       r.Name,
+		Body:       r.Body,
+		Draft:      r.Draft,
+		Prerelease: r.Prerelease,
+		Assets:     make([]*Asset, 0, len(r.Assets)),
+	}
+	for _, a := range r.Assets {
+		res.Assets = append(res.Assets, &Asset{Id: a.Id, Name: a.Name, Size: a.Size, Url: a.BrowserDownloadUrl})
+	}
+	return res
+}
+
+func (g *gitea) ListReleases(path string) ([]*Release, error) {
+	releases, err := list[giteaRelease](g.client, fmt.Sprintf("/repos/%s/releases?limit=50", path))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Release, 0, len(releases))
+	for i := range releases {
+		res = append(res, releases[i].toRelease())
+	}
+	return res, nil
+}
+
+func (g *gitea) CreateRelease(path string, release *Release) (*Release, error) {
+	body := map[string]any{
+		"tag_name":   release.TagName,
+		"name":       release.Name,
+		"body":       release.Body,
+		"draft":      release.Draft,
+		"prerelease": release.Prerelease,
+	}
+	created := new(giteaRelease)
+	if _, err := g.do(http.MethodPost, fmt.Sprintf("%s/repos/%s/releases", g.apiUrl, path), body, created); err != nil {
+		return nil, err
+	}
+	return created.toRelease(), nil
+}
+
+func (g *gitea) DownloadAsset(_ string, asset *Asset) (io.ReadCloser, error) {
+	return g.download(asset.Url, "")
+}
+
+func (g *gitea) UploadAsset(path string, release *Release, name string, _ int64, r io.Reader) error {
+	uploadUrl := fmt.Sprintf("%s/repos/%s/releases/%d/assets?name=%s", g.apiUrl, path, release.Id, url.QueryEscape(name))
+	return g.uploadForm(uploadUrl, "attachment", name, r, nil)
+}
@@ -0,0 +1,190 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type github struct {
+	*client
+}
+
+type githubRepo struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch"`
+	Visibility    string `json:"visibility"`
+	Private       bool   `json:"private"`
+	Archived      bool   `json:"archived"`
+	Fork          bool   `json:"fork"`
+	CloneUrl      string `json:"clone_url"`
+	SshUrl        string `json:"ssh_url"`
+}
+
+func (r *githubRepo) toRepository() *Repository {
+	visibility := r.Visibility
+	if visibility == "" {
+		visibility = "public"
+		if r.Private {
+			visibility = "private"
+		}
+	}
+	return &Repository{
+		Name:          r.Name,
+		Path:          r.FullName,
+		Description:   r.Description,
+		DefaultBranch: r.DefaultBranch,
+		Visibility:    visibility,
+		Archived:      r.Archived,
+		Fork:          r.Fork,
+		HttpUrl:       r.CloneUrl,
+		SshUrl:        r.SshUrl,
+	}
+}
+
+func (g *github) ListRepos(owner string, _ bool) ([]*Repository, error) {
+	repos, err := list[githubRepo](g.client, fmt.Sprintf("/orgs/%s/repos?type=all&per_page=100", url.PathEscape(owner)))
+	if errors.Is(err, ErrNotFound) {
+		repos, err = g.listUserRepos(owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Repository, 0, len(repos))
+	for i := range repos {
+		res = append(res, repos[i].toRepository())
+	}
+	return res, nil
+}
+
+// listUserRepos lists the repositories of a user, /users/{owner}/repos only lists the public repositories
+func (g *github) listUserRepos(owner string) ([]githubRepo, error) {
+	self, err := g.isUser(owner)
+	if err != nil {
+		return nil, err
+	}
+	if self {
+		return list[githubRepo](g.client, "/user/repos?affiliation=owner&visibility=all&per_page=100")
+	}
+	return list[githubRepo](g.client, fmt.Sprintf("/users/%s/repos?type=owner&per_page=100", url.PathEscape(owner)))
+}
+
+func (g *github) GetRepo(path string) (*Repository, error) {
+	repo := new(githubRepo)
+	if _, err := g.do(http.MethodGet, fmt.Sprintf("%s/repos/%s", g.apiUrl, path), nil, repo); err != nil {
+		return nil, err
+	}
+	return repo.toRepository(), nil
+}
+
+// CreateRepo creates the repository of an organization with the visibility of repo, internal repositories are
+// only supported by the organizations of GitHub Enterprise. Repositories of a user can not be internal, they are
+// created private for the private and internal visibilities
+func (g *github) CreateRepo(repo *Repository) error {
+	owner, name := splitPath(repo.Path)
+	body := map[string]any{
+		"name":        name,
+		"description": repo.Description,
+		// 个人仓库不支持 internal，按私有仓库创建
+		"private": repo.Visibility != "public",
+	}
+	orgBody := map[string]any{
+		"name":        name,
+		"description": repo.Description,
+		"visibility":  repo.Visibility,
+	}
+	_, err := g.do(http.MethodPost, fmt.Sprintf("%s/orgs/%s/repos", g.apiUrl, url.PathEscape(owner)), orgBody, nil)
+	if errors.Is(err, ErrNotFound) {
+		_, err = g.do(http.MethodPost, fmt.Sprintf("%s/user/repos", g.apiUrl), body, nil)
+	}
+	return err
+}
+
+func (g *github) SetDefaultBranch(path, branch string) error {
+	_, err := g.do(http.MethodPatch, fmt.Sprintf("%s/repos/%s", g.apiUrl, path), map[string]any{"default_branch": branch}, nil)
+	return err
+}
+
+type githubRelease struct {
+	Id         int64  `json:"id"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	UploadUrl  string `json:"upload_url"`
+	Assets     []struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		Url  string `json:"url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) toRelease() *Release {
+	res := &Release{
+		Id:         r.Id,
+		TagName:    r.TagName,
+		Name:       r.Name,
+		Body:       r.Body,
+		Draft:      r.Draft,
+		Prerelease: r.Prerelease,
+		UploadUrl:  r.UploadUrl,
+		Assets:     make([]*Asset, 0, len(r.Assets)),
+	}
+	for _, a := range r.Assets {
+		res.Assets = append(res.Assets, &Asset{Id: a.Id, Name: a.Name, Size: a.Size, Url: a.Url})
+	}
+	return res
+}
+
+func (g *github) ListReleases(path string) ([]*Release, error) {
+	releases, err := list[githubRelease](g.client, fmt.Sprintf("/repos/%s/releases?per_page=100", path))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Release, 0, len(releases))
+	for i := range releases {
+		res = append(res, releases[i].toRelease())
+	}
+	return res, nil
+}
+
+func (g *github) CreateRelease(path string, release *Release) (*Release, error) {
+	body := map[string]any{
+		"tag_name":   release.TagName,
+		"name":       release.Name,
+		"body":       release.Body,
+		"draft":      release.Draft,
+		"prerelease": release.Prerelease,
+	}
+	created := new(githubRelease)
+	if _, err := g.do(http.MethodPost, fmt.Sprintf("%s/repos/%s/releases", g.apiUrl, path), body, created); err != nil {
+		return nil, err
+	}
+	return created.toRelease(), nil
+}
+
+func (g *github) DownloadAsset(_ string, asset *Asset) (io.ReadCloser, error) {
+	// 资源的 api 地址在 Accept 为 octet-stream 时重定向到文件内容
+	return g.download(asset.Url, "application/octet-stream")
+}
+
+func (g *github) UploadAsset(path string, release *Release, name string, size int64, r io.Reader) error {
+	uploadUrl := release.UploadUrl
+	if i := strings.Index(uploadUrl, "{"); i >= 0 {
+		uploadUrl = uploadUrl[:i]
+	}
+	if uploadUrl == "" {
+		uploadUrl = fmt.Sprintf("%s/repos/%s/releases/%d/assets", g.apiUrl, path, release.Id)
+	}
+	return g.upload(uploadUrl+"?name="+url.QueryEscape(name), "application/octet-stream", size, r, nil)
+}
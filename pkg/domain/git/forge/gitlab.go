@@ -0,0 +1,167 @@
+package forge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type gitlab struct {
+	*client
+}
+
+type gitlabProject struct {
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	Description       string `json:"description"`
+	DefaultBranch     string `json:"default_branch"`
+	Visibility        string `json:"visibility"`
+	Archived          bool   `json:"archived"`
+	ForkedFromProject *struct {
+		Id int `json:"id"`
+	} `json:"forked_from_project"`
+	HttpUrlToRepo string `json:"http_url_to_repo"`
+	SshUrlToRepo  string `json:"ssh_url_to_repo"`
+}
+
+func (p *gitlabProject) toRepository() *Repository {
+	return &Repository{
+		Name:          p.Path,
+		Path:          p.PathWithNamespace,
+		Description:   p.Description,
+		DefaultBranch: p.DefaultBranch,
+		Visibility:    p.Visibility,
+		Archived:      p.Archived,
+		Fork:          p.ForkedFromProject != nil,
+		HttpUrl:       p.HttpUrlToRepo,
+		SshUrl:        p.SshUrlToRepo,
+	}
+}
+
+func (g *gitlab) ListRepos(owner string, recursive bool) ([]*Repository, error) {
+	projects, err := list[gitlabProject](g.client, fmt.Sprintf("/groups/%s/projects?include_subgroups=%t&per_page=100", url.PathEscape(owner), recursive))
+	if errors.Is(err, ErrNotFound) {
+		projects, err = list[gitlabProject](g.client, fmt.Sprintf("/users/%s/projects?per_page=100", url.PathEscape(owner)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Repository, 0, len(projects))
+	for i := range projects {
+		res = append(res, projects[i].toRepository())
+	}
+	return res, nil
+}
+
+func (g *gitlab) GetRepo(path string) (*Repository, error) {
+	project := new(gitlabProject)
+	if _, err := g.do(http.MethodGet, fmt.Sprintf("%s/projects/%s", g.apiUrl, url.PathEscape(path)), nil, project); err != nil {
+		return nil, err
+	}
+	return project.toRepository(), nil
+}
+
+func (g *gitlab) CreateRepo(repo *Repository) error {
+	owner, name := splitPath(repo.Path)
+	var namespace struct {
+		Id int `json:"id"`
+	}
+	if _, err := g.do(http.MethodGet, fmt.Sprintf("%s/namespaces/%s", g.apiUrl, url.PathEscape(owner)), nil, &namespace); err != nil {
+		return fmt.Errorf("get namespace %s failed: %w", owner, err)
+	}
+	body := map[string]any{
+		"name":         name,
+		"path":         name,
+		"namespace_id": namespace.Id,
+		"description":  repo.Description,
+	}
+	if repo.Visibility != "" {
+		body["visibility"] = repo.Visibility
+	}
+	_, err := g.do(http.MethodPost, fmt.Sprintf("%s/projects", g.apiUrl), body, nil)
+	return err
+}
+
+func (g *gitlab) SetDefaultBranch(path, branch string) error {
+	_, err := g.do(http.MethodPut, fmt.Sprintf("%s/projects/%s", g.apiUrl, url.PathEscape(path)), map[string]any{"default_branch": branch}, nil)
+	return err
+}
+
+type gitlabRelease struct {
+	TagName     string `json:"tag_name"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Assets      struct {
+		Links []struct {
+			Id             int64  `json:"id"`
+			Name           string `json:"name"`
+			Url            string `json:"url"`
+			DirectAssetUrl string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func (r *gitlabRelease) toRelease() *Release {
+	res := &Release{
+		TagName: r.TagName,
+		Name:    r.Name,
+		Body:    r.Description,
+		Assets:  make([]*Asset, 0, len(r.Assets.Links)),
+	}
+	for _, l := range r.Assets.Links {
+		u := l.DirectAssetUrl
+		if u == "" {
+			u = l.Url
+		}
+		res.Assets = append(res.Assets, &Asset{Id: l.Id, Name: l.Name, Url: u})
+	}
+	return res
+}
+
+func (g *gitlab) ListReleases(path string) ([]*Release, error) {
+	releases, err := list[gitlabRelease](g.client, fmt.Sprintf("/projects/%s/releases?per_page=100", url.PathEscape(path)))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Release, 0, len(releases))
+	for i := range releases {
+		res = append(res, releases[i].toRelease())
+	}
+	return res, nil
+}
+
+func (g *gitlab) CreateRelease(path string, release *Release) (*Release, error) {
+	body := map[string]any{
+		"tag_name":    release.TagName,
+		"name":        release.Name,
+		"description": release.Body,
+	}
+	created := new(gitlabRelease)
+	if _, err := g.do(http.MethodPost, fmt.Sprintf("%s/projects/%s/releases", g.apiUrl, url.PathEscape(path)), body, created); err != nil {
+		return nil, err
+	}
+	return created.toRelease(), nil
+}
+
+func (g *gitlab) DownloadAsset(_ string, asset *Asset) (io.ReadCloser, error) {
+	return g.download(asset.Url, "")
+}
+
+// UploadAsset uploads the file to the project and links it to the release, gitlab releases only hold links
+func (g *gitlab) UploadAsset(path string, release *Release, name string, _ int64, r io.Reader) error {
+	var uploaded struct {
+		FullPath string `json:"full_path"`
+	}
+	if err := g.uploadForm(fmt.Sprintf("%s/projects/%s/uploads", g.apiUrl, url.PathEscape(path)), "file", name, r, &uploaded); err != nil {
+		return err
+	}
+	link := map[string]any{
+		"name": name,
+		"url":  strings.TrimSuffix(g.apiUrl, "/api/v4") + uploaded.FullPath,
+	}
+	_, err := g.do(http.MethodPost, fmt.Sprintf("%s/projects/%s/releases/%s/assets/links", g.apiUrl, url.PathEscape(path), url.PathEscape(release.TagName)), link, nil)
+	return err
+}
@@ -0,0 +1,182 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"sync"
+	"time"
+)
+
+// backend transfers the objects of the bare mirror repositories, the refs and objects of the
+// mirror are read through go-git whichever backend wrote them
+type backend interface {
+	// Clone mirrors url into the empty dir, opts limits the fetched refs and history
+	Clone(dir, url string, auth transport.AuthMethod, opts *cloneOptions) (*git.Repository, error)
+	// Fetch fetches the refspecs of url into the mirror
+	Fetch(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error
+	// Push pushes the refspecs of the mirror to url, refspecs starting with + are force pushed
+	Push(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error
+	// Bundle writes the refs of the mirror without the objects reachable from the prerequisites to file
+	Bundle(repo *git.Repository, file string, header *bundleHeader) error
+}
+
+// newBackend returns the backend of the mapping, falling back to the global backend, the transfers of the
+// backend stop when ctx is canceled
+func newBackend(ctx context.Context, repo *config.Repo, cfg *config.Config) (backend, error) {
+	name := cfg.Backend
+	if repo != nil && repo.Backend != "" {
+		name = repo.Backend
+	}
+	// go-git 无法从浅克隆的仓库推送
+	if repo != nil && repo.Shallow() {
+		switch name {
+		case "":
+			name = config.BackendGit
+		case config.BackendGoGit:
+			return nil, fmt.Errorf("depth and since require the %s backend, go-git can not push shallow repositories", config.BackendGit)
+		}
+	}
+	switch name {
+	case "", config.BackendGoGit:
+		return &goGitBackend{ctx: ctx}, nil
+	case config.BackendGit:
+		return newSystemGitBackend(ctx)
+	}
+	return nil, fmt.Errorf("unsupported git backend %s, should be %s or %s", name, config.BackendGoGit, config.BackendGit)
+}
+
+// cloneMirror mirrors the repository into a new temporary directory, the caller removes the directory
+func cloneMirror(ctx context.Context, b backend, url string, auth transport.AuthMethod, opts *cloneOptions) (_ *git.Repository, _ string, err error) {
+	_, span := tracing.Start(ctx, "git.clone", attribute.String("url", configutil.RedactUrl(url)))
+	defer func() { tracing.End(span, err) }()
+
+	dirName, err := os.MkdirTemp("", "syncer-git-*")
+	if err != nil {
+		return nil, "", err
+	}
+
+	task.Logger(ctx).Infof("clone %s to %s", url, dirName)
+	repo, err := b.Clone(dirName, url, auth, opts)
+	if err != nil {
+		_ = os.RemoveAll(dirName)
+		return nil, "", err
+	}
+	return repo, dirName, nil
+}
+
+// pushMirror pushes the refspecs of the mirror to url with b and records the push metrics and span
+func pushMirror(ctx context.Context, b backend, repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error {
+	host := "local"
+	if u, e := gitutil.ParseUrl(url); e == nil && u.Host != "" {
+		host = u.Host
+	}
+	_, span := tracing.Start(ctx, "git.push", attribute.String("url", configutil.RedactUrl(url)), attribute.Int("refspecs", len(specs)))
+
+	start := time.Now()
+	err := b.Push(repo, url, auth, specs)
+	metrics.ObservePush(host, start, err)
+	tracing.End(span, err)
+	return err
+}
+
+// goGitBackend transfers objects in-process with go-git. A go-git storage is not safe for concurrent use, so
+// each push reads the mirror through its own storage
+type goGitBackend struct {
+	ctx context.Context
+
+	// pushLock serializes the pushes of mirrors whose storage can not be reopened, like in-memory mirrors
+	pushLock sync.Mutex
+}
+
+func (b *goGitBackend) Clone(dir, url string, auth transport.AuthMethod, opts *cloneOptions) (*git.Repository, error) {
+	storage := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+	if opts == nil {
+		return git.CloneContext(b.ctx, storage, nil, &git.CloneOptions{
+			URL:             url,
+			Mirror:          true,
+			Auth:            auth,
+			InsecureSkipTLS: true,
+		})
+	}
+	if opts.shallow() {
+		return nil, fmt.Errorf("go-git can not push shallow repositories")
+	}
+
+	repo, err := git.Init(storage, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Fetch(repo, url, auth, opts.refSpecs()); err != nil {
+		return nil, err
+	}
+	return repo, opts.setHead(repo)
+}
+
+func (b *goGitBackend) Fetch(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error {
+	remote := git.NewRemote(repo.Storer, &gitConfig.RemoteConfig{
+		Name: "fetch",
+		URLs: []string{url},
+	})
+	err := remote.FetchContext(b.ctx, &git.FetchOptions{
+		RefSpecs:        specs,
+		Auth:            auth,
+		InsecureSkipTLS: true,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) || errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil
+	}
+	return err
+}
+
+func (b *goGitBackend) Push(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error {
+	s := repo.Storer
+	if fs, ok := repo.Storer.(*filesystem.Storage); ok {
+		s = filesystem.NewStorage(fs.Filesystem(), cache.NewObjectLRUDefault())
+	} else {
+		b.pushLock.Lock()
+		defer b.pushLock.Unlock()
+	}
+	// 临时的 remote 不写入配置，也没有 fetch refspec，推送不会修改镜像仓库
+	remote := git.NewRemote(s, &gitConfig.RemoteConfig{
+		Name: "push",
+		URLs: []string{url},
+	})
+	err := remote.PushContext(b.ctx, &git.PushOptions{
+		RemoteName:      "push",
+		Auth:            auth,
+		InsecureSkipTLS: true,
+		RefSpecs:        specs,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		task.Logger(b.ctx).Warnf("%s is up to date", url)
+		return nil
+	}
+	return err
+}
+
+func (b *goGitBackend) Bundle(repo *git.Repository, file string, header *bundleHeader) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := writeBundle(f, repo.Storer, header); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,120 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemGitBackend(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	dests := []string{newTestBareRepo(t), newTestBareRepo(t)}
+
+	cfg := config.NewConfig()
+	cfg.Backend = config.BackendGit
+	repo := &config.Repo{
+		Destinations: dests,
+		Rename:       []*config.RenameRule{{Type: config.RefTypeHeads, Prefix: "upstream/"}},
+	}
+	task := NewSyncTask(src, dests, repo, cfg, nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, r := range task.Results() {
+		if r.Status != StatusPushed {
+			t.Errorf("Results() = %s %s, want %s", r.Destination, r.Status, StatusPushed)
+		}
+		refs, err := listRefs(r.Destination, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := refs["refs/heads/upstream/master"]; !ok {
+			t.Errorf("refs of %s = %v, want refs/heads/upstream/master", r.Destination, refs)
+		}
+	}
+
+	dir := t.TempDir()
+	state, err := LoadBundleState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := NewExportTask(src, src, &config.Repo{}, cfg, dir, "1", state, false)
+	if err := export.Run(); err != nil {
+		t.Fatalf("export Run() error = %v", err)
+	}
+	dest := newTestBareRepo(t)
+	imp := NewImportTask(src, []string{dest}, []string{filepath.Join(dir, export.Entry().File)}, &config.Repo{}, cfg)
+	if err := imp.Run(); err != nil {
+		t.Fatalf("import Run() error = %v", err)
+	}
+	if !testUpToDate(t, src, dest) {
+		t.Errorf("%s is not up to date after import", dest)
+	}
+}
+
+func TestGoGitBackend(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	dests := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		dests = append(dests, newTestBareRepo(t))
+	}
+
+	// 多个目标同时推送，每次推送使用独立的存储读取镜像仓库
+	cfg := config.NewConfig()
+	cfg.Backend = config.BackendGoGit
+	task := NewSyncTask(src, dests, &config.Repo{Destinations: dests}, cfg, nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, r := range task.Results() {
+		if r.Status != StatusPushed {
+			t.Errorf("Results() = %s %s %v, want %s", r.Destination, r.Status, r.Error, StatusPushed)
+		}
+		if !testUpToDate(t, src, r.Destination) {
+			t.Errorf("%s is not up to date", r.Destination)
+		}
+	}
+}
+
+func Test_gitEnv(t *testing.T) {
+	env, cleanup, err := gitEnv("https://github.com/MR5356/syncer.git", &http.BasicAuth{Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatalf("gitEnv() error = %v", err)
+	}
+	defer cleanup()
+	joined := strings.Join(env, "\n")
+	if !strings.Contains(joined, "GIT_CONFIG_VALUE_0=Authorization: Basic dXNlcjpwYXNz") {
+		t.Errorf("gitEnv() = %v, want basic auth header", env)
+	}
+
+	_, _, err = gitEnv("git@github.com:MR5356/syncer.git", &sshAuth{encrypted: true})
+	if err == nil {
+		t.Errorf("gitEnv() error = nil, want error for password protected keys")
+	}
+}
+
+func Test_sshUrlWithUser(t *testing.T) {
+	tests := []struct {
+		url  string
+		user string
+		want string
+	}{
+		{url: "git@github.com:MR5356/syncer.git", user: "deploy", want: "deploy@github.com:MR5356/syncer.git"},
+		{url: "ssh://git@git.internal:2222/mirror/syncer.git", user: "deploy", want: "ssh://deploy@git.internal:2222/mirror/syncer.git"},
+		{url: "git@github.com:MR5356/syncer.git", user: "", want: "git@github.com:MR5356/syncer.git"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := sshUrlWithUser(tt.url, tt.user); got != tt.want {
+				t.Errorf("sshUrlWithUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package task
+
+import (
+	"fmt"
+	"github.com/avast/retry-go"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	StatusConflict = "conflict"
+
+	sideSource      = "source"
+	sideDestination = "destination"
+
+	// destinationRefPrefix holds the refs fetched from the destination in the mirror of the source
+	destinationRefPrefix = "refs/syncer/destination/"
+	conflictRefPrefix    = "refs/conflicts/"
+)
+
+// refUpdate is a ref to push to one side of a bidirectional sync
+type refUpdate struct {
+	name plumbing.ReferenceName
+	// local is the name of the ref in the mirror
+	local plumbing.ReferenceName
+	hash  plumbing.Hash
+}
+
+func (u *refUpdate) refSpec(force bool) gitConfig.RefSpec {
+	if force {
+		return gitConfig.RefSpec(fmt.Sprintf("+%s:%s", u.local, u.name))
+	}
+	return gitConfig.RefSpec(fmt.Sprintf("%s:%s", u.local, u.name))
+}
+
+// bidiPlan is the result of comparing the refs of both sides
+type bidiPlan struct {
+	// toSource and toDestination are fast-forwards and new refs
+	toSource      []*refUpdate
+	toDestination []*refUpdate
+	// conflicts are the refs which diverged on both sides
+	conflicts []plumbing.ReferenceName
+}
+
+// syncBidirectional fast-forwards the refs advanced on only one side to the other side and reports the
+// refs which diverged as conflicts, refs deleted on one side are recreated from the other side
+func (t *SyncTask) syncBidirectional() error {
+	dest := t.destinations[0]
+
+	srcAuth, srcUrl, err := getAuth(t.source, t.cfg)
+	if err != nil {
+		return err
+	}
+	destAuth, destUrl, err := getAuth(dest, t.cfg)
+	if err != nil {
+		t.setResult(dest, StatusFailed, err)
+		return t.err()
+	}
+
+	srcRefs, err := listRefs(srcUrl, srcAuth)
+	if err != nil {
+		return err
+	}
+	destRefs, err := listRefs(destUrl, destAuth)
+	if err != nil {
+		t.setResult(dest, StatusFailed, err)
+		return t.err()
+	}
+	if equalRefs(mirroredRefs(srcRefs), mirroredRefs(destRefs)) {
+		t.log.Infof("%s is up to date with %s, skip fetching", destUrl, srcUrl)
+		t.setResult(dest, StatusUpToDate, nil)
+		return nil
+	}
+
+	b, err := newBackend(t.ctx, t.repo, t.cfg)
+	if err != nil {
+		return err
+	}
+	repo, dirName, err := cloneMirror(t.ctx, b, srcUrl, srcAuth, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		t.log.Infof("clean %s", dirName)
+		_ = os.RemoveAll(dirName)
+	}()
+	t.log.Infof("fetch %s", destUrl)
+	if err := b.Fetch(repo, destUrl, destAuth, []gitConfig.RefSpec{gitConfig.RefSpec("+refs/*:" + destinationRefPrefix + "*")}); err != nil {
+		t.setResult(dest, StatusFailed, err)
+		return t.err()
+	}
+
+	// 以拉取后的本地引用为准，避免列出引用之后两端又发生了变化
+	localRefs, err := repoRefs(repo)
+	if err != nil {
+		return err
+	}
+	fetchedRefs, err := destinationRefs(repo)
+	if err != nil {
+		return err
+	}
+	plan, err := planBidirectional(repo, localRefs, fetchedRefs)
+	if err != nil {
+		return err
+	}
+
+	// 只有快进与新建引用不使用强制推送，推送期间对端有新的提交时推送会被拒绝，下次同步时重新比较
+	if err := t.pushUpdates(b, repo, srcUrl, srcAuth, plan.toSource, false); err != nil {
+		t.setResult(dest, StatusFailed, fmt.Errorf("update %s failed: %w", srcUrl, err))
+		return t.err()
+	}
+	if err := t.pushUpdates(b, repo, destUrl, destAuth, plan.toDestination, false); err != nil {
+		t.setResult(dest, StatusFailed, err)
+		return t.err()
+	}
+
+	if len(plan.conflicts) == 0 {
+		t.setResult(dest, StatusPushed, nil)
+		return nil
+	}
+
+	if t.repo.ParkConflicts {
+		if err := t.parkConflicts(b, repo, srcUrl, srcAuth, destUrl, destAuth, plan.conflicts, localRefs, fetchedRefs); err != nil {
+			t.setResult(dest, StatusFailed, err)
+			return t.err()
+		}
+	}
+	names := make([]string, 0, len(plan.conflicts))
+	for _, name := range plan.conflicts {
+		names = append(names, name.String())
+	}
+	err = fmt.Errorf("%d refs diverged on both sides: %s", len(names), strings.Join(names, ", "))
+	t.setResult(dest, StatusConflict, err)
+	// 冲突需要人工处理，重试不会改变结果
+	return retry.Unrecoverable(t.err())
+}
+
+// destinationRefs returns the refs fetched from the destination with their names on the destination
+func destinationRefs(repo *git.Repository) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[plumbing.ReferenceName]plumbing.Hash)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if ref.Type() == plumbing.HashReference && strings.HasPrefix(name, destinationRefPrefix) {
+			res[plumbing.ReferenceName("refs/"+strings.TrimPrefix(name, destinationRefPrefix))] = ref.Hash()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mirroredRefs(res), nil
+}
+
+// planBidirectional compares the refs of both sides, a branch is fast-forwarded when the other side is its
+// ancestor, tags and branches which diverged are conflicts
+func planBidirectional(repo *git.Repository, srcRefs, destRefs map[plumbing.ReferenceName]plumbing.Hash) (*bidiPlan, error) {
+	names := make(map[plumbing.ReferenceName]bool)
+	for name := range srcRefs {
+		names[name] = true
+	}
+	for name := range destRefs {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name.String())
+	}
+	sort.Strings(sorted)
+
+	plan := new(bidiPlan)
+	for _, n := range sorted {
+		name := plumbing.ReferenceName(n)
+		srcHash, inSrc := srcRefs[name]
+		destHash, inDest := destRefs[name]
+		toDest := &refUpdate{name: name, local: name, hash: srcHash}
+		toSrc := &refUpdate{name: name, local: destinationLocalName(name), hash: destHash}
+
+		switch {
+		case inSrc && inDest && srcHash == destHash:
+		case !inDest:
+			plan.toDestination = append(plan.toDestination, toDest)
+		case !inSrc:
+			plan.toSource = append(plan.toSource, toSrc)
+		case !name.IsBranch():
+			plan.conflicts = append(plan.conflicts, name)
+		default:
+			srcAhead, err := isAncestor(repo, destHash, srcHash)
+			if err != nil {
+				return nil, err
+			}
+			destAhead, err := isAncestor(repo, srcHash, destHash)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case srcAhead:
+				plan.toDestination = append(plan.toDestination, toDest)
+			case destAhead:
+				plan.toSource = append(plan.toSource, toSrc)
+			default:
+				plan.conflicts = append(plan.conflicts, name)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// isAncestor reports whether the commit a is an ancestor of the commit b
+func isAncestor(repo *git.Repository, a, b plumbing.Hash) (bool, error) {
+	ca, err := peelCommit(repo, a)
+	if err != nil {
+		return false, err
+	}
+	cb, err := peelCommit(repo, b)
+	if err != nil {
+		return false, err
+	}
+	return ca.IsAncestor(cb)
+}
+
+func destinationLocalName(name plumbing.ReferenceName) plumbing.ReferenceName {
+	return plumbing.ReferenceName(destinationRefPrefix + strings.TrimPrefix(name.String(), "refs/"))
+}
+
+// conflictRefName returns the name a diverged ref of side is parked under on the other side
+func conflictRefName(side string, name plumbing.ReferenceName) plumbing.ReferenceName {
+	return plumbing.ReferenceName(conflictRefPrefix + side + "/" + strings.TrimPrefix(name.String(), "refs/"))
+}
+
+// pushUpdates pushes the updates to url and verifies them
+func (t *SyncTask) pushUpdates(b backend, repo *git.Repository, url string, auth transport.AuthMethod, updates []*refUpdate, force bool) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	specs := make([]gitConfig.RefSpec, 0, len(updates))
+	expected := make(map[plumbing.ReferenceName]plumbing.Hash, len(updates))
+	for _, u := range updates {
+		specs = append(specs, u.refSpec(force))
+		expected[u.name] = u.hash
+	}
+	t.log.Infof("push %d refs to %s", len(updates), url)
+	if err := pushMirror(t.ctx, b, repo, url, auth, specs); err != nil {
+		return err
+	}
+	return verifyPush(url, auth, expected)
+}
+
+// parkConflicts pushes the diverged refs of each side to refs/conflicts/<side>/* of the other side
+func (t *SyncTask) parkConflicts(b backend, repo *git.Repository, srcUrl string, srcAuth transport.AuthMethod, destUrl string, destAuth transport.AuthMethod,
+	conflicts []plumbing.ReferenceName, srcRefs, destRefs map[plumbing.ReferenceName]plumbing.Hash) error {
+	toSrc := make([]*refUpdate, 0, len(conflicts))
+	toDest := make([]*refUpdate, 0, len(conflicts))
+	for _, name := range conflicts {
+		toDest = append(toDest, &refUpdate{name: conflictRefName(sideSource, name), local: name, hash: srcRefs[name]})
+		toSrc = append(toSrc, &refUpdate{name: conflictRefName(sideDestination, name), local: destinationLocalName(name), hash: destRefs[name]})
+	}
+	t.log.Warnf("park %d diverged refs under %s", len(conflicts), conflictRefPrefix)
+	if err := t.pushUpdates(b, repo, srcUrl, srcAuth, toSrc, true); err != nil {
+		return fmt.Errorf("park conflicts on %s failed: %w", srcUrl, err)
+	}
+	if err := t.pushUpdates(b, repo, destUrl, destAuth, toDest, true); err != nil {
+		return fmt.Errorf("park conflicts on %s failed: %w", destUrl, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func pushTestRepo(t *testing.T, repo *git.Repository, url string) {
+	remote := git.NewRemote(repo.Storer, &gitConfig.RemoteConfig{Name: "test", URLs: []string{url}})
+	if err := remote.Push(&git.PushOptions{RemoteName: "test", RefSpecs: []gitConfig.RefSpec{"+refs/heads/*:refs/heads/*"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func headOf(t *testing.T, url string) plumbing.Hash {
+	refs, err := listRefs(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refs[plumbing.Master]
+}
+
+func TestSyncTask_Bidirectional(t *testing.T) {
+	a, b := newTestBareRepo(t), newTestBareRepo(t)
+	work, err := git.PlainOpen(newTestRepo(t, map[string]string{"README.md": "syncer"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestRepo(t, work, a)
+
+	repo := &config.Repo{Destinations: []string{b}, Bidirectional: true, ParkConflicts: true}
+	run := func() (*SyncTask, error) {
+		task := NewSyncTask(a, []string{b}, repo, config.NewConfig(), nil)
+		return task, task.Run()
+	}
+
+	// a -> b
+	if _, err := run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if headOf(t, a) != headOf(t, b) {
+		t.Fatalf("master of %s is not synced to %s", a, b)
+	}
+
+	// b 前进后快进到 a
+	commitTestFiles(t, work, map[string]string{"b.txt": "b"})
+	pushTestRepo(t, work, b)
+	if _, err := run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if headOf(t, a) != headOf(t, b) {
+		t.Fatalf("master of %s is not fast-forwarded to %s", a, b)
+	}
+
+	// 两端分叉时报告冲突并保留双方的提交
+	other, err := git.PlainClone(filepath.Join(t.TempDir(), "other"), false, &git.CloneOptions{URL: a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, work, map[string]string{"work.txt": "work"})
+	pushTestRepo(t, work, a)
+	commitTestFiles(t, other, map[string]string{"other.txt": "other"})
+	pushTestRepo(t, other, b)
+	headA, headB := headOf(t, a), headOf(t, b)
+
+	task, err := run()
+	if err == nil || !strings.Contains(err.Error(), "refs/heads/master") {
+		t.Fatalf("Run() error = %v, want conflict of refs/heads/master", err)
+	}
+	if r := task.Results(); len(r) != 1 || r[0].Status != StatusConflict {
+		t.Errorf("Results() = %+v, want conflict", r[0])
+	}
+	if headOf(t, a) != headA || headOf(t, b) != headB {
+		t.Errorf("diverged master is overwritten")
+	}
+
+	refsA, _ := listRefs(a, nil)
+	refsB, _ := listRefs(b, nil)
+	if refsA["refs/conflicts/destination/heads/master"] != headB {
+		t.Errorf("refs of %s = %v, want parked master of the destination", a, refsA)
+	}
+	if refsB["refs/conflicts/source/heads/master"] != headA {
+		t.Errorf("refs of %s = %v, want parked master of the source", b, refsB)
+	}
+}
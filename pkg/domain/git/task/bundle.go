@@ -0,0 +1,98 @@
+package task
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"io"
+	"sort"
+	"strings"
+)
+
+// bundleSignature is the first line of a v2 git bundle, readable by git bundle verify/unbundle and git clone
+const bundleSignature = "# v2 git bundle"
+
+var errInvalidBundle = errors.New("invalid git bundle")
+
+// bundleHeader is the ref list of a git bundle
+type bundleHeader struct {
+	// Prerequisites are the commits the receiver must already have
+	Prerequisites []plumbing.Hash
+	Refs          map[plumbing.ReferenceName]plumbing.Hash
+}
+
+// writeBundle writes the refs and every object reachable from them but not from the prerequisites as a git bundle
+func writeBundle(w io.Writer, s storer.EncodedObjectStorer, header *bundleHeader) error {
+	names := make([]string, 0, len(header.Refs))
+	tips := make([]plumbing.Hash, 0, len(header.Refs))
+	for name, hash := range header.Refs {
+		names = append(names, name.String())
+		tips = append(tips, hash)
+	}
+	sort.Strings(names)
+
+	hashes, err := revlist.Objects(s, tips, header.Prerequisites)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	_, _ = fmt.Fprintln(bw, bundleSignature)
+	for _, hash := range header.Prerequisites {
+		_, _ = fmt.Fprintf(bw, "-%s\n", hash)
+	}
+	for _, name := range names {
+		_, _ = fmt.Fprintf(bw, "%s %s\n", header.Refs[plumbing.ReferenceName(name)], name)
+	}
+	_, _ = fmt.Fprintln(bw)
+
+	if _, err := packfile.NewEncoder(bw, s, false).Encode(hashes, 10); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readBundlePack stores the objects of the pack following the bundle header
+func readBundlePack(br *bufio.Reader, s storer.Storer) error {
+	return packfile.UpdateObjectStorage(s, br)
+}
+
+// readBundleHeader reads the prerequisites and refs of a git bundle
+func readBundleHeader(br *bufio.Reader) (*bundleHeader, error) {
+	line, err := br.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != bundleSignature {
+		return nil, fmt.Errorf("%w: unsupported signature %q", errInvalidBundle, strings.TrimSpace(line))
+	}
+
+	header := &bundleHeader{Refs: make(map[plumbing.ReferenceName]plumbing.Hash)}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidBundle, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return header, nil
+		}
+
+		// 前置提交的格式为 -<hash> [comment]
+		if strings.HasPrefix(line, "-") {
+			hash, _, _ := strings.Cut(line[1:], " ")
+			if !plumbing.IsHash(hash) {
+				return nil, fmt.Errorf("%w: bad prerequisite %q", errInvalidBundle, line)
+			}
+			header.Prerequisites = append(header.Prerequisites, plumbing.NewHash(hash))
+			continue
+		}
+
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || !plumbing.IsHash(hash) {
+			return nil, fmt.Errorf("%w: bad ref %q", errInvalidBundle, line)
+		}
+		header.Refs[plumbing.ReferenceName(name)] = plumbing.NewHash(hash)
+	}
+}
@@ -0,0 +1,109 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// exportBundles runs an export task for src and writes its manifest like the git client does
+func exportBundles(t *testing.T, cfg *config.Config, src, dir string, state *BundleState, stamp string) *BundleEntry {
+	task := NewExportTask(src, src, &config.Repo{}, cfg, dir, stamp, state, false)
+	if err := task.Run(); err != nil {
+		t.Fatalf("export Run() error = %v", err)
+	}
+	if task.Entry() != nil {
+		manifest := &BundleManifest{Created: time.Now(), Bundles: []*BundleEntry{task.Entry()}}
+		if err := manifest.Save(filepath.Join(dir, ManifestFile(stamp))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return task.Entry()
+}
+
+func importBundles(t *testing.T, cfg *config.Config, dir string) {
+	list, err := GenerateImportTaskList(cfg, dir)
+	if err != nil {
+		t.Fatalf("GenerateImportTaskList() error = %v", err)
+	}
+	for task := range list.Iterator() {
+		if err := task.Run(); err != nil {
+			t.Fatalf("import Run() error = %v", err)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	dest := newTestBareRepo(t)
+	dir := t.TempDir()
+
+	cfg := config.NewConfig()
+	cfg.Repos[src] = dest
+	state, err := LoadBundleState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := exportBundles(t, cfg, src, dir, state, "1")
+	if full == nil || len(full.Prerequisites) != 0 {
+		t.Fatalf("full export = %+v, want a bundle without prerequisites", full)
+	}
+	if _, err := exec.LookPath("git"); err == nil {
+		out, err := exec.Command("git", "bundle", "list-heads", filepath.Join(dir, full.File)).CombinedOutput()
+		if err != nil {
+			t.Errorf("git bundle list-heads error = %v: %s", err, out)
+		}
+	}
+	if e := exportBundles(t, cfg, src, dir, state, "2"); e != nil {
+		t.Errorf("export of unchanged source = %+v, want nil", e)
+	}
+
+	importBundles(t, cfg, dir)
+	if !testUpToDate(t, src, dest) {
+		t.Fatalf("%s is not up to date after full import", dest)
+	}
+
+	repo, err := git.PlainOpen(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, repo, map[string]string{"main.go": "package main"})
+
+	incremental := exportBundles(t, cfg, src, dir, state, "3")
+	if incremental == nil || len(incremental.Prerequisites) != 1 {
+		t.Fatalf("incremental export = %+v, want a bundle with 1 prerequisite", incremental)
+	}
+
+	// 只保留增量包，前置提交需要从目标仓库获取
+	importDir := t.TempDir()
+	for _, f := range []string{incremental.File, ManifestFile("3")} {
+		content, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(importDir, f), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	importBundles(t, cfg, importDir)
+	if !testUpToDate(t, src, dest) {
+		t.Errorf("%s is not up to date after incremental import", dest)
+	}
+
+	// 目标仓库缺少前置提交时导入失败
+	cfg.Repos[src] = newTestBareRepo(t)
+	list, err := GenerateImportTaskList(cfg, importDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for task := range list.Iterator() {
+		if err := task.Run(); err == nil {
+			t.Errorf("import Run() error = nil, want missing prerequisite")
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BundleEntry describes a bundle written by git export
+type BundleEntry struct {
+	// Mapping is the key of the mapping in repos, used by git import to find the destinations
+	Mapping string `json:"mapping"`
+	// Source is the source url without credentials
+	Source        string            `json:"source"`
+	File          string            `json:"file"`
+	Refs          map[string]string `json:"refs"`
+	Prerequisites []string          `json:"prerequisites,omitempty"`
+}
+
+// BundleManifest lists the bundles written by one git export run, git import applies the manifests in name order
+type BundleManifest struct {
+	Created time.Time      `json:"created"`
+	Bundles []*BundleEntry `json:"bundles"`
+}
+
+// ManifestFile returns the manifest file name of an export run
+func ManifestFile(stamp string) string {
+	return fmt.Sprintf("manifest-%s.json", stamp)
+}
+
+func (m *BundleManifest) Save(file string) error {
+	return writeJSON(file, m)
+}
+
+// BundleState records the refs exported last time for each source, so that the next export only contains the new objects
+type BundleState struct {
+	lock  sync.Mutex
+	Repos map[string]map[string]string `json:"repos"`
+}
+
+// LoadBundleState reads the state file, a missing file returns an empty state
+func LoadBundleState(file string) (*BundleState, error) {
+	state := &BundleState{Repos: make(map[string]map[string]string)}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("invalid bundle state %s: %s", file, err)
+	}
+	if state.Repos == nil {
+		state.Repos = make(map[string]map[string]string)
+	}
+	return state, nil
+}
+
+func (s *BundleState) Save(file string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return writeJSON(file, s)
+}
+
+func (s *BundleState) get(source string) map[plumbing.ReferenceName]plumbing.Hash {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for name, hash := range s.Repos[source] {
+		res[plumbing.ReferenceName(name)] = plumbing.NewHash(hash)
+	}
+	return res
+}
+
+func (s *BundleState) set(source string, refs map[plumbing.ReferenceName]plumbing.Hash) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Repos[source] = refsToStrings(refs)
+}
+
+type ExportTask struct {
+	name    string
+	mapping string
+	source  string
+
+	repo  *config.Repo
+	cfg   *config.Config
+	dir   string
+	stamp string
+	state *BundleState
+	full  bool
+
+	entry *BundleEntry
+
+	ctx context.Context
+	log *logrus.Entry
+}
+
+func NewExportTask(mapping, source string, repo *config.Repo, cfg *config.Config, dir, stamp string, state *BundleState, full bool) *ExportTask {
+	return &ExportTask{
+		name:    fmt.Sprintf("%s -> %s", configutil.RedactUrl(source), dir),
+		mapping: mapping,
+		source:  source,
+
+		repo:  repo,
+		cfg:   cfg,
+		dir:   dir,
+		stamp: stamp,
+		state: state,
+		full:  full,
+
+		ctx: context.Background(),
+		log: task.Logger(context.Background()),
+	}
+}
+
+// GenerateExportTaskList creates an export task for each source of the repos, the bundle files are suffixed with stamp
+func GenerateExportTaskList(cfg *config.Config, dir, stamp string, state *BundleState, full bool) (*task.List, error) {
+	list := task.NewTaskList()
+	for source, dest := range cfg.Repos {
+		repo, err := config.ParseRepo(source, dest)
+		if err != nil {
+			return nil, err
+		}
+		sources, err := expandSources(cfg, source, repo)
+		if err != nil {
+			return nil, err
+		}
+		for src := range sources {
+			t := NewExportTask(source, src, repo, cfg, dir, stamp, state, full)
+			logrus.Infof("generate export task: %s", t.Name())
+			list.Add(t)
+		}
+	}
+	return list, nil
+}
+
+func (t *ExportTask) Name() string {
+	return t.name
+}
+
+func (t *ExportTask) Mapping() string {
+	return t.mapping
+}
+
+// Entry returns the written bundle, nil if the source is unchanged since the last export
+func (t *ExportTask) Entry() *BundleEntry {
+	return t.entry
+}
+
+func (t *ExportTask) Run() error {
+	return t.RunContext(context.Background())
+}
+
+// RunContext runs the task with the logger of ctx, it stops fetching when ctx is canceled
+func (t *ExportTask) RunContext(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "git.ExportTask.Run", attribute.String("task", t.Name()))
+	defer func() { tracing.End(span, err) }()
+	t.log = task.Logger(ctx).WithField(task.FieldSource, configutil.RedactUrl(t.source))
+	t.ctx = task.WithLogger(ctx, t.log)
+	srcAuth, srcUrl, err := getAuth(t.source, t.cfg)
+	if err != nil {
+		return err
+	}
+	key := stripUrl(srcUrl)
+
+	// 浅克隆的提交缺少父提交，无法作为后续增量导出的前置条件
+	if t.repo.Shallow() {
+		return fmt.Errorf("export of %s does not support depth and since", srcUrl)
+	}
+	// 只包含单个引用的导出会使导出状态丢失其它引用
+	if t.repo.Ref != "" {
+		return fmt.Errorf("export of %s does not support ref", srcUrl)
+	}
+	// bundle 中是未经改写的历史，不能导出需要排除内容的仓库
+	if t.repo.Strip != nil {
+		return fmt.Errorf("export of %s does not support strip", srcUrl)
+	}
+	opts, err := newCloneOptions(t.repo, srcUrl, srcAuth)
+	if err != nil {
+		return err
+	}
+
+	last := make(map[plumbing.ReferenceName]plumbing.Hash)
+	if !t.full {
+		last = t.state.get(key)
+	}
+	if len(last) > 0 {
+		srcRefs, err := listRefs(srcUrl, srcAuth)
+		if err != nil {
+			t.log.Debugf("list refs of %s failed: %s", srcUrl, err)
+		} else if equalRefs(mirroredRefs(opts.filter(srcRefs)), last) {
+			t.log.Infof("%s is unchanged since the last export, skip", srcUrl)
+			return nil
+		}
+	}
+
+	b, err := newBackend(t.ctx, t.repo, t.cfg)
+	if err != nil {
+		return err
+	}
+	repo, dirName, err := cloneMirror(t.ctx, b, srcUrl, srcAuth, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		t.log.Infof("clean %s", dirName)
+		_ = os.RemoveAll(dirName)
+	}()
+
+	refs, err := repoRefs(repo)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		t.log.Warnf("%s has no refs, skip", srcUrl)
+		return nil
+	}
+	if equalRefs(refs, last) {
+		t.log.Infof("%s is unchanged since the last export, skip", srcUrl)
+		return nil
+	}
+
+	// 上次导出的提交作为前置条件，导入端需要已经拥有这些提交
+	header := &bundleHeader{Refs: make(map[plumbing.ReferenceName]plumbing.Hash)}
+	seen := make(map[plumbing.Hash]bool)
+	for _, hash := range last {
+		commit, err := peelCommit(repo, hash)
+		if err != nil {
+			t.log.Debugf("skip prerequisite %s: %s", hash, err)
+			continue
+		}
+		if !seen[commit.Hash] {
+			seen[commit.Hash] = true
+			header.Prerequisites = append(header.Prerequisites, commit.Hash)
+		}
+	}
+	for name, hash := range refs {
+		header.Refs[name] = hash
+	}
+	if head, err := repo.Head(); err == nil {
+		header.Refs[plumbing.HEAD] = head.Hash()
+	}
+
+	file := fmt.Sprintf("%s-%s.bundle", bundleName(srcUrl), t.stamp)
+	if err := writeBundleFile(b, filepath.Join(t.dir, file), repo, header); err != nil {
+		return fmt.Errorf("write bundle of %s failed: %s", srcUrl, err)
+	}
+	t.log.Infof("export %s to %s with %d refs and %d prerequisites", srcUrl, file, len(refs), len(header.Prerequisites))
+
+	t.entry = &BundleEntry{
+		Mapping: t.mapping,
+		Source:  key,
+		File:    file,
+		Refs:    refsToStrings(header.Refs),
+	}
+	for _, hash := range header.Prerequisites {
+		t.entry.Prerequisites = append(t.entry.Prerequisites, hash.String())
+	}
+	t.state.set(key, refs)
+	return nil
+}
+
+func writeBundleFile(b backend, file string, repo *git.Repository, header *bundleHeader) error {
+	tmp := file + ".tmp"
+	if err := b.Bundle(repo, tmp, header); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// repoRefs returns the mirrored refs of a repository
+func repoRefs(repo *git.Repository) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[plumbing.ReferenceName]plumbing.Hash)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			res[ref.Name()] = ref.Hash()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mirroredRefs(res), nil
+}
+
+func equalRefs(a, b map[plumbing.ReferenceName]plumbing.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, hash := range a {
+		if other, ok := b[name]; !ok || other != hash {
+			return false
+		}
+	}
+	return true
+}
+
+func refsToStrings(refs map[plumbing.ReferenceName]plumbing.Hash) map[string]string {
+	res := make(map[string]string, len(refs))
+	for name, hash := range refs {
+		res[name.String()] = hash.String()
+	}
+	return res
+}
+
+// stripUrl removes the credentials of a url
+func stripUrl(url string) string {
+	u, err := gitutil.ParseUrl(url)
+	if err != nil {
+		return url
+	}
+	return u.String()
+}
+
+// bundleName returns a file name for the bundles of a repository, e.g. github.com_MR5356_syncer
+func bundleName(url string) string {
+	host, repoPath := splitRepoUrl(url)
+	name := strings.TrimSuffix(strings.Trim(host+"/"+repoPath, "/"), ".git")
+	return strings.NewReplacer("/", "_", ":", "_", "\\", "_").Replace(name)
+}
+
+func writeJSON(file string, v any) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0644)
+}
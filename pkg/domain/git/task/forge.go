@@ -0,0 +1,202 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// expandForgeSource lists the repositories selected by a forge source like github:org/*
+// and renders the destination templates of the mapping for each of them
+func expandForgeSource(cfg *config.Config, source string, repo *config.Repo) (map[string][]string, error) {
+	src, err := forge.ParseSource(source)
+	if err != nil {
+		return nil, err
+	}
+	if src.Host == "" {
+		src.Host = forge.DefaultHost(src.Type)
+	}
+	host, fc, err := cfg.GetForge(src.Type, src.Host)
+	if err != nil {
+		return nil, fmt.Errorf("expand %s failed: %s", source, err)
+	}
+	f, err := newForge(host, fc)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpls := make([]*template.Template, 0, len(repo.Destinations))
+	for _, d := range repo.Destinations {
+		tmpl, err := template.New("destination").Option("missingkey=error").Parse(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination template %s: %s", d, err)
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+
+	repos, err := f.ListRepos(src.Owner, src.Recursive())
+	if err != nil {
+		return nil, fmt.Errorf("list repos of %s failed: %s", source, err)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Path < repos[j].Path
+	})
+
+	res := make(map[string][]string)
+	seen := make(map[string]string)
+	for _, r := range repos {
+		if !matchForgeRepo(src, repo.Filter, r) {
+			logrus.Debugf("skip %s: filtered out by %s", r.Path, source)
+			continue
+		}
+		url := r.HttpUrl
+		if repo.Protocol == "ssh" {
+			url = r.SshUrl
+		}
+		for _, tmpl := range tmpls {
+			dest, err := renderDestination(tmpl, url)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := seen[dest]; ok {
+				return nil, fmt.Errorf("destination %s of %s conflicts with %s, use variables like {{.Name}} in the template", dest, url, other)
+			}
+			seen[dest] = url
+			res[url] = append(res[url], dest)
+		}
+	}
+	logrus.Infof("expand %s to %d repos", source, len(res))
+	return res, nil
+}
+
+func matchForgeRepo(src *forge.Source, filter *config.Filter, r *forge.Repository) bool {
+	if !src.Recursive() {
+		if !strings.EqualFold(path.Dir(r.Path), src.Owner) {
+			return false
+		}
+		if ok, _ := path.Match(src.Pattern, r.Name); !ok {
+			return false
+		}
+	}
+
+	if filter == nil {
+		filter = new(config.Filter)
+	}
+	if r.Archived && !filter.Archived {
+		return false
+	}
+	if r.Fork && !filter.Forks {
+		return false
+	}
+	if len(filter.Visibility) > 0 {
+		found := false
+		for _, v := range filter.Visibility {
+			if strings.EqualFold(v, r.Visibility) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, pattern := range filter.Exclude {
+		if ok, _ := path.Match(pattern, r.Path); ok {
+			return false
+		}
+	}
+	return true
+}
+
+func newForge(host string, fc *config.Forge) (forge.Forge, error) {
+	apiUrl := fc.Url
+	if apiUrl == "" {
+		apiUrl = forge.DefaultApiUrl(fc.Type, host)
+	}
+	return forge.NewForge(fc.Type, apiUrl, fc.Token.Value(), fc.Insecure)
+}
+
+// forgeForHost returns the forge api of a git host, github.com and gitlab.com work without config
+func forgeForHost(cfg *config.Config, host string) (forge.Forge, error) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	defaultType := ""
+	for _, typ := range []string{forge.TypeGithub, forge.TypeGitlab} {
+		if forge.DefaultHost(typ) == hostname {
+			defaultType = typ
+		}
+	}
+
+	for _, h := range []string{host, hostname} {
+		if fc, ok := cfg.Forges[h]; ok {
+			if fc.Type == "" {
+				fc = &config.Forge{Type: defaultType, Url: fc.Url, Token: fc.Token, Insecure: fc.Insecure}
+			}
+			return newForge(h, fc)
+		}
+	}
+	if defaultType != "" {
+		return newForge(hostname, &config.Forge{Type: defaultType})
+	}
+	return nil, fmt.Errorf("no forge configured for host %s", host)
+}
+
+// ensureDestination creates the destination repository through the forge api if it does not exist.
+// The description, visibility and default branch are copied from the source where available, the
+// returned repository is nil if the destination already existed. The default branch follows the rename rules.
+func ensureDestination(ctx context.Context, cfg *config.Config, source, destination string, repo *git.Repository, rules []*config.RenameRule) (forge.Forge, *forge.Repository, error) {
+	host, destPath := splitRepoUrl(destination)
+	f, err := forgeForHost(cfg, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	destPath = strings.TrimSuffix(destPath, ".git")
+	_, err = f.GetRepo(destPath)
+	if err == nil {
+		return f, nil, nil
+	}
+	if !errors.Is(err, forge.ErrNotFound) {
+		return nil, nil, err
+	}
+
+	meta := &forge.Repository{Path: destPath, Visibility: "private"}
+	srcHost, srcPath := splitRepoUrl(source)
+	if sf, err := forgeForHost(cfg, srcHost); err == nil {
+		if src, err := sf.GetRepo(strings.TrimSuffix(srcPath, ".git")); err == nil {
+			meta.Description = src.Description
+			meta.DefaultBranch = src.DefaultBranch
+			if src.Visibility != "" {
+				meta.Visibility = src.Visibility
+			}
+		} else {
+			task.Logger(ctx).Debugf("get source repo %s failed: %s", source, err)
+		}
+	}
+	if meta.DefaultBranch == "" {
+		if head, err := repo.Storer.Reference(plumbing.HEAD); err == nil && head.Type() == plumbing.SymbolicReference {
+			meta.DefaultBranch = head.Target().Short()
+		}
+	}
+	if meta.DefaultBranch != "" {
+		meta.DefaultBranch = renameRef(rules, plumbing.NewBranchReferenceName(meta.DefaultBranch)).Short()
+	}
+
+	task.Logger(ctx).Infof("create repo %s on %s, visibility: %s", destPath, host, meta.Visibility)
+	if err := f.CreateRepo(meta); err != nil {
+		return nil, nil, fmt.Errorf("create repo %s failed: %s", destPath, err)
+	}
+	return f, meta, nil
+}
@@ -0,0 +1,127 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_matchForgeRepo(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		filter *config.Filter
+		repo   *forge.Repository
+		want   bool
+	}{
+		{
+			name:   "test match",
+			source: "github:MR5356/*",
+			repo:   &forge.Repository{Name: "syncer", Path: "MR5356/syncer", Visibility: "public"},
+			want:   true,
+		},
+		{
+			name:   "test pattern",
+			source: "github:MR5356/app-*",
+			repo:   &forge.Repository{Name: "syncer", Path: "MR5356/syncer", Visibility: "public"},
+			want:   false,
+		},
+		{
+			name:   "test subgroup without recursive",
+			source: "gitlab:group/*",
+			repo:   &forge.Repository{Name: "lib", Path: "group/sub/lib", Visibility: "public"},
+			want:   false,
+		},
+		{
+			name:   "test subgroup with recursive",
+			source: "gitlab:group/**",
+			repo:   &forge.Repository{Name: "lib", Path: "group/sub/lib", Visibility: "public"},
+			want:   true,
+		},
+		{
+			name:   "test archived",
+			source: "github:MR5356/*",
+			repo:   &forge.Repository{Name: "syncer", Path: "MR5356/syncer", Archived: true},
+			want:   false,
+		},
+		{
+			name:   "test forks included",
+			source: "github:MR5356/*",
+			filter: &config.Filter{Forks: true},
+			repo:   &forge.Repository{Name: "syncer", Path: "MR5356/syncer", Fork: true},
+			want:   true,
+		},
+		{
+			name:   "test visibility",
+			source: "github:MR5356/*",
+			filter: &config.Filter{Visibility: []string{"public"}},
+			repo:   &forge.Repository{Name: "syncer", Path: "MR5356/syncer", Visibility: "private"},
+			want:   false,
+		},
+		{
+			name:   "test exclude",
+			source: "gitlab:group/**",
+			filter: &config.Filter{Exclude: []string{"group/sandbox/*"}},
+			repo:   &forge.Repository{Name: "lib", Path: "group/sandbox/lib"},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := forge.ParseSource(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchForgeRepo(src, tt.filter, tt.repo); got != tt.want {
+				t.Errorf("matchForgeRepo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ensureDestination(t *testing.T) {
+	var created map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/mirror/syncer":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPost && r.URL.Path == "/orgs/mirror/repos":
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+
+	cfg := config.NewConfig()
+	cfg.Forges[host] = &config.Forge{Type: forge.TypeGitea, Url: server.URL}
+	_, meta, err := ensureDestination(context.Background(), cfg, "git@unknown.example.com:MR5356/syncer.git", fmt.Sprintf("http://%s/mirror/syncer.git", host), repo, nil)
+	if err != nil {
+		t.Fatalf("ensureDestination() error = %v", err)
+	}
+	want := &forge.Repository{Path: "mirror/syncer", Visibility: "private", DefaultBranch: "main"}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("ensureDestination() got = %+v, want %+v", meta, want)
+	}
+	if created["name"] != "syncer" || created["private"] != true {
+		t.Errorf("ensureDestination() created = %+v", created)
+	}
+}
@@ -0,0 +1,276 @@
+package task
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+)
+
+// ImportTask pushes the bundles of a source to its destinations, it reuses the push logic of SyncTask
+type ImportTask struct {
+	*SyncTask
+
+	// files are the bundle files of the source in export order
+	files []string
+}
+
+func NewImportTask(source string, destinations, files []string, repo *config.Repo, cfg *config.Config) *ImportTask {
+	return &ImportTask{
+		SyncTask: NewSyncTask(source, destinations, repo, cfg, nil),
+		files:    files,
+	}
+}
+
+// GenerateImportTaskList reads the manifests written by git export in dir and creates an import task for each source,
+// the destinations come from the mapping of the bundle in repos
+func GenerateImportTaskList(cfg *config.Config, dir string) (*task.List, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, ManifestFile("*")))
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no bundle manifest found in %s", dir)
+	}
+	sort.Strings(manifests)
+
+	sources := make([]string, 0)
+	entries := make(map[string][]*BundleEntry)
+	for _, m := range manifests {
+		content, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		manifest := new(BundleManifest)
+		if err := json.Unmarshal(content, manifest); err != nil {
+			return nil, fmt.Errorf("invalid bundle manifest %s: %s", m, err)
+		}
+		for _, e := range manifest.Bundles {
+			if _, ok := entries[e.Source]; !ok {
+				sources = append(sources, e.Source)
+			}
+			entries[e.Source] = append(entries[e.Source], e)
+		}
+	}
+
+	list := task.NewTaskList()
+	for _, source := range sources {
+		last := entries[source][len(entries[source])-1]
+		value, ok := cfg.Repos[last.Mapping]
+		if !ok {
+			logrus.Warnf("skip bundles of %s: mapping %s not found in repos", source, last.Mapping)
+			continue
+		}
+		repo, err := config.ParseRepo(last.Mapping, value)
+		if err != nil {
+			return nil, err
+		}
+		dests, err := importDestinations(last.Mapping, source, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		files := make([]string, 0, len(entries[source]))
+		for _, e := range entries[source] {
+			files = append(files, filepath.Join(dir, e.File))
+		}
+		t := NewImportTask(source, dests, files, repo, cfg)
+		t.mapping = last.Mapping
+		logrus.Infof("generate import task: %s", t.Name())
+		list.Add(t)
+	}
+	return list, nil
+}
+
+// importDestinations renders the destination templates of forge mappings offline, without listing the repos
+func importDestinations(mapping, source string, repo *config.Repo) ([]string, error) {
+	if !forge.IsSource(mapping) {
+		return repo.Destinations, nil
+	}
+	res := make([]string, 0, len(repo.Destinations))
+	for _, d := range repo.Destinations {
+		tmpl, err := template.New("destination").Option("missingkey=error").Parse(d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination template %s: %s", d, err)
+		}
+		dest, err := renderDestination(tmpl, source)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dest)
+	}
+	return res, nil
+}
+
+func (t *ImportTask) Run() error {
+	return t.RunContext(context.Background())
+}
+
+// RunContext runs the task with the logger of ctx, it stops pushing when ctx is canceled
+func (t *ImportTask) RunContext(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "git.ImportTask.Run", attribute.String("task", t.Name()))
+	defer func() { tracing.End(span, err) }()
+	t.bind(ctx)
+	dests := t.resolveDestinations()
+	if len(dests) == 0 {
+		return t.err()
+	}
+
+	dirName, err := os.MkdirTemp("", "syncer-git-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		t.log.Infof("clean %s", dirName)
+		_ = os.RemoveAll(dirName)
+	}()
+	repo, err := git.PlainInit(dirName, true)
+	if err != nil {
+		return err
+	}
+	b, err := newBackend(t.ctx, t.repo, t.cfg)
+	if err != nil {
+		return err
+	}
+
+	// 按导出顺序解包，后导出的引用覆盖之前的引用
+	refs := make(map[plumbing.ReferenceName]plumbing.Hash)
+	fetched := false
+	for _, file := range t.files {
+		header, err := t.unbundle(b, repo, file, dests[0], &fetched)
+		if err != nil {
+			return err
+		}
+		for name, hash := range header.Refs {
+			refs[name] = hash
+		}
+	}
+
+	mirrored := mirroredRefs(refs)
+	for name, hash := range mirrored {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			return err
+		}
+	}
+	if head, ok := refs[plumbing.HEAD]; ok {
+		setBundleHead(t.ctx, repo, head, mirrored)
+	}
+
+	if t.repo.Verify != nil {
+		if err := t.verify(repo); err != nil {
+			return err
+		}
+		verified, err := repoRefs(repo)
+		if err != nil {
+			return err
+		}
+		mirrored = mirroredRefs(verified)
+	}
+
+	if t.repo.Strip != nil {
+		commits, err := loadCommitMap(commitMapFile(t.repo.Strip, t.cfg, t.source), t.repo.Strip)
+		if err != nil {
+			return err
+		}
+		if err := t.strip(b, repo, t.source, commits, dests); err != nil {
+			return err
+		}
+		stripped, err := repoRefs(repo)
+		if err != nil {
+			return err
+		}
+		mirrored = mirroredRefs(stripped)
+	}
+
+	dests = t.skipUpToDate(t.source, mirrored, dests)
+	t.pushAll(b, repo, t.source, dests)
+	return t.err()
+}
+
+// unbundle stores the objects of a bundle, the prerequisites missing locally are fetched from the destination once
+func (t *ImportTask) unbundle(b backend, repo *git.Repository, file string, dest *destination, fetched *bool) (*bundleHeader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	header, err := readBundleHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %w", file, err)
+	}
+
+	missing := missingObjects(repo, header.Prerequisites)
+	if len(missing) > 0 && !*fetched {
+		*fetched = true
+		// 目标仓库的引用放在 refs/syncer/destination/* 下，不会被推送
+		if err := b.Fetch(repo, dest.url, dest.auth, []gitConfig.RefSpec{"+refs/*:refs/syncer/destination/*"}); err != nil {
+			return nil, fmt.Errorf("fetch prerequisites of %s from %s failed: %s", file, dest.url, err)
+		}
+		missing = missingObjects(repo, header.Prerequisites)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s requires commit %s which %s does not have, import the previous bundles first", file, missing[0], dest.url)
+	}
+
+	t.log.Infof("unbundle %s", file)
+	if err := readBundlePack(br, repo.Storer); err != nil {
+		return nil, fmt.Errorf("unbundle %s failed: %s", file, err)
+	}
+	return header, nil
+}
+
+func missingObjects(repo *git.Repository, hashes []plumbing.Hash) []plumbing.Hash {
+	res := make([]plumbing.Hash, 0)
+	for _, hash := range hashes {
+		if repo.Storer.HasEncodedObject(hash) != nil {
+			res = append(res, hash)
+		}
+	}
+	return res
+}
+
+// setBundleHead points HEAD to the branch the exported HEAD pointed to, preferring main and master
+func setBundleHead(ctx context.Context, repo *git.Repository, head plumbing.Hash, refs map[plumbing.ReferenceName]plumbing.Hash) {
+	candidates := make([]string, 0)
+	for name, hash := range refs {
+		if name.IsBranch() && hash == head {
+			candidates = append(candidates, name.String())
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return branchRank(candidates[i]) < branchRank(candidates[j]) ||
+			branchRank(candidates[i]) == branchRank(candidates[j]) && candidates[i] < candidates[j]
+	})
+	ref := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName(candidates[0]))
+	if err := repo.Storer.SetReference(ref); err != nil {
+		task.Logger(ctx).Warnf("set HEAD to %s failed: %s", candidates[0], err)
+	}
+}
+
+func branchRank(name string) int {
+	switch plumbing.ReferenceName(name) {
+	case plumbing.NewBranchReferenceName("main"):
+		return 0
+	case plumbing.Master:
+		return 1
+	}
+	return 2
+}
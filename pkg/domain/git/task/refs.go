@@ -0,0 +1,203 @@
+package task
+
+import (
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"sort"
+	"strings"
+)
+
+// pushRefSpecs are the refs mirrored to the destinations
+var pushRefSpecs = []gitConfig.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+	"+refs/change/*:refs/change/*",
+}
+
+// matchRefSpecs reports whether the ref is mirrored by the refspecs
+func matchRefSpecs(specs []gitConfig.RefSpec, name plumbing.ReferenceName) bool {
+	for _, spec := range specs {
+		if spec.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// listRefs lists the refs of a remote without fetching any object, like git ls-remote
+func listRefs(url string, auth transport.AuthMethod) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	refs, err := listRemote(url, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			res[ref.Name()] = ref.Hash()
+		}
+	}
+	return res, nil
+}
+
+// isUpToDate reports whether every mirrored ref of the source points to the same object on the destination
+func isUpToDate(srcRefs, destRefs map[plumbing.ReferenceName]plumbing.Hash) bool {
+	found := false
+	for name, hash := range srcRefs {
+		if !matchRefSpecs(pushRefSpecs, name) {
+			continue
+		}
+		found = true
+		if destRefs[name] != hash {
+			return false
+		}
+	}
+	return found
+}
+
+// mirroredRefs filters the refs by the push refspecs
+func mirroredRefs(refs map[plumbing.ReferenceName]plumbing.Hash) map[plumbing.ReferenceName]plumbing.Hash {
+	res := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for name, hash := range refs {
+		if matchRefSpecs(pushRefSpecs, name) {
+			res[name] = hash
+		}
+	}
+	return res
+}
+
+// renameRef returns the destination name of a mirrored ref after applying the rename rules
+func renameRef(rules []*config.RenameRule, name plumbing.ReferenceName) plumbing.ReferenceName {
+	var typ, prefix string
+	switch {
+	case name.IsBranch():
+		typ, prefix = config.RefTypeHeads, "refs/heads/"
+	case name.IsTag():
+		typ, prefix = config.RefTypeTags, "refs/tags/"
+	default:
+		return name
+	}
+
+	short := strings.TrimPrefix(name.String(), prefix)
+	for _, r := range rules {
+		if r.Type != "" && r.Type != typ {
+			continue
+		}
+		if renamed, ok := r.Apply(short); ok {
+			short = renamed
+		}
+	}
+	return plumbing.ReferenceName(prefix + short)
+}
+
+// renameRefs maps the mirrored refs to their destination names, two refs renamed to the same name is an error
+func renameRefs(rules []*config.RenameRule, refs map[plumbing.ReferenceName]plumbing.Hash) (map[plumbing.ReferenceName]plumbing.Hash, map[plumbing.ReferenceName]plumbing.ReferenceName, error) {
+	res := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	names := make(map[plumbing.ReferenceName]plumbing.ReferenceName, len(refs))
+	for name, hash := range mirroredRefs(refs) {
+		dest := renameRef(rules, name)
+		if other, ok := names[dest]; ok {
+			return nil, nil, fmt.Errorf("%s and %s are both renamed to %s", other, name, dest)
+		}
+		if !validRefName(dest) {
+			return nil, nil, fmt.Errorf("%s is renamed to an invalid ref %s", name, dest)
+		}
+		names[dest] = name
+		res[dest] = hash
+	}
+	return res, names, nil
+}
+
+// refSpecsOf returns the push refspecs of the repository, explicit refspecs for each ref if there are rename rules
+func refSpecsOf(repo *git.Repository, rules []*config.RenameRule) ([]gitConfig.RefSpec, error) {
+	if len(rules) == 0 {
+		return pushRefSpecs, nil
+	}
+	refs, err := repoRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+	_, names, err := renameRefs(rules, refs)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]gitConfig.RefSpec, 0, len(names))
+	for dest, src := range names {
+		specs = append(specs, gitConfig.RefSpec(fmt.Sprintf("+%s:%s", src, dest)))
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i] < specs[j]
+	})
+	return specs, nil
+}
+
+// expectedRefs returns the refs the destinations should have after the push, with their destination names
+func expectedRefs(repo *git.Repository, rules []*config.RenameRule) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	refs, err := repoRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+	res, _, err := renameRefs(rules, refs)
+	return res, err
+}
+
+// maxMismatches limits the mismatched refs listed in a verification error
+const maxMismatches = 10
+
+// verifyPush lists the refs of the destination after a push and checks every pushed ref points to the
+// expected object, server side hooks may reject or rewrite refs without failing the push
+func verifyPush(url string, auth transport.AuthMethod, expected map[plumbing.ReferenceName]plumbing.Hash) error {
+	actual, err := listRefs(url, auth)
+	if err != nil {
+		return fmt.Errorf("list refs of %s for verification failed: %s", url, err)
+	}
+	return verifyRefs(expected, actual)
+}
+
+func verifyRefs(expected, actual map[plumbing.ReferenceName]plumbing.Hash) error {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+
+	mismatches := make([]string, 0)
+	for _, n := range names {
+		name := plumbing.ReferenceName(n)
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s is missing, want %s", name, expected[name]))
+		case got != expected[name]:
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, want %s", name, got, expected[name]))
+		}
+	}
+	if len(mismatches) == 0 {
+		return nil
+	}
+	total := len(mismatches)
+	if total > maxMismatches {
+		mismatches = append(mismatches[:maxMismatches], fmt.Sprintf("and %d more", total-maxMismatches))
+	}
+	return fmt.Errorf("verify push failed, %d refs mismatch: %s", total, strings.Join(mismatches, "; "))
+}
+
+// validRefName checks the rules of git check-ref-format which a renamed ref can break
+func validRefName(name plumbing.ReferenceName) bool {
+	n := name.String()
+	if strings.HasSuffix(n, "/") || strings.HasSuffix(n, ".lock") || strings.HasSuffix(n, ".") ||
+		strings.Contains(n, "..") || strings.Contains(n, "//") || strings.Contains(n, "@{") ||
+		strings.ContainsAny(n, " ~^:?*[\\\x7f") {
+		return false
+	}
+	for _, part := range strings.Split(n, "/") {
+		if part == "" || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
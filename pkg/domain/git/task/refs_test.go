@@ -0,0 +1,215 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRepo creates a repository with a commit on master and returns its path
+func newTestRepo(t *testing.T, files map[string]string) string {
+	dir := filepath.Join(t.TempDir(), "src")
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, repo, files)
+	return dir
+}
+
+func commitTestFiles(t *testing.T, repo *git.Repository, files map[string]string) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(wt.Filesystem.Root(), filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(wt.Filesystem.Root(), name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sig := &object.Signature{Name: "syncer", Email: "syncer@example.com", When: time.Unix(1700000000, 0)}
+	if _, err := wt.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestBareRepo(t *testing.T) string {
+	dir := filepath.Join(t.TempDir(), "dest.git")
+	if _, err := git.PlainInit(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func testUpToDate(t *testing.T, src, dest string) bool {
+	srcRefs, err := listRefs(src, nil)
+	if err != nil {
+		t.Fatalf("listRefs() error = %v", err)
+	}
+	destRefs, err := listRefs(dest, nil)
+	if err != nil {
+		t.Fatalf("listRefs() error = %v", err)
+	}
+	return isUpToDate(srcRefs, destRefs)
+}
+
+func Test_isUpToDate(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	dest := newTestBareRepo(t)
+
+	if testUpToDate(t, src, dest) {
+		t.Errorf("isUpToDate() = true before sync, want false")
+	}
+
+	cfg := config.NewConfig()
+	if err := NewSyncTask(src, []string{dest}, &config.Repo{Destinations: []string{dest}}, cfg, nil).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if !testUpToDate(t, src, dest) {
+		t.Errorf("isUpToDate() = false after sync, want true")
+	}
+}
+
+// renameRules compiles the rules like config.ParseRepo
+func renameRules(t *testing.T, rules ...*config.RenameRule) []*config.RenameRule {
+	for _, r := range rules {
+		if err := r.Compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rules
+}
+
+func Test_renameRef(t *testing.T) {
+	rules := renameRules(t,
+		&config.RenameRule{Type: config.RefTypeHeads, Match: "^master$", Replace: "main"},
+		&config.RenameRule{Type: config.RefTypeHeads, Prefix: "upstream/"},
+		&config.RenameRule{Type: config.RefTypeTags, Match: "^v(.*)$", Replace: "release-$1"},
+		&config.RenameRule{Type: config.RefTypeTags, Prefix: "vendor-"},
+	)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "refs/heads/master", want: "refs/heads/upstream/main"},
+		{name: "refs/heads/dev", want: "refs/heads/upstream/dev"},
+		{name: "refs/tags/v1.0", want: "refs/tags/vendor-release-1.0"},
+		{name: "refs/tags/nightly", want: "refs/tags/vendor-nightly"},
+		{name: "refs/change/01/1/1", want: "refs/change/01/1/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renameRef(rules, plumbing.ReferenceName(tt.name)); got.String() != tt.want {
+				t.Errorf("renameRef() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renameRefs(t *testing.T) {
+	refs := map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash("1111111111111111111111111111111111111111"),
+		"refs/heads/main":   plumbing.NewHash("2222222222222222222222222222222222222222"),
+	}
+	if _, _, err := renameRefs(renameRules(t, &config.RenameRule{Match: "^master$", Replace: "main"}), refs); err == nil {
+		t.Errorf("renameRefs() error = nil, want conflict")
+	}
+	if _, _, err := renameRefs(renameRules(t, &config.RenameRule{Match: "^master$", Replace: "a..b"}), refs); err == nil {
+		t.Errorf("renameRefs() error = nil, want invalid ref")
+	}
+}
+
+func TestSyncTask_Rename(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	dest := newTestBareRepo(t)
+
+	repo := &config.Repo{
+		Destinations: []string{dest},
+		Rename:       renameRules(t, &config.RenameRule{Type: config.RefTypeHeads, Match: "^master$", Replace: "main"}),
+	}
+	if err := NewSyncTask(src, []string{dest}, repo, config.NewConfig(), nil).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	refs, err := listRefs(dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := refs["refs/heads/main"]; !ok {
+		t.Errorf("refs of destination = %v, want refs/heads/main", refs)
+	}
+	if _, ok := refs["refs/heads/master"]; ok {
+		t.Errorf("refs of destination = %v, want no refs/heads/master", refs)
+	}
+
+	// 再次同步时按改名后的引用判断是否一致
+	task := NewSyncTask(src, []string{dest}, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if r := task.Results(); len(r) != 1 || r[0].Status != StatusUpToDate {
+		t.Errorf("Results() = %+v, want up-to-date", r[0])
+	}
+}
+
+func Test_verifyRefs(t *testing.T) {
+	a := plumbing.NewHash("1111111111111111111111111111111111111111")
+	b := plumbing.NewHash("2222222222222222222222222222222222222222")
+	expected := map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/master": a, "refs/tags/v1": b}
+
+	if err := verifyRefs(expected, map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/master": a, "refs/tags/v1": b, "refs/heads/other": b}); err != nil {
+		t.Errorf("verifyRefs() error = %v, want nil", err)
+	}
+	err := verifyRefs(expected, map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/master": b})
+	if err == nil {
+		t.Fatalf("verifyRefs() error = nil, want mismatches")
+	}
+	for _, want := range []string{"2 refs mismatch", "refs/heads/master is " + b.String(), "refs/tags/v1 is missing"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("verifyRefs() error = %v, want %s", err, want)
+		}
+	}
+}
+
+func TestSyncTask_Verify(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	repo, err := git.PlainOpen(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateTag("v1", head.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务端钩子静默删除推送的标签
+	dest := newTestBareRepo(t)
+	hook := "#!/bin/sh\ngit update-ref -d refs/tags/v1\n"
+	if err := os.MkdirAll(filepath.Join(dest, "hooks"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "hooks", "post-receive"), []byte(hook), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewSyncTask(src, []string{dest}, &config.Repo{Destinations: []string{dest}}, config.NewConfig(), nil).Run()
+	if err == nil || !strings.Contains(err.Error(), "refs/tags/v1 is missing") {
+		t.Errorf("Run() error = %v, want refs/tags/v1 is missing", err)
+	}
+}
@@ -0,0 +1,178 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/go-git/go-git/v5/plumbing"
+	"io"
+	"os"
+	"strings"
+)
+
+// forgeRepo is a repository on a forge
+type forgeRepo struct {
+	forge forge.Forge
+	path  string
+}
+
+func newForgeRepo(cfg *config.Config, url string) (*forgeRepo, error) {
+	host, repoPath := splitRepoUrl(url)
+	f, err := forgeForHost(cfg, host)
+	if err != nil {
+		return nil, err
+	}
+	return &forgeRepo{forge: f, path: strings.TrimSuffix(repoPath, ".git")}, nil
+}
+
+// syncReleases mirrors the releases of the source to the destinations which are pushed or up to date,
+// a destination failing to sync the releases is marked as failed so that it is retried
+func (t *SyncTask) syncReleases() error {
+	limit, err := t.repo.Releases.AssetSizeLimit()
+	if err != nil {
+		return err
+	}
+	src, err := newForgeRepo(t.cfg, t.source)
+	if err != nil {
+		return fmt.Errorf("releases of %s require a forge: %s", configutil.RedactUrl(t.source), err)
+	}
+	releases, err := src.forge.ListReleases(src.path)
+	if err != nil {
+		return fmt.Errorf("list releases of %s failed: %s", src.path, err)
+	}
+	if len(releases) == 0 {
+		return nil
+	}
+
+	for _, d := range t.destinations {
+		switch t.status(d) {
+		case StatusPushed, StatusUpToDate:
+		default:
+			continue
+		}
+		if err := t.syncDestinationReleases(src, releases, d, limit); err != nil {
+			t.log.Errorf("sync releases to %s failed: %s", configutil.RedactUrl(d), err)
+			t.setResult(d, StatusFailed, fmt.Errorf("sync releases failed: %w", err))
+		}
+	}
+	return t.err()
+}
+
+func (t *SyncTask) syncDestinationReleases(src *forgeRepo, releases []*forge.Release, d string, limit int64) error {
+	destAuth, destUrl, err := getAuth(d, t.cfg)
+	if err != nil {
+		return err
+	}
+	dest, err := newForgeRepo(t.cfg, destUrl)
+	if err != nil {
+		return err
+	}
+	// 只为目标仓库中已有的标签创建 release，否则 forge 会从默认分支创建标签
+	refs, err := listRefs(destUrl, destAuth)
+	if err != nil {
+		return err
+	}
+	return mirrorReleases(t.ctx, src, dest, releases, t.repo.Rename, refs, limit)
+}
+
+// mirrorReleases creates the releases missing at dest from the oldest and uploads their missing assets,
+// drafts and releases of tags missing at dest are skipped
+func mirrorReleases(ctx context.Context, src, dest *forgeRepo, releases []*forge.Release, rules []*config.RenameRule, refs map[plumbing.ReferenceName]plumbing.Hash, limit int64) error {
+	existing, err := dest.forge.ListReleases(dest.path)
+	if err != nil {
+		return fmt.Errorf("list releases of %s failed: %s", dest.path, err)
+	}
+	byTag := make(map[string]*forge.Release, len(existing))
+	for _, r := range existing {
+		byTag[r.TagName] = r
+	}
+
+	// forge 按从新到旧的顺序列出 release
+	for i := len(releases) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r := releases[i]
+		if r.Draft {
+			continue
+		}
+		tag := renameRef(rules, plumbing.NewTagReferenceName(r.TagName))
+		if _, ok := refs[tag]; !ok {
+			task.Logger(ctx).Debugf("skip release %s: tag %s not found in %s", r.TagName, tag.Short(), dest.path)
+			continue
+		}
+
+		dr, ok := byTag[tag.Short()]
+		if !ok {
+			task.Logger(ctx).Infof("create release %s in %s", tag.Short(), dest.path)
+			dr, err = dest.forge.CreateRelease(dest.path, &forge.Release{
+				TagName:    tag.Short(),
+				Name:       r.Name,
+				Body:       r.Body,
+				Prerelease: r.Prerelease,
+			})
+			if err != nil {
+				return fmt.Errorf("create release %s failed: %s", tag.Short(), err)
+			}
+		}
+
+		assets := make(map[string]bool, len(dr.Assets))
+		for _, a := range dr.Assets {
+			assets[a.Name] = true
+		}
+		for _, a := range r.Assets {
+			if assets[a.Name] {
+				continue
+			}
+			if err := copyAsset(ctx, src, dest, dr, a, limit); err != nil {
+				return fmt.Errorf("copy asset %s of release %s failed: %s", a.Name, r.TagName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyAsset downloads the asset to a temporary file and uploads it, assets larger than limit are skipped,
+// the size of assets not reported by the forge is checked while downloading
+func copyAsset(ctx context.Context, src, dest *forgeRepo, release *forge.Release, asset *forge.Asset, limit int64) error {
+	if limit > 0 && asset.Size > limit {
+		task.Logger(ctx).Warnf("skip asset %s of release %s: %d bytes exceeds the limit", asset.Name, release.TagName, asset.Size)
+		return nil
+	}
+
+	rc, err := src.forge.DownloadAsset(src.path, asset)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.CreateTemp("", "syncer-asset-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	var r io.Reader = rc
+	if limit > 0 {
+		r = io.LimitReader(rc, limit+1)
+	}
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	if limit > 0 && size > limit {
+		task.Logger(ctx).Warnf("skip asset %s of release %s: exceeds the limit of %d bytes", asset.Name, release.TagName, limit)
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	task.Logger(ctx).Infof("upload asset %s of release %s to %s, %d bytes", asset.Name, release.TagName, dest.path, size)
+	return dest.forge.UploadAsset(dest.path, release, asset.Name, size, f)
+}
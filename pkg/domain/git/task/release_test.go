@@ -0,0 +1,94 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/domain/git/forge"
+	"github.com/go-git/go-git/v5/plumbing"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// fakeForge keeps the releases and asset contents in memory
+type fakeForge struct {
+	forge.Forge
+	releases []*forge.Release
+	contents map[string][]byte
+}
+
+func (f *fakeForge) ListReleases(string) ([]*forge.Release, error) {
+	return f.releases, nil
+}
+
+func (f *fakeForge) CreateRelease(_ string, release *forge.Release) (*forge.Release, error) {
+	f.releases = append([]*forge.Release{release}, f.releases...)
+	return release, nil
+}
+
+func (f *fakeForge) DownloadAsset(_ string, asset *forge.Asset) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(f.contents[asset.Url])), nil
+}
+
+func (f *fakeForge) UploadAsset(_ string, release *forge.Release, name string, size int64, r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	url := release.TagName + "/" + name
+	f.contents[url] = content
+	release.Assets = append(release.Assets, &forge.Asset{Name: name, Size: size, Url: url})
+	return nil
+}
+
+func Test_mirrorReleases(t *testing.T) {
+	src := &fakeForge{
+		releases: []*forge.Release{
+			{TagName: "v3", Draft: true},
+			{TagName: "v2", Name: "v2", Body: "notes", Prerelease: true, Assets: []*forge.Asset{
+				{Name: "app.tar.gz", Size: 3, Url: "v2/app.tar.gz"},
+				{Name: "big.iso", Size: 100, Url: "v2/big.iso"},
+				// gitlab 不返回资源大小，下载时检查
+				{Name: "link.bin", Url: "v2/link.bin"},
+			}},
+			{TagName: "v1", Name: "v1", Assets: []*forge.Asset{{Name: "app.tar.gz", Size: 3, Url: "v1/app.tar.gz"}}},
+			{TagName: "v0"},
+		},
+		contents: map[string][]byte{
+			"v2/app.tar.gz": []byte("new"),
+			"v2/big.iso":    bytes.Repeat([]byte("x"), 100),
+			"v2/link.bin":   bytes.Repeat([]byte("x"), 50),
+			"v1/app.tar.gz": []byte("old"),
+		},
+	}
+	dest := &fakeForge{
+		releases: []*forge.Release{{TagName: "vendor-v1", Name: "v1", Assets: []*forge.Asset{{Name: "app.tar.gz", Url: "kept"}}}},
+		contents: map[string][]byte{},
+	}
+	refs := map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/tags/vendor-v1": plumbing.ZeroHash,
+		"refs/tags/vendor-v2": plumbing.ZeroHash,
+		"refs/tags/vendor-v3": plumbing.ZeroHash,
+	}
+	rules := []*config.RenameRule{{Type: config.RefTypeTags, Prefix: "vendor-"}}
+
+	err := mirrorReleases(context.Background(), &forgeRepo{forge: src, path: "src"}, &forgeRepo{forge: dest, path: "dest"}, src.releases, rules, refs, 10)
+	if err != nil {
+		t.Fatalf("mirrorReleases() error = %v", err)
+	}
+
+	if len(dest.releases) != 2 {
+		t.Fatalf("releases = %+v, want vendor-v1 and vendor-v2", dest.releases)
+	}
+	v2 := dest.releases[0]
+	if v2.TagName != "vendor-v2" || v2.Name != "v2" || v2.Body != "notes" || !v2.Prerelease {
+		t.Errorf("created release = %+v, want vendor-v2 copied from v2", v2)
+	}
+	if len(v2.Assets) != 1 || v2.Assets[0].Name != "app.tar.gz" || string(dest.contents["vendor-v2/app.tar.gz"]) != "new" {
+		t.Errorf("assets of vendor-v2 = %+v, want only app.tar.gz", v2.Assets)
+	}
+	if want := []*forge.Asset{{Name: "app.tar.gz", Url: "kept"}}; !reflect.DeepEqual(dest.releases[1].Assets, want) {
+		t.Errorf("assets of vendor-v1 = %+v, want the existing asset only", dest.releases[1].Assets)
+	}
+}
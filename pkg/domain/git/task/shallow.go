@@ -0,0 +1,143 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"time"
+)
+
+// cloneOptions limits the refs and the history fetched by Clone, nil mirrors every ref with the full history
+type cloneOptions struct {
+	depth int
+	since time.Time
+	// head is the default branch of the source
+	head plumbing.ReferenceName
+	// singleBranch only fetches the default branch without tags
+	singleBranch bool
+	// ref only fetches the branch or tag
+	ref plumbing.ReferenceName
+}
+
+// newCloneOptions returns the clone options of the mapping, the default branch is resolved with ls-remote
+// unless the mapping only mirrors a ref
+func newCloneOptions(repo *config.Repo, url string, auth transport.AuthMethod) (*cloneOptions, error) {
+	if repo == nil || (!repo.Shallow() && !repo.SingleBranch && repo.Ref == "") {
+		return nil, nil
+	}
+	opts := &cloneOptions{depth: repo.Depth, singleBranch: repo.SingleBranch, ref: plumbing.ReferenceName(repo.Ref)}
+	if repo.Since != "" {
+		since, err := config.ParseSince(repo.Since)
+		if err != nil {
+			return nil, err
+		}
+		opts.since = since
+	}
+
+	// 只拉取单个分支时 HEAD 指向该分支，标签则不设置 HEAD
+	if opts.ref != "" {
+		if opts.ref.IsBranch() {
+			opts.head = opts.ref
+		}
+		return opts, nil
+	}
+
+	refs, err := listRemote(url, auth)
+	if err != nil {
+		return nil, err
+	}
+	opts.head = remoteHead(refs)
+	if opts.head == "" && opts.singleBranch {
+		return nil, fmt.Errorf("can not resolve the default branch of %s", url)
+	}
+	return opts, nil
+}
+
+func (o *cloneOptions) shallow() bool {
+	return o != nil && (o.depth > 0 || !o.since.IsZero())
+}
+
+// refSpecs returns the refspecs fetched from the source
+func (o *cloneOptions) refSpecs() []gitConfig.RefSpec {
+	if o.ref != "" {
+		return []gitConfig.RefSpec{gitConfig.RefSpec(fmt.Sprintf("+%s:%s", o.ref, o.ref))}
+	}
+	if o.singleBranch {
+		return []gitConfig.RefSpec{gitConfig.RefSpec(fmt.Sprintf("+%s:%s", o.head, o.head))}
+	}
+	return pushRefSpecs
+}
+
+// filter drops the refs of the source which are not fetched
+func (o *cloneOptions) filter(refs map[plumbing.ReferenceName]plumbing.Hash) map[plumbing.ReferenceName]plumbing.Hash {
+	if o == nil || (!o.singleBranch && o.ref == "") {
+		return refs
+	}
+	name := o.head
+	if o.ref != "" {
+		name = o.ref
+	}
+	res := make(map[plumbing.ReferenceName]plumbing.Hash, 1)
+	if hash, ok := refs[name]; ok {
+		res[name] = hash
+	}
+	return res
+}
+
+// setHead points HEAD of the mirror to the default branch of the source
+func (o *cloneOptions) setHead(repo *git.Repository) error {
+	if o.head == "" {
+		return nil
+	}
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, o.head))
+}
+
+// listRemote lists the refs of a remote including the symbolic HEAD
+func listRemote(url string, auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitConfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	refs, err := remote.List(&git.ListOptions{
+		Auth:            auth,
+		InsecureSkipTLS: true,
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil
+	}
+	return refs, err
+}
+
+// remoteHead returns the branch HEAD of the remote points to, servers without the symref capability only
+// advertise the hash of HEAD, in which case a branch with the same hash is picked
+func remoteHead(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+
+	var res plumbing.ReferenceName
+	for _, ref := range refs {
+		if !ref.Name().IsBranch() || ref.Type() != plumbing.HashReference || ref.Hash() != head.Hash() {
+			continue
+		}
+		rank, best := branchRank(ref.Name().String()), branchRank(res.String())
+		if res == "" || rank < best || (rank == best && ref.Name() < res) {
+			res = ref.Name()
+		}
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSyncTask_Shallow(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+
+	dir := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	work, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitTestFiles(t, work, map[string]string{"a.txt": "a"})
+	commitTestFiles(t, work, map[string]string{"b.txt": "b"})
+	src := "file://" + dir
+
+	accept, reject := newTestBareRepo(t), newTestBareRepo(t)
+	if out, err := exec.Command("git", "-C", accept, "config", "receive.shallowUpdate", "true").CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	repo := &config.Repo{Destinations: []string{accept, reject}, Depth: 1}
+	task := NewSyncTask(src, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err == nil {
+		t.Fatalf("Run() error = nil, want error of %s", reject)
+	}
+	results := task.Results()
+	if len(results) != 2 || results[0].Status != StatusPushed || results[1].Status != StatusFailed {
+		t.Fatalf("Results() = %+v, want pushed and failed", results)
+	}
+	if !strings.Contains(results[1].Error.Error(), "receive.shallowUpdate") {
+		t.Errorf("Results() error = %v, want hint of receive.shallowUpdate", results[1].Error)
+	}
+	if !testUpToDate(t, src, accept) {
+		t.Errorf("%s is not up to date", accept)
+	}
+
+	out, err := exec.Command("git", "-C", accept, "rev-list", "--count", "HEAD").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "1" {
+		t.Errorf("%s has %s commits, want 1", accept, got)
+	}
+}
+
+func TestSyncTask_SingleBranch(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	work, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := work.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := work.CreateTag("v1.0.0", head.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := work.Storer.SetReference(plumbing.NewHashReference("refs/heads/dev", head.Hash())); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := newTestBareRepo(t)
+	repo := &config.Repo{Destinations: []string{dest}, SingleBranch: true}
+	task := NewSyncTask(dir, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	refs, err := listRefs(dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[plumbing.Master] != head.Hash() {
+		t.Errorf("refs of %s = %v, want only master", dest, refs)
+	}
+
+	// 其它分支与标签的变化不影响跳过判断
+	task = NewSyncTask(dir, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if r := task.Results(); len(r) != 1 || r[0].Status != StatusUpToDate {
+		t.Errorf("Results() = %+v, want up to date", r[0])
+	}
+}
+
+func TestSyncTask_Ref(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	work, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := work.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := work.CreateTag("v1.0.0", head.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := work.Storer.SetReference(plumbing.NewHashReference("refs/heads/dev", head.Hash())); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := newTestBareRepo(t)
+	repo := &config.Repo{Destinations: []string{dest}, Ref: "refs/heads/dev"}
+	task := NewSyncTask(dir, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	refs, err := listRefs(dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs["refs/heads/dev"] != head.Hash() {
+		t.Errorf("refs of %s = %v, want only dev", dest, refs)
+	}
+
+	// 其它分支的变化不影响跳过判断
+	commitTestFiles(t, work, map[string]string{"a.txt": "a"})
+	task = NewSyncTask(dir, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if r := task.Results(); len(r) != 1 || r[0].Status != StatusUpToDate {
+		t.Errorf("Results() = %+v, want up to date", r[0])
+	}
+}
@@ -0,0 +1,282 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+	"github.com/skeema/knownhosts"
+	goSSH "golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultSSHUser = "git"
+
+var (
+	defaultPrivateKeyFiles = []string{"id_rsa", "id_ecdsa", "id_ed25519"}
+
+	// knownHostsLock serializes the recording of new host keys
+	knownHostsLock sync.Mutex
+)
+
+// sshAuth is a public keys auth which also negotiates the host key algorithms known for the host
+type sshAuth struct {
+	ssh.PublicKeysCallback
+	hostKeyAlgorithms []string
+
+	// keyFiles, encrypted and agent describe the keys for the system git backend
+	keyFiles  []string
+	encrypted bool
+	agent     bool
+	// hostKey is the verified key of addr, nil if the host could not be scanned
+	hostKey goSSH.PublicKey
+	addr    string
+}
+
+func (a *sshAuth) ClientConfig() (*goSSH.ClientConfig, error) {
+	cfg, err := a.PublicKeysCallback.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg.HostKeyAlgorithms = a.hostKeyAlgorithms
+	return cfg, nil
+}
+
+// getSSHAuth builds the ssh auth of host:port from the per host settings, the private key file
+// and the ssh-agent. Without an explicit key or agent the default keys in ~/.ssh are used.
+func getSSHAuth(user, host string, port int, cfg *config.Config) (*sshAuth, error) {
+	hostCfg := cfg.SSH.GetHost(host, port)
+	if hostCfg.User != "" {
+		user = hostCfg.User
+	}
+	if user == "" {
+		user = defaultSSHUser
+	}
+
+	keyFile, keyPassword := hostCfg.PrivateKeyFile, hostCfg.PrivateKeyPassword
+	if keyFile == "" {
+		keyFile, keyPassword = cfg.PrivateKeyFile, cfg.PrivateKeyPassword
+	}
+
+	signers := make([]goSSH.Signer, 0)
+	keyFiles := make([]string, 0)
+	if keyFile != "" {
+		keys, err := ssh.NewPublicKeysFromFile(user, keyFile, keyPassword.Value())
+		if err != nil {
+			return nil, fmt.Errorf("load private key %s failed: %s", keyFile, err)
+		}
+		signers = append(signers, keys.Signer)
+		keyFiles = append(keyFiles, keyFile)
+	}
+
+	var agentCallback func() ([]goSSH.Signer, error)
+	if cfg.SSH.Agent {
+		agentAuth, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, err
+		}
+		agentCallback = agentAuth.Callback
+	}
+
+	if keyFile == "" && !cfg.SSH.Agent {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range defaultPrivateKeyFiles {
+				file := filepath.Join(home, ".ssh", name)
+				if _, err := os.Stat(file); err != nil {
+					continue
+				}
+				keys, err := ssh.NewPublicKeysFromFile(user, file, keyPassword.Value())
+				if err != nil {
+					logrus.Warnf("load private key %s failed: %s", file, err)
+					continue
+				}
+				signers = append(signers, keys.Signer)
+				keyFiles = append(keyFiles, file)
+			}
+		}
+		if len(signers) == 0 {
+			return nil, fmt.Errorf("no private key found for %s, configure privateKeyFile or enable the ssh agent", host)
+		}
+	}
+	logrus.Debugf("ssh auth for %s:%d, user: %s, privateKeyFile: %s, agent: %t", host, port, user, keyFile, cfg.SSH.Agent)
+
+	auth := &sshAuth{
+		PublicKeysCallback: ssh.PublicKeysCallback{
+			User: user,
+			Callback: func() ([]goSSH.Signer, error) {
+				if agentCallback == nil {
+					return signers, nil
+				}
+				agentSigners, err := agentCallback()
+				if err != nil {
+					return nil, err
+				}
+				return append(signers, agentSigners...), nil
+			},
+		},
+		keyFiles:  keyFiles,
+		encrypted: keyPassword != "",
+		agent:     cfg.SSH.Agent,
+		addr:      net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	auth.HostKeyCallback = hostKeyCallback(&cfg.SSH)
+	if len(hostCfg.Fingerprints) == 0 {
+		if kh, err := loadKnownHosts(&cfg.SSH); err == nil && kh != nil {
+			auth.hostKeyAlgorithms = kh.HostKeyAlgorithms(net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+	}
+	return auth, nil
+}
+
+// hostKeyCallback verifies host keys against the pinned fingerprints of the host if any, otherwise
+// against the known hosts files. Unknown hosts are recorded unless strict host key checking is enabled.
+func hostKeyCallback(sshCfg *config.SSH) goSSH.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key goSSH.PublicKey) error {
+		host, portStr, err := net.SplitHostPort(hostname)
+		if err != nil {
+			host, portStr = hostname, "22"
+		}
+		port, _ := strconv.Atoi(portStr)
+		fingerprint := goSSH.FingerprintSHA256(key)
+
+		if pinned := sshCfg.GetHost(host, port).Fingerprints; len(pinned) > 0 {
+			for _, fp := range pinned {
+				if fp == fingerprint || fp == goSSH.FingerprintLegacyMD5(key) {
+					return nil
+				}
+			}
+			return fmt.Errorf("host key %s of %s does not match the pinned fingerprints", fingerprint, hostname)
+		}
+
+		kh, err := loadKnownHosts(sshCfg)
+		if err != nil {
+			return err
+		}
+		if kh != nil {
+			err = kh(hostname, remote, key)
+			if err == nil {
+				return nil
+			}
+			if !knownhosts.IsHostUnknown(err) {
+				return fmt.Errorf("verify host key %s of %s failed: %w", fingerprint, hostname, err)
+			}
+		}
+
+		if sshCfg.StrictHostKeyChecking {
+			return fmt.Errorf("unknown host %s with key %s, add it to known hosts or pin its fingerprint", hostname, fingerprint)
+		}
+		logrus.Warnf("trust unknown host %s with key %s", hostname, fingerprint)
+		return recordKnownHost(sshCfg, hostname, key)
+	}
+}
+
+// sshKeyScan connects to host:port and verifies its host key in-process, recording
+// the key of an unknown host unless strict host key checking is enabled. The verified
+// key is returned, nil if the host could not be reached.
+func sshKeyScan(host string, port int, sshCfg *config.SSH) (goSSH.PublicKey, error) {
+	var keyErr error
+	var hostKey goSSH.PublicKey
+	cb := hostKeyCallback(sshCfg)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	clientCfg := &goSSH.ClientConfig{
+		User: defaultSSHUser,
+		HostKeyCallback: func(hostname string, remote net.Addr, key goSSH.PublicKey) error {
+			keyErr = cb(hostname, remote, key)
+			if keyErr == nil {
+				hostKey = key
+			}
+			return keyErr
+		},
+		Timeout: 30 * time.Second,
+	}
+	if len(sshCfg.GetHost(host, port).Fingerprints) == 0 {
+		if kh, err := loadKnownHosts(sshCfg); err == nil && kh != nil {
+			clientCfg.HostKeyAlgorithms = kh.HostKeyAlgorithms(addr)
+		}
+	}
+
+	client, err := goSSH.Dial("tcp", addr, clientCfg)
+	if client != nil {
+		_ = client.Close()
+	}
+	if keyErr != nil {
+		return nil, keyErr
+	}
+	if err != nil {
+		// the host key has been verified when authentication fails
+		logrus.Debugf("ssh-keyscan %s: %s", addr, err)
+	}
+	return hostKey, nil
+}
+
+func loadKnownHosts(sshCfg *config.SSH) (knownhosts.HostKeyCallback, error) {
+	files, err := knownHostsFiles(sshCfg)
+	if err != nil {
+		return nil, err
+	}
+	existing := make([]string, 0, len(files))
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	return knownhosts.New(existing...)
+}
+
+func recordKnownHost(sshCfg *config.SSH, hostname string, key goSSH.PublicKey) error {
+	files, err := knownHostsFiles(sshCfg)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no known hosts file to record host keys")
+	}
+
+	knownHostsLock.Lock()
+	defer knownHostsLock.Unlock()
+	if err := os.MkdirAll(filepath.Dir(files[0]), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(files[0], os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+func knownHostsFiles(sshCfg *config.SSH) ([]string, error) {
+	if len(sshCfg.KnownHostsFiles) > 0 {
+		return sshCfg.KnownHostsFiles, nil
+	}
+	return getDefaultKnownHostsFiles()
+}
+
+func getDefaultKnownHostsFiles() ([]string, error) {
+	files := filepath.SplitList(os.Getenv("SSH_KNOWN_HOSTS"))
+	if len(files) != 0 {
+		return files, nil
+	}
+
+	homeDirPath, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		filepath.Join(homeDirPath, "/.ssh/known_hosts"),
+	}, nil
+}
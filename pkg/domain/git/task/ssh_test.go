@@ -0,0 +1,147 @@
+package task
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	goSSH "golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestSSHServer starts a ssh server which only completes the key exchange
+func newTestSSHServer(t *testing.T) (string, int, goSSH.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := goSSH.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg := &goSSH.ServerConfig{
+		PublicKeyCallback: func(goSSH.ConnMetadata, goSSH.PublicKey) (*goSSH.Permissions, error) {
+			return nil, os.ErrPermission
+		},
+	}
+	serverCfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _, _, _ = goSSH.NewServerConn(conn, serverCfg)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	host, portStr, _ := net.SplitHostPort(l.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return host, port, signer.PublicKey()
+}
+
+func Test_sshKeyScan(t *testing.T) {
+	host, port, key := newTestSSHServer(t)
+
+	tests := []struct {
+		name       string
+		sshCfg     func(knownHosts string) *config.SSH
+		wantErr    bool
+		wantRecord bool
+	}{
+		{
+			name: "test record unknown host",
+			sshCfg: func(knownHosts string) *config.SSH {
+				return &config.SSH{KnownHostsFiles: []string{knownHosts}}
+			},
+			wantRecord: true,
+		},
+		{
+			name: "test strict unknown host",
+			sshCfg: func(knownHosts string) *config.SSH {
+				return &config.SSH{KnownHostsFiles: []string{knownHosts}, StrictHostKeyChecking: true}
+			},
+			wantErr: true,
+		},
+		{
+			name: "test pinned fingerprint",
+			sshCfg: func(knownHosts string) *config.SSH {
+				return &config.SSH{
+					KnownHostsFiles:       []string{knownHosts},
+					StrictHostKeyChecking: true,
+					Hosts: map[string]*config.SSHHost{
+						host: {Fingerprints: []string{goSSH.FingerprintSHA256(key)}},
+					},
+				}
+			},
+		},
+		{
+			name: "test mismatched fingerprint",
+			sshCfg: func(knownHosts string) *config.SSH {
+				return &config.SSH{
+					KnownHostsFiles: []string{knownHosts},
+					Hosts: map[string]*config.SSHHost{
+						net.JoinHostPort(host, strconv.Itoa(port)): {Fingerprints: []string{"SHA256:invalid"}},
+					},
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+			_, err := sshKeyScan(host, port, tt.sshCfg(knownHosts))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sshKeyScan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			content, _ := os.ReadFile(knownHosts)
+			if got := strings.Contains(string(content), strings.TrimSpace(string(goSSH.MarshalAuthorizedKey(key)))); got != tt.wantRecord {
+				t.Errorf("sshKeyScan() recorded = %v, want %v", got, tt.wantRecord)
+			}
+		})
+	}
+}
+
+func Test_sshKeyScan_knownHost(t *testing.T) {
+	host, port, _ := newTestSSHServer(t)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	sshCfg := &config.SSH{KnownHostsFiles: []string{knownHosts}}
+	if _, err := sshKeyScan(host, port, sshCfg); err != nil {
+		t.Fatalf("sshKeyScan() error = %v", err)
+	}
+
+	sshCfg.StrictHostKeyChecking = true
+	if _, err := sshKeyScan(host, port, sshCfg); err != nil {
+		t.Errorf("sshKeyScan() recorded host error = %v", err)
+	}
+
+	_, otherPort, _ := newTestSSHServer(t)
+	if err := os.WriteFile(knownHosts, []byte(strings.ReplaceAll(mustRead(t, knownHosts), strconv.Itoa(port), strconv.Itoa(otherPort))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sshKeyScan(host, otherPort, sshCfg); err == nil {
+		t.Errorf("sshKeyScan() changed host key want error")
+	}
+}
+
+func mustRead(t *testing.T, file string) string {
+	bs, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
@@ -0,0 +1,489 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// commitMap maps the commits and tags of the source to the rewritten objects, it is saved after each run
+// so that later runs only rewrite the new commits
+type commitMap struct {
+	// Filter is the fingerprint of the strip options the objects were rewritten with
+	Filter  string            `json:"filter"`
+	Objects map[string]string `json:"objects"`
+}
+
+// commitMapFile returns the commit map file of the source
+func commitMapFile(strip *config.Strip, cfg *config.Config, url string) string {
+	if strip.CommitMap != "" {
+		return strip.CommitMap
+	}
+	return filepath.Join(cfg.StateDir, "commit-map", bundleName(url)+".json")
+}
+
+// stripFingerprint identifies the strip options, a map written with other options is discarded
+func stripFingerprint(strip *config.Strip) string {
+	bs, _ := json.Marshal([]any{strip.Paths, strip.MaxBlobSize})
+	sum := sha256.Sum256(bs)
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadCommitMap reads the commit map, a missing file or a map of other strip options returns an empty map
+func loadCommitMap(file string, strip *config.Strip) (*commitMap, error) {
+	m := &commitMap{Filter: stripFingerprint(strip), Objects: make(map[string]string)}
+	bs, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	saved := new(commitMap)
+	if err := json.Unmarshal(bs, saved); err != nil {
+		return nil, fmt.Errorf("invalid commit map %s: %s", file, err)
+	}
+	if saved.Filter != m.Filter {
+		logrus.Warnf("strip options changed since %s was written, rewrite the whole history", file)
+		return m, nil
+	}
+	if saved.Objects != nil {
+		m.Objects = saved.Objects
+	}
+	return m, nil
+}
+
+func (m *commitMap) save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return writeJSON(file, m)
+}
+
+// mapRefs returns the refs pointing to the rewritten objects, false if any ref has not been rewritten
+func (m *commitMap) mapRefs(refs map[plumbing.ReferenceName]plumbing.Hash) (map[plumbing.ReferenceName]plumbing.Hash, bool) {
+	res := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for name, hash := range mirroredRefs(refs) {
+		mapped, ok := m.Objects[hash.String()]
+		if !ok {
+			return nil, false
+		}
+		res[name] = plumbing.NewHash(mapped)
+	}
+	return res, true
+}
+
+// stripPath is an excluded path, paths starting with a slash or containing a slash only match from the
+// repository root, like .gitignore
+type stripPath struct {
+	pattern  string
+	anchored bool
+}
+
+// historyRewriter rewrites commits without the excluded paths and large blobs, commits whose tree and
+// parents are unchanged keep their ids, rewritten commits lose their signatures
+type historyRewriter struct {
+	s           storer.EncodedObjectStorer
+	paths       []stripPath
+	maxBlobSize int64
+
+	// objects maps the commits and tags of the source to the rewritten objects
+	objects map[plumbing.Hash]plumbing.Hash
+	// trees caches the filtered trees by directory and tree id
+	trees map[string]plumbing.Hash
+	// removed counts the excluded files and directories by path
+	removed map[string]bool
+}
+
+func newHistoryRewriter(s storer.EncodedObjectStorer, strip *config.Strip, m *commitMap) (*historyRewriter, error) {
+	limit, err := strip.BlobSizeLimit()
+	if err != nil {
+		return nil, err
+	}
+	r := &historyRewriter{
+		s:           s,
+		maxBlobSize: limit,
+		objects:     make(map[plumbing.Hash]plumbing.Hash, len(m.Objects)),
+		trees:       make(map[string]plumbing.Hash),
+		removed:     make(map[string]bool),
+	}
+	for _, p := range strip.Paths {
+		pattern := strings.Trim(p, "/")
+		r.paths = append(r.paths, stripPath{
+			pattern:  pattern,
+			anchored: strings.HasPrefix(p, "/") || strings.Contains(pattern, "/"),
+		})
+	}
+	for src, dest := range m.Objects {
+		r.objects[plumbing.NewHash(src)] = plumbing.NewHash(dest)
+	}
+	return r, nil
+}
+
+// rewriteRefs points the mirrored refs to the rewritten objects, refs to trees and blobs are removed since
+// their paths are unknown
+func (r *historyRewriter) rewriteRefs(ctx context.Context, repo *git.Repository) error {
+	refs, err := repoRefs(repo)
+	if err != nil {
+		return err
+	}
+	for name, hash := range mirroredRefs(refs) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj, err := r.s.EncodedObject(plumbing.AnyObject, hash)
+		if err != nil {
+			return err
+		}
+		var mapped plumbing.Hash
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			mapped, err = r.rewriteCommit(hash)
+		case plumbing.TagObject:
+			mapped, err = r.rewriteTag(hash)
+		default:
+			task.Logger(ctx).Warnf("remove %s pointing to a %s, which can not be stripped", name, obj.Type())
+			if err := repo.Storer.RemoveReference(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("rewrite %s failed: %w", name, err)
+		}
+		if mapped != hash {
+			if err := repo.Storer.SetReference(plumbing.NewHashReference(name, mapped)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(r.removed) > 0 {
+		task.Logger(ctx).Infof("strip %d paths from the history", len(r.removed))
+	}
+	return nil
+}
+
+// known returns the rewritten object of hash, objects of the commit map are only used when they exist in
+// the mirror, otherwise they are rewritten again which gives the same ids
+func (r *historyRewriter) known(hash plumbing.Hash) (plumbing.Hash, bool) {
+	mapped, ok := r.objects[hash]
+	if !ok {
+		return plumbing.ZeroHash, false
+	}
+	if mapped != hash && r.s.HasEncodedObject(mapped) != nil {
+		delete(r.objects, hash)
+		return plumbing.ZeroHash, false
+	}
+	return mapped, true
+}
+
+// rewriteCommit rewrites the commit after its parents, without recursion to support deep histories
+func (r *historyRewriter) rewriteCommit(hash plumbing.Hash) (plumbing.Hash, error) {
+	stack := []plumbing.Hash{hash}
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		if _, ok := r.known(h); ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		commit, err := object.GetCommit(r.s, h)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		pending := false
+		for _, p := range commit.ParentHashes {
+			if _, ok := r.known(p); !ok {
+				stack = append(stack, p)
+				pending = true
+			}
+		}
+		if pending {
+			continue
+		}
+		stack = stack[:len(stack)-1]
+
+		mapped, err := r.writeCommit(commit)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		r.objects[h] = mapped
+	}
+	mapped, _ := r.known(hash)
+	return mapped, nil
+}
+
+func (r *historyRewriter) writeCommit(commit *object.Commit) (plumbing.Hash, error) {
+	tree, err := r.filterTree(commit.TreeHash, "")
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if tree.IsZero() {
+		// 所有文件都被排除时使用空树
+		if tree, err = r.writeTree(nil); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	changed := tree != commit.TreeHash
+	parents := make([]plumbing.Hash, 0, len(commit.ParentHashes))
+	for _, p := range commit.ParentHashes {
+		mapped, _ := r.known(p)
+		changed = changed || mapped != p
+		parents = append(parents, mapped)
+	}
+	if !changed {
+		return commit.Hash, nil
+	}
+
+	// 按原始内容改写，保留 encoding、mergetag 等 go-git 不解析的头部，改写后原有的签名不再有效
+	headers, message, err := r.readObject(plumbing.CommitObject, commit.Hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	rewritten := make([]objectHeader, 0, len(headers))
+	for _, h := range headers {
+		switch h.key {
+		case "tree":
+			h.value = tree.String()
+		case "parent":
+			h.value = parents[0].String()
+			parents = parents[1:]
+		case "gpgsig", "gpgsig-sha256":
+			continue
+		}
+		rewritten = append(rewritten, h)
+	}
+	return r.writeObject(plumbing.CommitObject, rewritten, message)
+}
+
+func (r *historyRewriter) rewriteTag(hash plumbing.Hash) (plumbing.Hash, error) {
+	if mapped, ok := r.known(hash); ok {
+		return mapped, nil
+	}
+	tag, err := object.GetTag(r.s, hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	var target plumbing.Hash
+	switch tag.TargetType {
+	case plumbing.CommitObject:
+		target, err = r.rewriteCommit(tag.Target)
+	case plumbing.TagObject:
+		target, err = r.rewriteTag(tag.Target)
+	default:
+		return plumbing.ZeroHash, fmt.Errorf("tag %s points to a %s, which can not be stripped", tag.Name, tag.TargetType)
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	mapped := hash
+	if target != tag.Target {
+		headers, _, err := r.readObject(plumbing.TagObject, hash)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		for i := range headers {
+			if headers[i].key == "object" {
+				headers[i].value = target.String()
+			}
+		}
+		// 标签的签名附在消息之后，tag.Message 不包含签名
+		if mapped, err = r.writeObject(plumbing.TagObject, headers, []byte(tag.Message)); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	r.objects[hash] = mapped
+	return mapped, nil
+}
+
+// objectHeader is a header of a raw commit or tag, the continuation lines of the value are joined by \n
+type objectHeader struct {
+	key   string
+	value string
+}
+
+// readObject splits the raw commit or tag into its headers and message
+func (r *historyRewriter) readObject(t plumbing.ObjectType, hash plumbing.Hash) ([]objectHeader, []byte, error) {
+	obj, err := r.s.EncodedObject(t, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := obj.Reader()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := make([]objectHeader, 0)
+	for len(content) > 0 {
+		line, rest, _ := bytes.Cut(content, []byte("\n"))
+		content = rest
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' && len(headers) > 0 {
+			headers[len(headers)-1].value += "\n" + string(line[1:])
+			continue
+		}
+		key, value, _ := strings.Cut(string(line), " ")
+		headers = append(headers, objectHeader{key: key, value: value})
+	}
+	return headers, content, nil
+}
+
+// writeObject writes the commit or tag of the headers and message to the mirror
+func (r *historyRewriter) writeObject(t plumbing.ObjectType, headers []objectHeader, message []byte) (plumbing.Hash, error) {
+	var buf bytes.Buffer
+	for _, h := range headers {
+		buf.WriteString(h.key + " " + strings.ReplaceAll(h.value, "\n", "\n ") + "\n")
+	}
+	buf.WriteByte('\n')
+	buf.Write(message)
+
+	obj := r.s.NewEncodedObject()
+	obj.SetType(t)
+	obj.SetSize(int64(buf.Len()))
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		_ = w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.s.SetEncodedObject(obj)
+}
+
+// filterTree returns the tree without the excluded entries, the zero hash if nothing is left
+func (r *historyRewriter) filterTree(hash plumbing.Hash, dir string) (plumbing.Hash, error) {
+	key := dir + "\x00" + hash.String()
+	if res, ok := r.trees[key]; ok {
+		return res, nil
+	}
+
+	tree, err := object.GetTree(r.s, hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	changed := false
+	entries := make([]object.TreeEntry, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		p := path.Join(dir, e.Name)
+		if r.excluded(p) {
+			r.removed[p] = true
+			changed = true
+			continue
+		}
+
+		switch e.Mode {
+		case filemode.Dir:
+			sub, err := r.filterTree(e.Hash, p)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if sub.IsZero() {
+				changed = true
+				continue
+			}
+			if sub != e.Hash {
+				e.Hash = sub
+				changed = true
+			}
+		case filemode.Submodule:
+		default:
+			large, err := r.largeBlob(e.Hash)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if large {
+				r.removed[p] = true
+				changed = true
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	res := hash
+	switch {
+	case len(entries) == 0:
+		res = plumbing.ZeroHash
+	case changed:
+		if res, err = r.writeTree(entries); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	r.trees[key] = res
+	return res, nil
+}
+
+func (r *historyRewriter) writeTree(entries []object.TreeEntry) (plumbing.Hash, error) {
+	obj := r.s.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.s.SetEncodedObject(obj)
+}
+
+func (r *historyRewriter) largeBlob(hash plumbing.Hash) (bool, error) {
+	if r.maxBlobSize <= 0 {
+		return false, nil
+	}
+	size, err := r.s.EncodedObjectSize(hash)
+	if err != nil {
+		return false, err
+	}
+	return size > r.maxBlobSize, nil
+}
+
+// excluded reports whether the path matches the strip paths
+func (r *historyRewriter) excluded(p string) bool {
+	name := path.Base(p)
+	for _, sp := range r.paths {
+		if ok, _ := path.Match(sp.pattern, p); ok {
+			return true
+		}
+		if !sp.anchored {
+			if ok, _ := path.Match(sp.pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commitMap returns the map of the rewritten objects to save
+func (r *historyRewriter) commitMap(strip *config.Strip) *commitMap {
+	m := &commitMap{Filter: stripFingerprint(strip), Objects: make(map[string]string, len(r.objects))}
+	for src, dest := range r.objects {
+		m.Objects[src.String()] = dest.String()
+	}
+	return m
+}
@@ -0,0 +1,194 @@
+package task
+
+import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_historyRewriter_excluded(t *testing.T) {
+	r, err := newHistoryRewriter(nil, &config.Strip{Paths: []string{"/internal", "secrets/", "*.key", "docs/private"}}, &commitMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "internal", want: true},
+		{path: "pkg/internal", want: false},
+		{path: "secrets", want: true},
+		{path: "pkg/secrets", want: true},
+		{path: "certs/server.key", want: true},
+		{path: "docs/private", want: true},
+		{path: "src/docs/private", want: false},
+		{path: "README.md", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := r.excluded(tt.path); got != tt.want {
+				t.Errorf("excluded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncTask_Strip(t *testing.T) {
+	dir := newTestRepo(t, map[string]string{
+		"README.md":           "syncer",
+		"internal/secret.txt": "secret",
+		"pkg/big.bin":         strings.Repeat("x", 2048),
+	})
+	work, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := newTestBareRepo(t)
+
+	cfg := config.NewConfig()
+	cfg.StateDir = t.TempDir()
+	repo := &config.Repo{
+		Destinations: []string{dest},
+		Strip:        &config.Strip{Paths: []string{"/internal"}, MaxBlobSize: "1KB"},
+	}
+	run := func() *SyncTask {
+		task := NewSyncTask(dir, repo.Destinations, repo, cfg, nil)
+		if err := task.Run(); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return task
+	}
+	files := func() (*object.Commit, []string) {
+		commit, err := object.GetCommit(openTestRepo(t, dest).Storer, headOf(t, dest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0)
+		_ = tree.Files().ForEach(func(f *object.File) error {
+			names = append(names, f.Name)
+			return nil
+		})
+		return commit, names
+	}
+
+	run()
+	first, names := files()
+	if strings.Join(names, ",") != "README.md" {
+		t.Errorf("files of %s = %v, want only README.md", dest, names)
+	}
+	if first.Hash == headOf(t, dir) {
+		t.Errorf("history of %s is not rewritten", dest)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.StateDir, "commit-map", bundleName(dir)+".json")); err != nil {
+		t.Errorf("commit map is not saved: %v", err)
+	}
+
+	// 新的提交基于上次改写的历史，已改写的提交保持不变
+	commitTestFiles(t, work, map[string]string{"a.txt": "a", "internal/b.txt": "b"})
+	run()
+	second, names := files()
+	if strings.Join(names, ",") != "README.md,a.txt" {
+		t.Errorf("files of %s = %v, want README.md and a.txt", dest, names)
+	}
+	if len(second.ParentHashes) != 1 || second.ParentHashes[0] != first.Hash {
+		t.Errorf("parents of %s = %v, want %s", second.Hash, second.ParentHashes, first.Hash)
+	}
+
+	if r := run().Results(); len(r) != 1 || r[0].Status != StatusUpToDate {
+		t.Errorf("Results() = %+v, want up to date", r[0])
+	}
+}
+
+func Test_historyRewriter_rewriteTag(t *testing.T) {
+	s := memory.NewStorage()
+	write := func(typ plumbing.ObjectType, content string) plumbing.Hash {
+		obj := s.NewEncodedObject()
+		obj.SetType(typ)
+		w, _ := obj.Writer()
+		_, _ = w.Write([]byte(content))
+		_ = w.Close()
+		h, err := s.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	blob := write(plumbing.BlobObject, "syncer")
+	tree := &object.Tree{Entries: []object.TreeEntry{
+		{Name: "README.md", Mode: filemode.Regular, Hash: blob},
+		{Name: "server.key", Mode: filemode.Regular, Hash: blob},
+	}}
+	obj := s.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	treeHash, _ := s.SetEncodedObject(obj)
+
+	signature := "-----BEGIN PGP SIGNATURE-----\n\niQ\n-----END PGP SIGNATURE-----"
+	commit := write(plumbing.CommitObject, "tree "+treeHash.String()+"\n"+
+		"author a <a@example.com> 1700000000 +0800\n"+
+		"committer a <a@example.com> 1700000000 +0800\n"+
+		"encoding ISO-8859-1\n"+
+		"mergetag object 1234\n type commit\n tag v1\n \n "+strings.ReplaceAll(signature, "\n", "\n ")+"\n"+
+		"gpgsig "+strings.ReplaceAll(signature, "\n", "\n ")+"\n"+
+		"\nfirst\n")
+	tag := write(plumbing.TagObject, "object "+commit.String()+"\n"+
+		"type commit\n"+
+		"tag v1.0.0\n"+
+		"tagger a <a@example.com> 1700000000 +0800\n"+
+		"\nrelease\n"+signature+"\n")
+
+	r, err := newHistoryRewriter(s, &config.Strip{Paths: []string{"*.key"}}, &commitMap{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped, err := r.rewriteTag(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapped == tag {
+		t.Fatalf("tag %s is not rewritten", tag)
+	}
+
+	// 保留签名以外的所有头部
+	headers, message, err := r.readObject(plumbing.TagObject, mapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 4 || headers[0].key != "object" || string(message) != "release\n" {
+		t.Errorf("unexpected tag %+v, message %q", headers, message)
+	}
+	headers, message, err = r.readObject(plumbing.CommitObject, plumbing.NewHash(headers[0].value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0)
+	for _, h := range headers {
+		keys = append(keys, h.key)
+	}
+	if strings.Join(keys, ",") != "tree,author,committer,encoding,mergetag" || string(message) != "first\n" {
+		t.Errorf("unexpected commit %v, message %q", keys, message)
+	}
+	if headers[0].value == treeHash.String() || !strings.HasSuffix(headers[4].value, "\n"+signature) {
+		t.Errorf("unexpected commit headers %+v", headers)
+	}
+}
+
+func openTestRepo(t *testing.T, dir string) *git.Repository {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
@@ -0,0 +1,239 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const gitModulesFile = ".gitmodules"
+
+// submoduleMirror is a submodule url found in .gitmodules with its mirror destination
+type submoduleMirror struct {
+	source      string
+	destination string
+}
+
+// syncSubmodules discovers the submodules referenced on the synced branches and tags,
+// and commits rewritten .gitmodules to mirror branches when enabled.
+func syncSubmodules(ctx context.Context, repo *git.Repository, source string, opts *config.Submodules) ([]*submoduleMirror, error) {
+	tmpl, err := template.New("submodule").Option("missingkey=error").Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid submodules template: %s", err)
+	}
+
+	mirrors := make(map[string]*submoduleMirror)
+	refs, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !(ref.Name().IsBranch() || ref.Name().IsTag()) {
+			return nil
+		}
+		commit, err := peelCommit(repo, ref.Hash())
+		if err != nil {
+			task.Logger(ctx).Debugf("skip %s: %s", ref.Name(), err)
+			return nil
+		}
+		modules, err := readModules(commit)
+		if err != nil || modules == nil {
+			return err
+		}
+
+		changed := false
+		for _, m := range modules.Submodules {
+			subUrl := resolveSubmoduleUrl(source, m.URL)
+			mirror, ok := mirrors[subUrl]
+			if !ok {
+				dest, err := renderDestination(tmpl, subUrl)
+				if err != nil {
+					return err
+				}
+				mirror = &submoduleMirror{source: subUrl, destination: dest}
+				mirrors[subUrl] = mirror
+			}
+			if m.URL != mirror.destination {
+				m.URL = mirror.destination
+				changed = true
+			}
+		}
+
+		if !opts.Rewrite || !changed || !ref.Name().IsBranch() || strings.HasPrefix(ref.Name().Short(), opts.BranchPrefix) {
+			return nil
+		}
+		content, err := modules.Marshal()
+		if err != nil {
+			return err
+		}
+		mirrorRef := plumbing.NewBranchReferenceName(opts.BranchPrefix + ref.Name().Short())
+		hash, err := commitModules(repo.Storer, commit, content)
+		if err != nil {
+			return fmt.Errorf("rewrite submodules of %s failed: %s", ref.Name(), err)
+		}
+		task.Logger(ctx).Infof("rewrite submodule urls of %s to %s", ref.Name(), mirrorRef)
+		return repo.Storer.SetReference(plumbing.NewHashReference(mirrorRef, hash))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*submoduleMirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		res = append(res, m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].source < res[j].source
+	})
+	return res, nil
+}
+
+func peelCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	obj, err := repo.Object(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch o := obj.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			obj, err = o.Object()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s is a %s", hash, obj.Type())
+		}
+	}
+}
+
+func readModules(commit *object.Commit) (*gitConfig.Modules, error) {
+	file, err := commit.File(gitModulesFile)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	modules := gitConfig.NewModules()
+	if err := modules.Unmarshal([]byte(content)); err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %s", gitModulesFile, commit.Hash, err)
+	}
+	return modules, nil
+}
+
+// commitModules creates a child of commit replacing .gitmodules with content. The author
+// and committer are copied from the parent, so the same input always gives the same hash.
+func commitModules(s storer.EncodedObjectStorer, parent *object.Commit, content []byte) (plumbing.Hash, error) {
+	tree, err := parent.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	blob := s.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	blobHash, err := s.SetEncodedObject(blob)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	entries := make([]object.TreeEntry, len(tree.Entries))
+	copy(entries, tree.Entries)
+	for i := range entries {
+		if entries[i].Name == gitModulesFile {
+			entries[i].Hash = blobHash
+		}
+	}
+	treeHash, err := storeObject(s, &object.Tree{Entries: entries})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return storeObject(s, &object.Commit{
+		Author:       parent.Author,
+		Committer:    parent.Committer,
+		Message:      fmt.Sprintf("Rewrite submodule urls for mirror\n\nBased on %s\n", parent.Hash),
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	})
+}
+
+func storeObject(s storer.EncodedObjectStorer, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	o := s.NewEncodedObject()
+	if err := obj.Encode(o); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(o)
+}
+
+// resolveSubmoduleUrl resolves a relative submodule url (./ or ../) against the parent url like git does
+func resolveSubmoduleUrl(parent, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+
+	base, sep, repoPath := "", "", parent
+	if u, err := gitutil.ParseUrl(parent); err == nil && !u.IsLocal() {
+		if u.Scp && u.Port == 0 {
+			i := strings.LastIndex(parent[:len(parent)-len(u.Path)], ":")
+			base, sep, repoPath = parent[:i], ":", parent[i+1:]
+		} else if i := strings.Index(parent, "://"); i >= 0 {
+			if j := strings.Index(parent[i+3:], "/"); j >= 0 {
+				base, sep, repoPath = parent[:i+3+j], "/", parent[i+3+j+1:]
+			}
+		}
+	}
+
+	repoPath = strings.TrimSuffix(repoPath, "/")
+	for {
+		if strings.HasPrefix(url, "./") {
+			url = url[2:]
+		} else if strings.HasPrefix(url, "../") {
+			url = url[3:]
+			repoPath = path.Dir(repoPath)
+			if repoPath == "." {
+				repoPath = ""
+			}
+		} else {
+			break
+		}
+	}
+	if repoPath == "" {
+		return base + sep + url
+	}
+	if repoPath == "/" {
+		return base + sep + "/" + url
+	}
+	return base + sep + repoPath + "/" + url
+}
@@ -0,0 +1,163 @@
+package task
+
+import (
+	"context"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_resolveSubmoduleUrl(t *testing.T) {
+	type args struct {
+		parent string
+		url    string
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			name: "test absolute url",
+			args: args{
+				parent: "git@github.com:MR5356/syncer.git",
+				url:    "https://github.com/MR5356/other.git",
+			},
+			want: "https://github.com/MR5356/other.git",
+		},
+		{
+			name: "test relative scp url",
+			args: args{
+				parent: "git@github.com:MR5356/syncer.git",
+				url:    "../other.git",
+			},
+			want: "git@github.com:MR5356/other.git",
+		},
+		{
+			name: "test relative http url",
+			args: args{
+				parent: "https://github.com/MR5356/syncer.git",
+				url:    "../../other/lib.git",
+			},
+			want: "https://github.com/other/lib.git",
+		},
+		{
+			name: "test relative local path",
+			args: args{
+				parent: "/srv/git/syncer.git",
+				url:    "../lib.git",
+			},
+			want: "/srv/git/lib.git",
+		},
+		{
+			name: "test relative ssh url with port",
+			args: args{
+				parent: "ssh://git@host:2222/group/syncer.git",
+				url:    "../lib.git",
+			},
+			want: "ssh://git@host:2222/group/lib.git",
+		},
+		{
+			name: "test dot relative url",
+			args: args{
+				parent: "https://github.com/MR5356/syncer.git",
+				url:    "./lib.git",
+			},
+			want: "https://github.com/MR5356/syncer.git/lib.git",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveSubmoduleUrl(tt.args.parent, tt.args.url); got != tt.want {
+				t.Errorf("resolveSubmoduleUrl() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_syncSubmodules(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := wt.Filesystem.Create(gitModulesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte("[submodule \"lib\"]\n\tpath = lib\n\turl = ../lib.git\n"))
+	_ = f.Close()
+	if _, err := wt.Add(gitModulesFile); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "syncer", Email: "syncer@example.com", When: time.Unix(0, 0)}
+	if _, err := wt.Commit("init", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+
+	mirrors, err := syncSubmodules(context.Background(), repo, "git@github.com:MR5356/syncer.git", &config.Submodules{
+		Template:     "git@git.internal:mirror/{{.Name}}.git",
+		Rewrite:      true,
+		BranchPrefix: "mirror/",
+	})
+	if err != nil {
+		t.Fatalf("syncSubmodules() error = %v", err)
+	}
+	want := []*submoduleMirror{{source: "git@github.com:MR5356/lib.git", destination: "git@git.internal:mirror/lib.git"}}
+	if !reflect.DeepEqual(mirrors, want) {
+		t.Errorf("syncSubmodules() = %+v, want %+v", mirrors, want)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("mirror/master"), true)
+	if err != nil {
+		t.Fatalf("mirror branch not found: %v", err)
+	}
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules, err := readModules(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := modules.Submodules["lib"].URL; got != "git@git.internal:mirror/lib.git" {
+		t.Errorf("rewritten url = %v, want %v", got, "git@git.internal:mirror/lib.git")
+	}
+}
+
+func TestSyncTask_Submodules(t *testing.T) {
+	lib := newTestRepo(t, map[string]string{"lib.go": "package lib"})
+	libDest := newTestBareRepo(t)
+	src := newTestRepo(t, map[string]string{
+		gitModulesFile: "[submodule \"lib\"]\n\tpath = lib\n\turl = " + lib + "\n",
+	})
+	dest := newTestBareRepo(t)
+
+	// 子模块不继承父仓库的改名规则
+	repo := &config.Repo{
+		Destinations: []string{dest},
+		Submodules:   &config.Submodules{Template: libDest, BranchPrefix: "mirror/"},
+		Rename:       renameRules(t, &config.RenameRule{Type: config.RefTypeHeads, Match: "^master$", Replace: "main"}),
+	}
+	if err := NewSyncTask(src, []string{dest}, repo, config.NewConfig(), nil).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for dir, want := range map[string]string{dest: "refs/heads/main", libDest: "refs/heads/master"} {
+		refs, err := listRefs(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := refs[plumbing.ReferenceName(want)]; !ok || len(refs) != 1 {
+			t.Errorf("refs of %s = %v, want only %s", dir, refs, want)
+		}
+	}
+}
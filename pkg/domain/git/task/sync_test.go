@@ -1,8 +1,10 @@
 package task
 
 import (
+	"github.com/MR5356/syncer/pkg/domain/git/config"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -62,7 +64,8 @@ func Test_getAuth(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotAuth, gotRepoUrl, err := getAuth(tt.args.repo, tt.args.privateKeyFile, tt.args.privateKeyPassword)
+			cfg := config.NewConfig(config.WithPrivateKeyFile(tt.args.privateKeyFile), config.WithPrivateKeyPassword(tt.args.privateKeyPassword))
+			gotAuth, gotRepoUrl, err := getAuth(tt.args.repo, cfg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getAuth() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -114,6 +117,55 @@ func Test_getUrlType(t *testing.T) {
 			},
 			want: basicHttpUrlType,
 		},
+		{
+			name: "test ssh url with port",
+			args: args{
+				url: "ssh://git@github.com:2222/group/sub/syncer.git",
+			},
+			want: gitUrlType,
+		},
+		{
+			name: "test scp url without suffix",
+			args: args{
+				url: "git@gitlab.com:group/sub/syncer",
+			},
+			want: gitUrlType,
+		},
+		{
+			name: "test http url without suffix",
+			args: args{
+				url: "https://gitlab.com/group/sub/syncer",
+			},
+			want: httpUrlType,
+		},
+		{
+			name: "test git daemon url",
+			args: args{
+				url: "git://git.kernel.org/pub/scm/git/git.git",
+			},
+			want: gitDaemonUrlType,
+		},
+		{
+			name: "test file url",
+			args: args{
+				url: "file:///srv/git/syncer.git",
+			},
+			want: fileUrlType,
+		},
+		{
+			name: "test local path",
+			args: args{
+				url: "/srv/git/syncer.git",
+			},
+			want: fileUrlType,
+		},
+		{
+			name: "test unsupported url",
+			args: args{
+				url: "ftp://github.com/MR5356/syncer.git",
+			},
+			want: unknownUrlType,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -124,26 +176,89 @@ func Test_getUrlType(t *testing.T) {
 	}
 }
 
-func Test_sshKeyScan(t *testing.T) {
-	type args struct {
-		host string
-		port int
+func Test_getHttpAuth(t *testing.T) {
+	t.Setenv("SYNCER_TEST_TOKEN", "env-token")
+	cfg := config.NewConfig()
+	cfg.Auth = map[string]*config.Auth{
+		"github.com":                 {Username: "user", Token: "token"},
+		"https://gitlab.com/group/":  {TokenEnv: "SYNCER_TEST_TOKEN"},
+		"https://gitlab.com/basic/":  {Username: "user", Password: "password"},
+		"https://gitlab.com/missing": {TokenEnv: "SYNCER_TEST_MISSING"},
 	}
 	tests := []struct {
-		name string
-		args args
+		name     string
+		repo     string
+		wantAuth transport.AuthMethod
+		wantErr  bool
 	}{
 		{
-			name: "test ssh key scan",
-			args: args{
-				host: "github.com",
-				port: 22,
-			},
+			name:     "test token with username",
+			repo:     "https://github.com/MR5356/syncer.git",
+			wantAuth: &http.BasicAuth{Username: "user", Password: "token"},
+		},
+		{
+			name:     "test token from env",
+			repo:     "https://gitlab.com/group/syncer.git",
+			wantAuth: &http.TokenAuth{Token: "env-token"},
+		},
+		{
+			name:     "test basic auth",
+			repo:     "https://gitlab.com/basic/syncer.git",
+			wantAuth: &http.BasicAuth{Username: "user", Password: "password"},
+		},
+		{
+			name:    "test missing env",
+			repo:    "https://gitlab.com/missing/syncer.git",
+			wantErr: true,
+		},
+		{
+			name: "test no auth",
+			repo: "https://gitee.com/MR5356/syncer.git",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			sshKeyScan(tt.args.host, tt.args.port)
+			gotAuth, err := getHttpAuth(tt.repo, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getHttpAuth() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotAuth, tt.wantAuth) {
+				t.Errorf("getHttpAuth() gotAuth = %v, want %v", gotAuth, tt.wantAuth)
+			}
 		})
 	}
 }
+
+func TestSyncTask_Results(t *testing.T) {
+	src := newTestRepo(t, map[string]string{"README.md": "syncer"})
+	synced := newTestBareRepo(t)
+	fresh := newTestBareRepo(t)
+	missing := filepath.Join(t.TempDir(), "missing.git")
+
+	cfg := config.NewConfig()
+	repo := &config.Repo{Destinations: []string{synced}}
+	if err := NewSyncTask(src, []string{synced}, repo, cfg, nil).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	dests := []string{synced, fresh, missing}
+	task := NewSyncTask(src, dests, &config.Repo{Destinations: dests}, cfg, nil)
+	if err := task.Run(); err == nil {
+		t.Fatalf("Run() error = nil, want error of %s", missing)
+	}
+
+	want := []string{StatusUpToDate, StatusPushed, StatusFailed}
+	results := task.Results()
+	if len(results) != len(want) {
+		t.Fatalf("Results() = %d results, want %d", len(results), len(want))
+	}
+	for i, r := range results {
+		if r.Destination != dests[i] || r.Status != want[i] {
+			t.Errorf("Results()[%d] = %s %s, want %s %s", i, r.Destination, r.Status, dests[i], want[i])
+		}
+	}
+	if !testUpToDate(t, src, fresh) {
+		t.Errorf("%s is not up to date after sync", fresh)
+	}
+}
@@ -0,0 +1,246 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"github.com/go-git/go-git/v5"
+	gitConfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/skeema/knownhosts"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// systemGitBackend drives the system git binary, which fetches and pushes large repositories
+// with far less memory than go-git
+type systemGitBackend struct {
+	git string
+	ctx context.Context
+}
+
+func newSystemGitBackend(ctx context.Context) (*systemGitBackend, error) {
+	bin, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("git backend requires the git binary: %s", err)
+	}
+	return &systemGitBackend{git: bin, ctx: ctx}, nil
+}
+
+func (b *systemGitBackend) Clone(dir, url string, auth transport.AuthMethod, opts *cloneOptions) (*git.Repository, error) {
+	if opts == nil {
+		if err := b.run("", url, auth, "clone", "--mirror", "--quiet", url, dir); err != nil {
+			return nil, err
+		}
+		return git.PlainOpen(dir)
+	}
+
+	if err := b.run("", "", nil, "init", "--bare", "--quiet", dir); err != nil {
+		return nil, err
+	}
+	args := []string{"fetch", "--quiet"}
+	if opts.depth > 0 {
+		args = append(args, fmt.Sprintf("--depth=%d", opts.depth))
+	}
+	if !opts.since.IsZero() {
+		args = append(args, "--shallow-since="+opts.since.Format(time.RFC3339))
+	}
+	args = append(args, url)
+	for _, spec := range opts.refSpecs() {
+		args = append(args, spec.String())
+	}
+	if err := b.run(dir, url, auth, args...); err != nil {
+		return nil, err
+	}
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	return repo, opts.setHead(repo)
+}
+
+func (b *systemGitBackend) Fetch(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error {
+	dir, err := repoDir(repo)
+	if err != nil {
+		return err
+	}
+	args := []string{"fetch", "--quiet", url}
+	for _, spec := range specs {
+		args = append(args, spec.String())
+	}
+	return b.run(dir, url, auth, args...)
+}
+
+func (b *systemGitBackend) Push(repo *git.Repository, url string, auth transport.AuthMethod, specs []gitConfig.RefSpec) error {
+	dir, err := repoDir(repo)
+	if err != nil {
+		return err
+	}
+	args := []string{"push", "--quiet", url}
+	for _, spec := range specs {
+		args = append(args, spec.String())
+	}
+	err = b.run(dir, url, auth, args...)
+	if err != nil && strings.Contains(err.Error(), "shallow update not allowed") {
+		return fmt.Errorf("%s rejects shallow pushes, set receive.shallowUpdate to true on the destination or mirror the full history: %w", url, err)
+	}
+	return err
+}
+
+func (b *systemGitBackend) Bundle(repo *git.Repository, file string, header *bundleHeader) error {
+	dir, err := repoDir(repo)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(header.Refs))
+	for name := range header.Refs {
+		names = append(names, name.String())
+	}
+	sort.Strings(names)
+	args := append([]string{"bundle", "create", "--quiet", file}, names...)
+	for _, hash := range header.Prerequisites {
+		args = append(args, "^"+hash.String())
+	}
+	return b.run(dir, "", nil, args...)
+}
+
+// run runs git in dir with the credentials of url passed through the environment, so that they
+// never show up in the arguments or the logs
+func (b *systemGitBackend) run(dir, url string, auth transport.AuthMethod, args ...string) error {
+	env, cleanup, err := gitEnv(url, auth)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if sa, ok := auth.(*sshAuth); ok {
+		args = replaceArg(args, url, sshUrlWithUser(url, sa.User))
+	}
+
+	task.Logger(b.ctx).Debugf("run git %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(b.ctx, b.git, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out := new(bytes.Buffer)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s failed: %s: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// gitEnv returns the environment passing the auth to git, cleanup removes the temporary files
+func gitEnv(url string, auth transport.AuthMethod) ([]string, func(), error) {
+	cleanup := func() {}
+	env := []string{
+		"GIT_TERMINAL_PROMPT=0",
+		// 与 go-git 的 InsecureSkipTLS 保持一致
+		"GIT_SSL_NO_VERIFY=true",
+	}
+	headers := make([]string, 0)
+
+	switch a := auth.(type) {
+	case nil:
+	case *http.BasicAuth:
+		basic := base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password))
+		headers = append(headers, "Authorization: Basic "+basic)
+	case *http.TokenAuth:
+		headers = append(headers, "Authorization: Bearer "+a.Token)
+	case *sshAuth:
+		command, c, err := sshCommand(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup = c
+		env = append(env, "GIT_SSH_COMMAND="+command)
+	default:
+		return nil, nil, fmt.Errorf("auth %s of %s is not supported by the git backend", auth.Name(), url)
+	}
+
+	// GIT_CONFIG_COUNT 需要 git 2.31 以上版本
+	if len(headers) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(headers)))
+		for i, h := range headers {
+			env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", i), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, h))
+		}
+	}
+	return env, cleanup, nil
+}
+
+// sshCommand returns the ssh command using the keys of the auth and trusting only the host key
+// verified by sshKeyScan
+func sshCommand(a *sshAuth) (string, func(), error) {
+	cleanup := func() {}
+	if a.encrypted {
+		return "", nil, fmt.Errorf("the git backend can not use password protected private keys, add the key to the ssh agent instead")
+	}
+
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if !a.agent {
+		args = append(args, "-o", "IdentitiesOnly=yes", "-o", "IdentityAgent=none")
+	}
+	for _, f := range a.keyFiles {
+		args = append(args, "-i", shellQuote(f))
+	}
+
+	if a.hostKey != nil {
+		f, err := os.CreateTemp("", "syncer-known-hosts-*")
+		if err != nil {
+			return "", nil, err
+		}
+		cleanup = func() { _ = os.Remove(f.Name()) }
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{a.addr}, a.hostKey))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		args = append(args, "-o", "UserKnownHostsFile="+shellQuote(f.Name()), "-o", "StrictHostKeyChecking=yes")
+	}
+	return strings.Join(args, " "), cleanup, nil
+}
+
+// sshUrlWithUser replaces the user of an ssh url with the user resolved by getSSHAuth
+func sshUrlWithUser(url, user string) string {
+	u, err := gitutil.ParseUrl(url)
+	if err != nil || user == "" {
+		return url
+	}
+	u.User = user
+	return u.String()
+}
+
+func replaceArg(args []string, old, new string) []string {
+	res := make([]string, len(args))
+	for i, arg := range args {
+		if arg == old && old != "" {
+			arg = new
+		}
+		res[i] = arg
+	}
+	return res
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// repoDir returns the directory of a repository stored on the filesystem
+func repoDir(repo *git.Repository) (string, error) {
+	s, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", fmt.Errorf("the git backend requires a repository on the filesystem")
+	}
+	return s.Filesystem().Root(), nil
+}
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/utils/gitutil"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// repoTemplateData is the data of destination templates
+type repoTemplateData struct {
+	// Name is the repository name without .git, e.g. syncer
+	Name string
+	// Path is the repository path on its host without .git, e.g. MR5356/syncer
+	Path string
+	// Owner is the path without the repository name, e.g. MR5356
+	Owner string
+	// Host is the host of the repository, e.g. github.com
+	Host string
+}
+
+func renderDestination(tmpl *template.Template, url string) (string, error) {
+	host, repoPath := splitRepoUrl(url)
+	repoPath = strings.TrimSuffix(repoPath, ".git")
+	data := &repoTemplateData{
+		Name: path.Base(repoPath),
+		Path: repoPath,
+		Host: host,
+	}
+	if dir := path.Dir(repoPath); dir != "." {
+		data.Owner = dir
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("render destination for %s failed: %s", url, err)
+	}
+	return buf.String(), nil
+}
+
+// splitRepoUrl returns the host (with the port if specified) and the path of a git url
+func splitRepoUrl(url string) (host, repoPath string) {
+	u, err := gitutil.ParseUrl(url)
+	if err != nil {
+		return "", ""
+	}
+	return u.HostWithPort(), strings.Trim(u.Path, "/")
+}
@@ -0,0 +1,45 @@
+package task
+
+import (
+	"testing"
+	"text/template"
+)
+
+func Test_renderDestination(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		url      string
+		want     string
+	}{
+		{
+			name:     "test name",
+			template: "git@git.internal:mirror/{{.Name}}.git",
+			url:      "https://github.com/MR5356/syncer.git",
+			want:     "git@git.internal:mirror/syncer.git",
+		},
+		{
+			name:     "test path",
+			template: "https://git.internal/{{.Host}}/{{.Path}}.git",
+			url:      "git@github.com:MR5356/syncer.git",
+			want:     "https://git.internal/github.com/MR5356/syncer.git",
+		},
+		{
+			name:     "test owner",
+			template: "git@git.internal:{{.Owner}}/{{.Name}}.git",
+			url:      "https://gitlab.com/group/sub/lib",
+			want:     "git@git.internal:group/sub/lib.git",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderDestination(template.Must(template.New("").Parse(tt.template)), tt.url)
+			if err != nil {
+				t.Fatalf("renderDestination() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderDestination() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
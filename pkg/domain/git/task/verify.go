@@ -0,0 +1,268 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	goSSH "golang.org/x/crypto/ssh"
+	"hash"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	sshSignatureMagic     = "SSHSIG"
+	sshSignatureNamespace = "git"
+)
+
+var errUnsigned = errors.New("not signed")
+
+// keyring holds the trusted gpg and ssh keys
+type keyring struct {
+	gpg openpgp.EntityList
+	// ssh maps the marshaled trusted ssh keys to the signer names
+	ssh map[string]string
+}
+
+func loadKeyring(v *config.Verify) (*keyring, error) {
+	k := &keyring{ssh: make(map[string]string)}
+	for _, file := range v.GPGKeys {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read gpg keys %s failed: %s", file, err)
+		}
+		k.gpg = append(k.gpg, entities...)
+	}
+	for _, file := range v.SSHKeys {
+		if err := k.loadSSHKeys(file); err != nil {
+			return nil, fmt.Errorf("read ssh keys %s failed: %s", file, err)
+		}
+	}
+	return k, nil
+}
+
+// loadSSHKeys reads keys in authorized_keys format or allowed_signers format, whose lines start with the
+// principals of the key
+func (k *keyring) loadSSHKeys(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		found := false
+		for i := range fields {
+			// ParseAuthorizedKey 会把前面的字段当作选项，这里要求当前字段就是密钥类型
+			key, comment, _, _, err := goSSH.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+			if err != nil || key.Type() != fields[i] {
+				continue
+			}
+			name := comment
+			if i > 0 {
+				name = fields[0]
+			}
+			if name == "" {
+				name = goSSH.FingerprintSHA256(key)
+			}
+			k.ssh[string(key.Marshal())] = name
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("invalid key at line %d", n)
+		}
+	}
+	return scanner.Err()
+}
+
+// verify checks the detached signature of the payload, returning the name of the signer
+func (k *keyring) verify(payload []byte, signature string) (string, error) {
+	switch {
+	case signature == "":
+		return "", errUnsigned
+	case strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----"):
+		return k.verifySSH(payload, signature)
+	case strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"):
+		entity, err := openpgp.CheckArmoredDetachedSignature(k.gpg, bytes.NewReader(payload), strings.NewReader(signature), nil)
+		if err != nil {
+			return "", err
+		}
+		if id := entity.PrimaryIdentity(); id != nil {
+			return id.Name, nil
+		}
+		return entity.PrimaryKey.KeyIdString(), nil
+	}
+	return "", fmt.Errorf("unsupported signature format")
+}
+
+// verifySSH verifies a signature created by ssh-keygen -Y sign, see PROTOCOL.sshsig of openssh
+func (k *keyring) verifySSH(payload []byte, signature string) (string, error) {
+	block, _ := pem.Decode([]byte(signature))
+	if block == nil || block.Type != "SSH SIGNATURE" || !bytes.HasPrefix(block.Bytes, []byte(sshSignatureMagic)) {
+		return "", fmt.Errorf("invalid ssh signature")
+	}
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if err := goSSH.Unmarshal(block.Bytes[len(sshSignatureMagic):], &sig); err != nil {
+		return "", fmt.Errorf("invalid ssh signature: %s", err)
+	}
+	if sig.Namespace != sshSignatureNamespace {
+		return "", fmt.Errorf("ssh signature of namespace %s, want %s", sig.Namespace, sshSignatureNamespace)
+	}
+
+	key, err := goSSH.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	name, ok := k.ssh[string(key.Marshal())]
+	if !ok {
+		return "", fmt.Errorf("ssh key %s is not trusted", goSSH.FingerprintSHA256(key))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported ssh signature hash %s", sig.HashAlgorithm)
+	}
+	h.Write(payload)
+	signed := append([]byte(sshSignatureMagic), goSSH.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, h.Sum(nil)})...)
+
+	blob := new(goSSH.Signature)
+	if err := goSSH.Unmarshal(sig.Signature, blob); err != nil {
+		return "", fmt.Errorf("invalid ssh signature: %s", err)
+	}
+	if err := key.Verify(signed, blob); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (k *keyring) verifyCommit(commit *object.Commit) (string, error) {
+	payload := new(plumbing.MemoryObject)
+	if err := commit.EncodeWithoutSignature(payload); err != nil {
+		return "", err
+	}
+	return k.verify(encodedBytes(payload), commit.PGPSignature)
+}
+
+func (k *keyring) verifyTag(tag *object.Tag) (string, error) {
+	payload := new(plumbing.MemoryObject)
+	if err := tag.EncodeWithoutSignature(payload); err != nil {
+		return "", err
+	}
+	return k.verify(encodedBytes(payload), tag.PGPSignature)
+}
+
+func encodedBytes(obj *plumbing.MemoryObject) []byte {
+	r, _ := obj.Reader()
+	defer r.Close()
+	buf := new(bytes.Buffer)
+	_, _ = buf.ReadFrom(r)
+	return buf.Bytes()
+}
+
+// verifyRef verifies the tip of a ref, an annotated tag is trusted when either the tag or the commit it points
+// to is signed by a trusted key
+func (k *keyring) verifyRef(repo *git.Repository, hash plumbing.Hash) (string, error) {
+	tag, err := repo.TagObject(hash)
+	if err == nil {
+		signer, tagErr := k.verifyTag(tag)
+		if tagErr == nil {
+			return signer, nil
+		}
+		commit, err := peelCommit(repo, hash)
+		if err != nil {
+			return "", tagErr
+		}
+		if signer, err := k.verifyCommit(commit); err == nil {
+			return signer, nil
+		}
+		return "", tagErr
+	}
+	if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return "", err
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return "", err
+	}
+	return k.verifyCommit(commit)
+}
+
+// withholdUnsigned removes the mirrored refs whose tips are not signed by the trusted keys from the mirror, so
+// that they are not pushed, and returns the withheld refs with the reasons
+func withholdUnsigned(ctx context.Context, repo *git.Repository, v *config.Verify) ([]string, error) {
+	k, err := loadKeyring(v)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := repoRefs(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	withheld := make([]string, 0)
+	for name, hash := range mirroredRefs(refs) {
+		switch {
+		case v.Type == config.RefTypeHeads && !name.IsBranch():
+			continue
+		case v.Type == config.RefTypeTags && !name.IsTag():
+			continue
+		case !name.IsBranch() && !name.IsTag():
+			continue
+		}
+
+		signer, err := k.verifyRef(repo, hash)
+		if err == nil {
+			task.Logger(ctx).Debugf("%s is signed by %s", name, signer)
+			continue
+		}
+		withheld = append(withheld, fmt.Sprintf("%s (%s)", name, err))
+		if err := repo.Storer.RemoveReference(name); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(withheld)
+	if len(withheld) > 0 {
+		task.Logger(ctx).Warnf("withhold %d refs failing signature verification: %s", len(withheld), strings.Join(withheld, ", "))
+	}
+	return withheld, nil
+}
@@ -0,0 +1,187 @@
+package task
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"github.com/MR5356/syncer/pkg/domain/git/config"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	goSSH "golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSSHSigner(t *testing.T) goSSH.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := goSSH.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// sshSign signs the payload like ssh-keygen -Y sign -n git
+func sshSign(t *testing.T, signer goSSH.Signer, payload []byte) string {
+	sum := sha512.Sum512(payload)
+	signed := append([]byte(sshSignatureMagic), goSSH.Marshal(struct {
+		Namespace, Reserved, HashAlgorithm string
+		Hash                               []byte
+	}{sshSignatureNamespace, "", "sha512", sum[:]})...)
+	sig, err := signer.Sign(rand.Reader, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append([]byte(sshSignatureMagic), goSSH.Marshal(struct {
+		Version                            uint32
+		PublicKey                          []byte
+		Namespace, Reserved, HashAlgorithm string
+		Signature                          []byte
+	}{1, signer.PublicKey().Marshal(), sshSignatureNamespace, "", "sha512", goSSH.Marshal(sig)})...)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}))
+}
+
+// storeTestCommit stores a commit on top of parent signed by sign, nil for unsigned
+func storeTestCommit(t *testing.T, repo *git.Repository, parent plumbing.Hash, sign func([]byte) string) plumbing.Hash {
+	p, err := repo.CommitObject(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := object.Signature{Name: "syncer", Email: "syncer@example.com", When: time.Unix(1700000000, 0)}
+	commit := &object.Commit{Author: sig, Committer: sig, Message: "commit\n", TreeHash: p.TreeHash, ParentHashes: []plumbing.Hash{parent}}
+	if sign != nil {
+		payload := new(plumbing.MemoryObject)
+		if err := commit.EncodeWithoutSignature(payload); err != nil {
+			t.Fatal(err)
+		}
+		commit.PGPSignature = sign(encodedBytes(payload))
+	}
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestSyncTask_VerifySignatures(t *testing.T) {
+	keyDir := t.TempDir()
+	entity, err := openpgp.NewEntity("syncer", "", "syncer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	armored := new(bytes.Buffer)
+	w, err := armor.Encode(armored, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	gpgKeys := filepath.Join(keyDir, "keys.asc")
+	if err := os.WriteFile(gpgKeys, armored.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trusted, untrusted := newTestSSHSigner(t), newTestSSHSigner(t)
+	sshKeys := filepath.Join(keyDir, "allowed_signers")
+	line := "dev@example.com namespaces=\"git\" " + string(goSSH.MarshalAuthorizedKey(trusted.PublicKey()))
+	if err := os.WriteFile(sshKeys, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "src")
+	work, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("syncer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wt, _ := work.Worktree()
+	if _, err := wt.Add("README.md"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "syncer", Email: "syncer@example.com", When: time.Unix(1700000000, 0)}
+	master, err := wt.Commit("commit", &git.CommitOptions{Author: sig, Committer: sig, SignKey: entity})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/unsigned": storeTestCommit(t, work, master, nil),
+		"refs/heads/ssh": storeTestCommit(t, work, master, func(p []byte) string {
+			return sshSign(t, trusted, p)
+		}),
+		"refs/heads/untrusted": storeTestCommit(t, work, master, func(p []byte) string {
+			return sshSign(t, untrusted, p)
+		}),
+	}
+	for name, hash := range refs {
+		if err := work.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 签名的附注标签指向未签名的提交
+	tag := &object.Tag{Name: "v1", Tagger: *sig, Message: "v1\n", TargetType: plumbing.CommitObject, Target: refs["refs/heads/unsigned"]}
+	payload := new(plumbing.MemoryObject)
+	if err := tag.EncodeWithoutSignature(payload); err != nil {
+		t.Fatal(err)
+	}
+	tag.PGPSignature = sshSign(t, trusted, encodedBytes(payload))
+	obj := work.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	tagHash, err := work.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := work.Storer.SetReference(plumbing.NewHashReference("refs/tags/v1", tagHash)); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := newTestBareRepo(t)
+	repo := &config.Repo{Destinations: []string{dest}, Verify: &config.Verify{GPGKeys: []string{gpgKeys}, SSHKeys: []string{sshKeys}}}
+	task := NewSyncTask(dir, repo.Destinations, repo, config.NewConfig(), nil)
+	if err := task.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	destRefs, err := listRefs(dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []plumbing.ReferenceName{plumbing.Master, "refs/heads/ssh", "refs/tags/v1"} {
+		if _, ok := destRefs[name]; !ok {
+			t.Errorf("refs of %s = %v, want %s", dest, destRefs, name)
+		}
+	}
+	for _, name := range []plumbing.ReferenceName{"refs/heads/unsigned", "refs/heads/untrusted"} {
+		if _, ok := destRefs[name]; ok {
+			t.Errorf("unverified %s is pushed to %s", name, dest)
+		}
+	}
+
+	r := task.Results()
+	if len(r) != 1 || len(r[0].Withheld) != 2 {
+		t.Fatalf("Results() = %+v, want 2 withheld refs", r[0])
+	}
+	if !strings.HasPrefix(r[0].Withheld[0], "refs/heads/unsigned") || !strings.HasPrefix(r[0].Withheld[1], "refs/heads/untrusted") {
+		t.Errorf("Withheld = %v, want unsigned and untrusted", r[0].Withheld)
+	}
+}
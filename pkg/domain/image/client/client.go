@@ -1,21 +1,33 @@
 package client
 
 import (
+	"context"
+	"fmt"
 	"github.com/MR5356/syncer/pkg/domain/image/config"
 	"github.com/MR5356/syncer/pkg/domain/image/task"
+	log2 "github.com/MR5356/syncer/pkg/log"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/progress"
 	task2 "github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
 	"github.com/avast/retry-go"
 	"github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
 
+// domain is the domain label of the metrics
+const domain = "image"
+
 type Client struct {
 	taskList *task2.List
 
 	failedTaskList  *task2.List
 	succeedTaskList *task2.List
 
+	// errors holds the last error of each failed task
+	errors sync.Map
+
 	config *config.Config
 }
 
@@ -31,18 +43,30 @@ func NewClient(cfg *config.Config) *Client {
 }
 
 func (c *Client) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext runs the sync tasks with the logger and the progress tracker of ctx, canceling ctx stops the running
+// tasks and skips the remaining ones
+func (c *Client) RunContext(ctx context.Context) (err error) {
+	// 一次运行的所有任务属于同一个 trace
+	ctx, span := tracing.Start(ctx, "image.Client.Run")
+	defer func() { tracing.End(span, err) }()
 	start := time.Now()
+	log := task2.Logger(ctx)
 
 	var ch = make(chan struct{}, c.config.Proc)
 	var wg = sync.WaitGroup{}
 
 	taskList, err := task.GenerateSyncTaskList(c.config, ch)
 	if err != nil {
-		logrus.Fatalf("error generate sync task list: %s", err)
+		return fmt.Errorf("error generate sync task list: %s", err)
 	}
 	c.taskList = taskList
+	tracker := progress.FromContext(ctx)
+	tracker.AddTasks(c.taskList.Length())
 
-	logrus.Infof("run sync task with %d processes", c.config.Proc)
+	log.Infof("run sync task with %d processes", c.config.Proc)
 
 	for t := range c.taskList.Iterator() {
 		//ch <- struct{}{}
@@ -50,25 +74,46 @@ func (c *Client) Run() error {
 
 		t := t
 		go func() {
-			logrus.Infof("start sync task: %s", t.Name())
-
-			if err := retry.Do(
-				t.Run,
+			// 任务内的日志携带任务的字段
+			log := log.WithFields(logrus.Fields{task2.FieldDomain: domain, task2.FieldTask: t.Name()})
+			log.Infof("start sync task: %s", t.Name())
+			ctx := progress.WithTask(ctx, t.Name())
+
+			start := time.Now()
+			attempt := 0
+			err := retry.Do(
+				func() error {
+					attempt++
+					return task2.Run(task2.WithLogger(ctx, log.WithField(task2.FieldAttempt, attempt)), t)
+				},
+				retry.Context(ctx),
+				// 取消后不再重试
+				retry.RetryIf(func(error) bool { return ctx.Err() == nil }),
 				retry.Attempts(uint(c.config.Retries)),
 				retry.Delay(0),
 				retry.LastErrorOnly(true),
 				retry.DelayType(retry.DefaultDelayType),
 				retry.OnRetry(func(n uint, err error) {
-					logrus.Warnf("%d/%d: retry %s with error %s", n+1, c.config.Retries, t.Name(), err)
+					log.Warnf("%d/%d: retry %s with error %s", n+1, c.config.Retries, t.Name(), err)
+					// 最后一次失败后也会调用 OnRetry，但不再重试
+					if int(n)+1 < c.config.Retries {
+						metrics.TaskRetries.WithLabelValues(domain).Inc()
+					}
 				}),
-			); err != nil {
-				logrus.Errorf("run sync task %s failed: %s", t.Name(), err)
+			)
+			metrics.ObserveTask(domain, start, err)
+			tracker.TaskDone()
+			if err != nil {
+				log.Errorf("run sync task %s failed: %s", t.Name(), err)
+				c.errors.Store(t.Name(), err)
 				c.failedTaskList.Add(t)
 			} else {
-				logrus.Infof("run sync task %s succeed", t.Name())
+				log.Infof("run sync task %s succeed", t.Name())
 				c.succeedTaskList.Add(t)
 			}
 
+			// 任务结束后关闭任务的日志文件
+			log2.CloseTask(t.Name())
 			//<-ch
 			wg.Done()
 		}()
@@ -79,9 +124,32 @@ func (c *Client) Run() error {
 
 	if c.failedTaskList.Length() > 0 {
 		for t := range c.failedTaskList.Iterator() {
-			logrus.Warnf("task %s failed", t.Name())
+			log.Warnf("task %s failed", t.Name())
 		}
 	}
-	logrus.Infof("image sync finished, %d/%d task failed, cost %s", c.failedTaskList.Length(), c.taskList.Length(), cost)
+	log.Infof("image sync finished, %d/%d task failed, cost %s", c.failedTaskList.Length(), c.taskList.Length(), cost)
+	return nil
+}
+
+// Tasks returns the tasks of the last run
+func (c *Client) Tasks() *task2.List {
+	return c.taskList
+}
+
+// Failed returns the tasks failed in the last run
+func (c *Client) Failed() *task2.List {
+	return c.failedTaskList
+}
+
+// Succeeded returns the tasks succeeded in the last run
+func (c *Client) Succeeded() *task2.List {
+	return c.succeedTaskList
+}
+
+// Err returns the last error of a failed task
+func (c *Client) Err(t task2.Task) error {
+	if err, ok := c.errors.Load(t.Name()); ok {
+		return err.(error)
+	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"github.com/MR5356/syncer/pkg/notify"
 	"github.com/MR5356/syncer/pkg/utils/configutil"
 	"github.com/mcuadros/go-defaults"
 	"github.com/sirupsen/logrus"
@@ -11,6 +12,10 @@ type Config struct {
 	Images  map[string]any   `json:"images" yaml:"images"`
 	Proc    int              `json:"proc" yaml:"proc"`
 	Retries int              `json:"retries" yaml:"retries"`
+	// StateDir holds the state kept between runs, like the failed tasks of the last run for the change notifications
+	StateDir string `json:"stateDir" yaml:"stateDir" default:".syncer"`
+	// Notifications post the summary of each run to webhooks
+	Notifications []*notify.Notification `json:"notifications" yaml:"notifications"`
 }
 
 type Auth struct {
@@ -31,8 +31,9 @@ func TestNewConfigFromFile(t *testing.T) {
 				Images: map[string]any{
 					"nginx:latest": "test/nginx:latest",
 				},
-				Proc:    8,
-				Retries: 10,
+				Proc:     8,
+				Retries:  10,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -51,8 +52,9 @@ func TestNewConfigFromFile(t *testing.T) {
 				Images: map[string]any{
 					"nginx:latest": "test/nginx:latest",
 				},
-				Proc:    8,
-				Retries: 10,
+				Proc:     8,
+				Retries:  10,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -61,10 +63,11 @@ func TestNewConfigFromFile(t *testing.T) {
 				cf: "testdata/config_nothing.yaml",
 			},
 			want: &Config{
-				Auth:    make(map[string]*Auth),
-				Images:  make(map[string]any),
-				Proc:    0,
-				Retries: 0,
+				Auth:     make(map[string]*Auth),
+				Images:   make(map[string]any),
+				Proc:     0,
+				Retries:  0,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -73,10 +76,11 @@ func TestNewConfigFromFile(t *testing.T) {
 				cf: "testdata/config_nothing.json",
 			},
 			want: &Config{
-				Auth:    make(map[string]*Auth),
-				Images:  make(map[string]any),
-				Proc:    0,
-				Retries: 0,
+				Auth:     make(map[string]*Auth),
+				Images:   make(map[string]any),
+				Proc:     0,
+				Retries:  0,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -89,8 +93,9 @@ func TestNewConfigFromFile(t *testing.T) {
 				Images: map[string]any{
 					"nginx:latest": "test/nginx:latest",
 				},
-				Proc:    8,
-				Retries: 10,
+				Proc:     8,
+				Retries:  10,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -103,8 +108,9 @@ func TestNewConfigFromFile(t *testing.T) {
 				Images: map[string]any{
 					"nginx:latest": "test/nginx:latest",
 				},
-				Proc:    8,
-				Retries: 10,
+				Proc:     8,
+				Retries:  10,
+				StateDir: ".syncer",
 			},
 		},
 		{
@@ -120,8 +126,9 @@ func TestNewConfigFromFile(t *testing.T) {
 						"test2/nginx:latest",
 					},
 				},
-				Proc:    8,
-				Retries: 10,
+				Proc:     8,
+				Retries:  10,
+				StateDir: ".syncer",
 			},
 		},
 	}
@@ -1,18 +1,25 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/MR5356/syncer/pkg/domain/image/config"
 	types3 "github.com/MR5356/syncer/pkg/domain/image/types"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/progress"
 	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
 	"github.com/MR5356/syncer/pkg/utils/imageutil"
 	"github.com/containers/image/v5/manifest"
 	types2 "github.com/containers/image/v5/types"
 	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
+	"io"
 )
 
 type SyncTask struct {
@@ -28,6 +35,8 @@ type SyncTask struct {
 type Sync struct {
 	source      *types3.ImageSource
 	destination *types3.ImageDestination
+	// tag is the tag or digest of the source image
+	tag string
 }
 
 func NewSyncTask(source, destination string, getAuthFunc func(repo string) *config.Auth, ch chan struct{}) *SyncTask {
@@ -74,7 +83,21 @@ func (t *SyncTask) Name() string {
 	return t.name
 }
 
+// Mapping returns the source of the mapping of the task in the images
+func (t *SyncTask) Mapping() string {
+	return t.source
+}
+
 func (t *SyncTask) Run() error {
+	return t.RunContext(context.Background())
+}
+
+// RunContext runs the task with the logger of ctx, it stops before the next blob when ctx is canceled
+func (t *SyncTask) RunContext(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "image.SyncTask.Run", attribute.String("source", t.source), attribute.String("destination", t.destination))
+	defer func() { tracing.End(span, err) }()
+	log := task.Logger(ctx).WithFields(logrus.Fields{task.FieldSource: t.source, task.FieldDestination: t.destination})
+	ctx = task.WithLogger(ctx, log)
 	// 支持的镜像同步规则
 	// 源镜像【包含tag或digest】 -> 目标镜像【包含/不包含tag或digest】：镜像对应的tag或digest都会同步至目标镜像对应的tag或digest，不包含则表示使用源tag
 	// 源镜像【不包含tag或digest】-> 目标镜像：镜像所有的tag都会同步至目标镜像
@@ -82,18 +105,18 @@ func (t *SyncTask) Run() error {
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("source image info: %+v", srcImageInfo)
+	log.Debugf("source image info: %+v", srcImageInfo)
 
 	destImageInfo, err := imageutil.ParseImageInfo(t.destination)
 	if err != nil {
 		return err
 	}
-	logrus.Debugf("destination image info: %+v", destImageInfo)
+	log.Debugf("destination image info: %+v", destImageInfo)
 
 	syncList := make([]*Sync, 0)
 
 	if srcImageInfo.TagOrDigest != "" {
-		logrus.Debugf("source image info tag or digest: %s", srcImageInfo.TagOrDigest)
+		log.Debugf("source image info tag or digest: %s", srcImageInfo.TagOrDigest)
 		srcAuth := t.getAuthFunc(srcImageInfo.Registry)
 		srcRef, err := types3.NewImageSource(srcImageInfo.Registry, srcImageInfo.GetRepo(), srcImageInfo.TagOrDigest, srcAuth.Username, srcAuth.Password, srcAuth.Insecure)
 		if err != nil {
@@ -112,9 +135,10 @@ func (t *SyncTask) Run() error {
 		syncList = append(syncList, &Sync{
 			source:      srcRef,
 			destination: destRef,
+			tag:         srcImageInfo.TagOrDigest,
 		})
 	} else {
-		logrus.Debugf("source image info tag or digest is empty")
+		log.Debugf("source image info tag or digest is empty")
 		srcAuth := t.getAuthFunc(srcImageInfo.Registry)
 		destAuth := t.getAuthFunc(destImageInfo.Registry)
 
@@ -122,16 +146,22 @@ func (t *SyncTask) Run() error {
 		if err != nil {
 			return err
 		}
+		_, tagSpan := tracing.Start(ctx, "image.GetTags", attribute.String("repository", srcImageInfo.Registry+"/"+srcImageInfo.GetRepo()))
 		tags, err := src.GetTags()
+		tagSpan.SetAttributes(attribute.Int("tags", len(tags)))
+		tracing.End(tagSpan, err)
 		if err != nil {
 			return err
 		}
-		logrus.Infof("source image tags: %+v", tags)
+		log.Infof("source image tags: %+v", tags)
 
 		group := new(errgroup.Group)
 
 		for _, tag := range tags {
-			t.ch <- struct{}{}
+			if err := t.acquire(ctx); err != nil {
+				_ = group.Wait()
+				return err
+			}
 
 			tag := tag
 			group.Go(func() error {
@@ -151,6 +181,7 @@ func (t *SyncTask) Run() error {
 				syncList = append(syncList, &Sync{
 					source:      srcRef,
 					destination: destRef,
+					tag:         tag,
 				})
 
 				return nil
@@ -158,20 +189,18 @@ func (t *SyncTask) Run() error {
 		}
 
 		if err := group.Wait(); err != nil {
-			logrus.Errorf("err: %+v", err)
+			log.Errorf("err: %+v", err)
 			return err
 		}
 	}
 
-	logrus.Debugf("s list: %+v", syncList)
+	log.Debugf("s list: %+v", syncList)
 
 	for _, s := range syncList {
-		mf, manifestType, err := s.source.GetManifest()
-		if err != nil {
-			return err
-		}
-		logrus.Infof("parsing manifest...")
-		mfObj, mfBytes, subMfs, err := GetManifests(mf, manifestType, s.source, nil)
+		log := log.WithField(task.FieldTag, s.tag)
+		ctx := task.WithLogger(ctx, log)
+		log.Infof("parsing manifest...")
+		mfObj, mfBytes, subMfs, err := getManifests(ctx, s.source)
 		if err != nil {
 			return err
 		}
@@ -189,15 +218,18 @@ func (t *SyncTask) Run() error {
 			for _, info := range blobInfos {
 				info := info
 
-				t.ch <- struct{}{}
+				if err := t.acquire(ctx); err != nil {
+					_ = group.Wait()
+					return err
+				}
 				group.Go(func() error {
 					defer func() {
 						<-t.ch
 					}()
-					return transBlob(s.source, s.destination, info)
+					return transBlob(ctx, s.source, s.destination, info)
 				})
 				if err := group.Wait(); err != nil {
-					logrus.Errorf("err: %+v", err)
+					log.Errorf("err: %+v", err)
 					return err
 				}
 			}
@@ -206,7 +238,10 @@ func (t *SyncTask) Run() error {
 			for _, mfInfo := range subMfs {
 				mfInfo := mfInfo
 
-				t.ch <- struct{}{}
+				if err := t.acquire(ctx); err != nil {
+					_ = group.Wait()
+					return err
+				}
 				group.Go(func() error {
 					defer func() {
 						<-t.ch
@@ -216,7 +251,10 @@ func (t *SyncTask) Run() error {
 						return err
 					}
 					for _, info := range blobInfos {
-						err := transBlob(s.source, s.destination, info)
+						if err := ctx.Err(); err != nil {
+							return err
+						}
+						err := transBlob(ctx, s.source, s.destination, info)
 						if err != nil {
 							return err
 						}
@@ -229,7 +267,7 @@ func (t *SyncTask) Run() error {
 				})
 			}
 			if err := group.Wait(); err != nil {
-				logrus.Errorf("err: %+v", err)
+				log.Errorf("err: %+v", err)
 				return err
 			}
 		}
@@ -242,14 +280,33 @@ func (t *SyncTask) Run() error {
 	return nil
 }
 
-func transBlob(source *types3.ImageSource, destination *types3.ImageDestination, info types2.BlobInfo) error {
-	logrus.Infof("trans blob: %s", info.Digest)
+// acquire takes a slot of the process channel, it fails when ctx is canceled first
+func (t *SyncTask) acquire(ctx context.Context) error {
+	select {
+	case t.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func transBlob(ctx context.Context, source *types3.ImageSource, destination *types3.ImageDestination, info types2.BlobInfo) (err error) {
+	ctx, span := tracing.Start(ctx, "image.transBlob", attribute.String("digest", info.Digest.String()), attribute.Int64("size", info.Size))
+	defer func() { tracing.End(span, err) }()
+
+	log := task.Logger(ctx).WithField(task.FieldDigest, info.Digest.String())
+	log.Infof("trans blob: %s", info.Digest)
+	_, existSpan := tracing.Start(ctx, "image.CheckBlobExist")
 	exist, err := destination.CheckBlobExist(info)
+	existSpan.SetAttributes(attribute.Bool("exist", exist))
+	tracing.End(existSpan, err)
 	if err != nil {
 		return err
 	}
+	span.SetAttributes(attribute.Bool("skipped", exist))
 	if exist {
-		logrus.Infof("blob %s already exist, skipping", info.Digest)
+		log.Infof("blob %s already exist, skipping", info.Digest)
+		metrics.BlobsSkipped.WithLabelValues(destination.Registry()).Inc()
 		return nil
 	}
 	blob, size, err := source.GetBlob(info)
@@ -257,13 +314,51 @@ func transBlob(source *types3.ImageSource, destination *types3.ImageDestination,
 		return err
 	}
 	info.Size = size
-	if err := destination.PutBlob(blob, info); err != nil {
+	span.SetAttributes(attribute.Int64("size", size))
+	// 按读取的字节计数，失败的传输也计入已传输的流量
+	progressBlob := progress.StartBlob(ctx, info.Digest.String(), size)
+	defer progressBlob.Done()
+	blob = &countingReader{ReadCloser: blob, counter: metrics.BytesTransferred.WithLabelValues(source.Registry(), destination.Registry()), progress: progressBlob}
+	_, putSpan := tracing.Start(ctx, "image.PutBlob")
+	err = destination.PutBlob(blob, info)
+	tracing.End(putSpan, err)
+	if err != nil {
 		return err
 	}
-	logrus.Infof("trans blob: %s success", info.Digest)
+	log.Infof("trans blob: %s success", info.Digest)
 	return nil
 }
 
+// countingReader adds the bytes read to counter and to the progress of the blob
+type countingReader struct {
+	io.ReadCloser
+	counter  prometheus.Counter
+	progress *progress.Blob
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	r.progress.Add(n)
+	return n, err
+}
+
+// getManifests fetches the manifest of source with the sub manifests of the manifest list
+func getManifests(ctx context.Context, source *types3.ImageSource) (obj interface{}, mfBytes []byte, subMfs []*ManifestInfo, err error) {
+	_, span := tracing.Start(ctx, "image.GetManifests")
+	defer func() {
+		span.SetAttributes(attribute.Int("manifests", len(subMfs)))
+		tracing.End(span, err)
+	}()
+
+	mf, manifestType, err := source.GetManifest()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	span.SetAttributes(attribute.String("media_type", manifestType))
+	return GetManifests(mf, manifestType, source, nil)
+}
+
 type ManifestInfo struct {
 	Obj    manifest.Manifest
 	Digest *digest.Digest
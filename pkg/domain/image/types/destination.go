@@ -12,6 +12,7 @@ import (
 
 type ImageDestination struct {
 	destination types.ImageDestination
+	registry    string
 	ctx         context.Context
 	sysCtx      *types.SystemContext
 }
@@ -43,11 +44,16 @@ func NewImageDestination(registry, repository, tagOrDigest, username, password s
 	destination, err := srcRef.NewImageDestination(ctx, sysCtx)
 	return &ImageDestination{
 		destination: destination,
+		registry:    registry,
 		ctx:         ctx,
 		sysCtx:      sysCtx,
 	}, nil
 }
 
+func (i *ImageDestination) Registry() string {
+	return i.registry
+}
+
 func (i *ImageDestination) PutManifest(manifestBytes []byte, instanceDigest *digest.Digest) error {
 	return i.destination.PutManifest(i.ctx, manifestBytes, instanceDigest)
 }
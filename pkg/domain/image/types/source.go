@@ -11,7 +11,8 @@ import (
 )
 
 type ImageSource struct {
-	ref types.ImageReference
+	ref      types.ImageReference
+	registry string
 
 	source types.ImageSource
 	ctx    context.Context
@@ -54,7 +55,8 @@ func NewImageSource(registry, repository, tagOrDigest, username, password string
 	}
 
 	return &ImageSource{
-		ref: srcRef,
+		ref:      srcRef,
+		registry: registry,
 
 		source: source,
 		ctx:    ctx,
@@ -63,6 +65,10 @@ func NewImageSource(registry, repository, tagOrDigest, username, password string
 
 }
 
+func (s *ImageSource) Registry() string {
+	return s.registry
+}
+
 func (s *ImageSource) GetManifest() ([]byte, string, error) {
 	return s.source.GetManifest(s.ctx, nil)
 }
@@ -1,23 +1,204 @@
 package log
 
 import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/task"
 	nested "github.com/antonfisher/nested-logrus-formatter"
 	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+
+	// maxFileName limits the length of the task log files, longer names are cut and suffixed with a hash
+	maxFileName = 100
+	// maxOpenFiles limits the task log files kept open, the least recently written one is closed beyond it
+	maxOpenFiles = 32
+)
+
+var (
+	unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+	// tasks is the hook of the task log files installed by Setup
+	tasks atomic.Pointer[taskHook]
 )
 
 func init() {
 	logrus.SetReportCaller(true)
+	logrus.SetFormatter(newTextFormatter(false))
+}
+
+// Setup sets the format of the logs, text or json, and writes the entries of each task to its own file in taskDir
+// if set. The json entries carry the task fields of task.Fields, the text entries leave them out. The returned
+// function closes the task log files
+func Setup(format, taskDir string) (closeLog func(), err error) {
+	var formatter, fileFormatter logrus.Formatter
+	switch format {
+	case "", FormatText:
+		formatter, fileFormatter = newTextFormatter(false), newTextFormatter(true)
+	case FormatJson:
+		formatter = &logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+			// 任务名中的 -> 不转义
+			DisableHTMLEscape: true,
+			// 不输出调用位置
+			CallerPrettyfier: func(*runtime.Frame) (string, string) { return "", "" },
+		}
+		fileFormatter = formatter
+	default:
+		return nil, fmt.Errorf("unsupported log format %s, should be %s or %s", format, FormatText, FormatJson)
+	}
+	logrus.SetFormatter(formatter)
+
+	if taskDir == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(taskDir, 0755); err != nil {
+		return nil, err
+	}
+	h := &taskHook{dir: taskDir, formatter: fileFormatter, files: make(map[string]*list.Element), lru: list.New()}
+	logrus.AddHook(h)
+	tasks.Store(h)
+	return h.closeAll, nil
+}
+
+// CloseTask closes the log file of the task named name once the task finishes, a later entry of the task
+// opens it again
+func CloseTask(name string) {
+	if h := tasks.Load(); h != nil {
+		h.close(name)
+	}
+}
 
-	logrus.SetFormatter(&nested.Formatter{
+func newTextFormatter(noColors bool) logrus.Formatter {
+	return &textFormatter{&nested.Formatter{
 		HideKeys:        false,
 		FieldsOrder:     []string{"level"},
 		TimestampFormat: "2006-01-02 15:04:05",
 		TrimMessages:    true,
 		CallerFirst:     false,
+		NoColors:        noColors,
 		CustomCallerFormatter: func(frame *runtime.Frame) string {
 			return ""
 			//return fmt.Sprintf(" %s:%d", frame.Function, frame.Line)
 		},
-	})
+	}}
+}
+
+// textFormatter leaves out the task fields, the messages of the tasks already name them
+type textFormatter struct {
+	*nested.Formatter
+}
+
+func (f *textFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		if !task.IsField(k) {
+			data[k] = v
+		}
+	}
+	entry := *e
+	entry.Data = data
+	return f.Formatter.Format(&entry)
+}
+
+// taskHook appends the entries of each task to the file of the task in dir, at most maxOpenFiles files are kept
+// open
+type taskHook struct {
+	dir       string
+	formatter logrus.Formatter
+
+	lock sync.Mutex
+	// files holds the elements of lru by task name, lru holds the open files from the most recently written
+	files map[string]*list.Element
+	lru   *list.List
+}
+
+type taskFile struct {
+	name string
+	f    *os.File
+}
+
+func (h *taskHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *taskHook) Fire(e *logrus.Entry) error {
+	name, ok := e.Data[task.FieldTask].(string)
+	if !ok {
+		return nil
+	}
+	bs, err := h.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	f, err := h.open(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bs)
+	return err
+}
+
+// open returns the open file of the task, closing the least recently written file beyond maxOpenFiles
+func (h *taskHook) open(name string) (*os.File, error) {
+	if e, ok := h.files[name]; ok {
+		h.lru.MoveToFront(e)
+		return e.Value.(*taskFile).f, nil
+	}
+	f, err := os.OpenFile(filepath.Join(h.dir, fileName(name)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	h.files[name] = h.lru.PushFront(&taskFile{name: name, f: f})
+	if h.lru.Len() > maxOpenFiles {
+		h.remove(h.lru.Back())
+	}
+	return f, nil
+}
+
+func (h *taskHook) remove(e *list.Element) {
+	tf := h.lru.Remove(e).(*taskFile)
+	delete(h.files, tf.name)
+	_ = tf.f.Close()
+}
+
+func (h *taskHook) close(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if e, ok := h.files[name]; ok {
+		h.remove(e)
+	}
+}
+
+func (h *taskHook) closeAll() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for h.lru.Len() > 0 {
+		h.remove(h.lru.Back())
+	}
+}
+
+// fileName returns the log file name of a task like nginx:latest -> test/nginx:latest
+func fileName(name string) string {
+	safe := unsafeChars.ReplaceAllString(name, "_")
+	if len(safe) > maxFileName {
+		sum := sha256.Sum256([]byte(name))
+		safe = safe[:maxFileName] + "-" + hex.EncodeToString(sum[:4])
+	}
+	return safe + ".log"
 }
@@ -1 +1,129 @@
 package log
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	out := new(bytes.Buffer)
+	logrus.SetOutput(out)
+	defer func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+		tasks.Store(nil)
+		_, _ = Setup(FormatText, "")
+	}()
+
+	if _, err := Setup("xml", ""); err == nil {
+		t.Errorf("unsupported format accepted")
+	}
+
+	dir := filepath.Join(t.TempDir(), "tasks")
+	closeLog, err := Setup(FormatJson, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeLog()
+	name := "nginx:latest -> test/nginx:latest"
+	logrus.WithFields(logrus.Fields{task.FieldDomain: "image", task.FieldTask: name, task.FieldAttempt: 2}).
+		WithField(task.FieldDigest, "sha256:1234").Infof("trans blob: sha256:1234")
+	logrus.Infof("image sync finished")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected logs:\n%s", out)
+	}
+	entry := make(map[string]any)
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["task"] != name || entry["domain"] != "image" || entry["digest"] != "sha256:1234" || entry["attempt"] != float64(2) || entry["msg"] != "trans blob: sha256:1234" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+	if _, ok := entry["func"]; ok {
+		t.Errorf("entry contains the caller: %v", entry)
+	}
+
+	// 只有任务内的日志写入任务的日志文件
+	content, err := os.ReadFile(filepath.Join(dir, "nginx_latest_-_test_nginx_latest.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(content)) != lines[0] {
+		t.Errorf("unexpected task log:\n%s", content)
+	}
+}
+
+func TestTaskHook(t *testing.T) {
+	dir := t.TempDir()
+	h := &taskHook{dir: dir, formatter: newTextFormatter(true), files: make(map[string]*list.Element), lru: list.New()}
+	tasks.Store(h)
+	defer tasks.Store(nil)
+	fire := func(name string) {
+		entry := logrus.WithField(task.FieldTask, name)
+		entry.Message = "trans blob"
+		if err := h.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 超过上限时关闭最久未写入的文件，再次写入时重新打开并追加
+	for i := 0; i <= maxOpenFiles; i++ {
+		fire(fmt.Sprintf("task-%d", i))
+	}
+	if len(h.files) != maxOpenFiles || h.lru.Len() != maxOpenFiles {
+		t.Fatalf("%d files open, want %d", len(h.files), maxOpenFiles)
+	}
+	if _, ok := h.files["task-0"]; ok {
+		t.Errorf("least recently written file is open")
+	}
+	fire("task-0")
+	content, err := os.ReadFile(filepath.Join(dir, "task-0.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "trans blob"); n != 2 {
+		t.Errorf("%d entries in the reopened file, want 2:\n%s", n, content)
+	}
+
+	CloseTask("task-0")
+	if _, ok := h.files["task-0"]; ok || len(h.files) != maxOpenFiles-1 {
+		t.Errorf("file of the finished task is open")
+	}
+	h.closeAll()
+	if len(h.files) != 0 || h.lru.Len() != 0 {
+		t.Errorf("%d files open after close", len(h.files))
+	}
+}
+
+func TestTextFormatter(t *testing.T) {
+	entry := logrus.WithFields(logrus.Fields{task.FieldTask: "nginx:latest -> test/nginx:latest", "run": "1"})
+	entry.Message = "start sync task"
+	entry.Level = logrus.InfoLevel
+	bs, err := newTextFormatter(true).Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(bs); strings.Contains(got, "nginx") || !strings.Contains(got, "[run:1]") || strings.Contains(got, "\x1b[") {
+		t.Errorf("unexpected text entry: %q", got)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	if got := fileName("git@github.com:MR5356/syncer.git -> git@test.com:MR5356/syncer.git"); got != "git_github.com_MR5356_syncer.git_-_git_test.com_MR5356_syncer.git.log" {
+		t.Errorf("fileName() = %s", got)
+	}
+	long := fileName(strings.Repeat("a", 200))
+	if len(long) != maxFileName+len("-12345678.log") || long == fileName(strings.Repeat("a", 201)) {
+		t.Errorf("fileName() of long name = %s", long)
+	}
+}
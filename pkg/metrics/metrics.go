@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const (
+	namespace = "syncer"
+
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// registry holds the metrics of syncer, the textfile output only contains them
+	registry = prometheus.NewRegistry()
+	// runtime holds the go runtime and process metrics, which are only served by Handler
+	runtime = prometheus.NewRegistry()
+
+	TasksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_total",
+		Help:      "Number of finished sync tasks by domain and status.",
+	}, []string{"domain", "status"})
+
+	TaskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Duration of the sync tasks including the retries.",
+		// 1 秒到 4.5 小时
+		Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+	}, []string{"domain", "status"})
+
+	TaskRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_retries_total",
+		Help:      "Number of retries of the sync tasks.",
+	}, []string{"domain"})
+
+	LastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful sync of each mapping of the config, labeled by the source of the mapping.",
+	}, []string{"domain", "mapping"})
+
+	BytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_bytes_transferred_total",
+		Help:      "Bytes of the image blobs copied from the source registry to the destination registry.",
+	}, []string{"source_registry", "destination_registry"})
+
+	BlobsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_blobs_skipped_total",
+		Help:      "Number of image blobs skipped since they already exist in the destination registry.",
+	}, []string{"destination_registry"})
+
+	GitPushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_pushes_total",
+		Help:      "Number of git pushes by destination host and status.",
+	}, []string{"host", "status"})
+
+	GitPushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_push_duration_seconds",
+		Help:      "Duration of the git pushes by destination host.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+	}, []string{"host"})
+)
+
+func init() {
+	registry.MustRegister(TasksTotal, TaskDuration, TaskRetries, LastSuccess, BytesTransferred, BlobsSkipped, GitPushes, GitPushDuration)
+	runtime.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+func status(err error) string {
+	if err != nil {
+		return StatusFailed
+	}
+	return StatusSucceeded
+}
+
+// ObserveTask records a finished task of domain started at start
+func ObserveTask(domain string, start time.Time, err error) {
+	s := status(err)
+	TasksTotal.WithLabelValues(domain, s).Inc()
+	TaskDuration.WithLabelValues(domain, s).Observe(time.Since(start).Seconds())
+}
+
+// ObserveMapping records a finished run of the mapping of the config whose source is mapping, only the mappings
+// of the config are recorded so that the ad-hoc syncs do not add series
+func ObserveMapping(domain, mapping string, err error) {
+	if err == nil {
+		LastSuccess.WithLabelValues(domain, configutil.RedactUrl(mapping)).SetToCurrentTime()
+	}
+}
+
+// ObservePush records a git push to host started at start
+func ObservePush(host string, start time.Time, err error) {
+	GitPushes.WithLabelValues(host, status(err)).Inc()
+	GitPushDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics of syncer with the go runtime and process metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.Gatherers{registry, runtime}, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics to file for the textfile collector of node exporter, the file is replaced
+// atomically so that the collector never reads a partial file
+func WriteTextfile(file string) error {
+	return prometheus.WriteToTextfile(file, registry)
+}
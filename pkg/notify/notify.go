@@ -0,0 +1,383 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	TypeJson     = "json"
+	TypeSlack    = "slack"
+	TypeDingtalk = "dingtalk"
+	TypeFeishu   = "feishu"
+	TypeWecom    = "wecom"
+
+	OnAlways  = "always"
+	OnFailure = "failure"
+	OnChange  = "change"
+
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+
+	// stateFile keeps the failed tasks of the last run of each summary name for the change trigger
+	stateFile = "notify.json"
+	// maxFailures limits the failed tasks listed in the messages
+	maxFailures = 10
+	timeout     = 10 * time.Second
+)
+
+// lock guards the state file, the mappings of the daemon finish concurrently
+var lock sync.Mutex
+
+// Notification posts the summary of a run to a webhook
+type Notification struct {
+	// URL is the address of the webhook, the incoming webhooks of slack and the robots carry their token in the url
+	URL configutil.Secret `json:"url" yaml:"url"`
+	// Type is the body format: json (default) posts the summary, slack, dingtalk, feishu and wecom post a text message
+	Type string `json:"type" yaml:"type"`
+	// On is the trigger: always (default), failure when any task failed, or change when the failed tasks differ
+	// from the last run
+	On string `json:"on" yaml:"on"`
+	// Template overrides the body with a go template executed with the summary, e.g. {"text": {{json .Text}}}
+	Template string `json:"template" yaml:"template"`
+	// Secret signs the messages of the dingtalk and feishu robots with signature verification enabled
+	Secret configutil.Secret `json:"secret" yaml:"secret"`
+	// Headers are added to the request, e.g. Authorization of a generic endpoint
+	Headers map[string]configutil.Secret `json:"headers" yaml:"headers"`
+}
+
+// Summary is the result of a run posted to the webhooks
+type Summary struct {
+	Domain string `json:"domain"`
+	// Name is the run, the config file of syncer image and syncer git or the mapping of the daemon
+	Name string `json:"name"`
+	// Run and Trigger are set for the runs of the daemon
+	Run     string `json:"run,omitempty"`
+	Trigger string `json:"trigger,omitempty"`
+	Status  string `json:"status"`
+	// Error is the error of the run itself, like failing to generate the tasks
+	Error     string     `json:"error,omitempty"`
+	Total     int        `json:"total"`
+	Succeeded int        `json:"succeeded"`
+	Failed    int        `json:"failed"`
+	Failures  []*Failure `json:"failures"`
+	Start     time.Time  `json:"start"`
+	End       time.Time  `json:"end"`
+	Duration  string     `json:"duration"`
+	// Changed is set when the failed tasks differ from the last run, only tracked with the change trigger
+	Changed bool `json:"changed"`
+}
+
+type Failure struct {
+	Task  string `json:"task"`
+	Error string `json:"error"`
+}
+
+// Result is the part of the image and git clients a summary is made of
+type Result interface {
+	Tasks() *task.List
+	Failed() *task.List
+	Err(t task.Task) error
+}
+
+// NewSummary returns the summary of the run of r started at start, err is the error returned by the run
+func NewSummary(domain, name string, r Result, start time.Time, err error) *Summary {
+	s := &Summary{Domain: domain, Name: name, Start: start, End: time.Now(), Failures: make([]*Failure, 0)}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if r.Tasks() != nil {
+		s.Total = r.Tasks().Length()
+	}
+	for t := range r.Failed().Iterator() {
+		f := &Failure{Task: t.Name()}
+		if err := r.Err(t); err != nil {
+			f.Error = err.Error()
+		}
+		s.Failures = append(s.Failures, f)
+	}
+	s.Complete()
+	return s
+}
+
+// Complete sets the counts, status and duration from the failures, the failures are sorted by task
+func (s *Summary) Complete() {
+	sort.Slice(s.Failures, func(i, j int) bool {
+		return s.Failures[i].Task < s.Failures[j].Task
+	})
+	s.Failed = len(s.Failures)
+	s.Succeeded = s.Total - s.Failed
+	s.Status = StatusSucceeded
+	if s.Failed > 0 || s.Error != "" {
+		s.Status = StatusFailed
+	}
+	s.Duration = s.End.Sub(s.Start).Round(time.Millisecond).String()
+}
+
+// Text is the message of the summary posted to slack and the robots
+func (s *Summary) Text() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "syncer %s %s %s: %d/%d succeeded, %d failed, cost %s", s.Domain, s.Name, s.Status, s.Succeeded, s.Total, s.Failed, s.Duration)
+	if s.Error != "" {
+		fmt.Fprintf(b, "\nerror: %s", s.Error)
+	}
+	for i, f := range s.Failures {
+		if i == maxFailures {
+			fmt.Fprintf(b, "\n... and %d more", len(s.Failures)-maxFailures)
+			break
+		}
+		fmt.Fprintf(b, "\n- %s: %s", f.Task, f.Error)
+	}
+	return b.String()
+}
+
+// Validate checks the type, trigger and template of the notifications
+func Validate(ns []*Notification) error {
+	for i, n := range ns {
+		if n == nil || n.URL == "" {
+			return fmt.Errorf("notification %d: url can not be empty", i)
+		}
+		switch n.Type {
+		case "", TypeJson, TypeSlack, TypeDingtalk, TypeFeishu, TypeWecom:
+		default:
+			return fmt.Errorf("notification %d: unsupported type %s, should be one of %s, %s, %s, %s and %s", i, n.Type, TypeJson, TypeSlack, TypeDingtalk, TypeFeishu, TypeWecom)
+		}
+		switch n.On {
+		case "", OnAlways, OnFailure, OnChange:
+		default:
+			return fmt.Errorf("notification %d: unsupported trigger %s, should be %s, %s or %s", i, n.On, OnAlways, OnFailure, OnChange)
+		}
+		if _, err := n.template(); err != nil {
+			return fmt.Errorf("notification %d: %s", i, err)
+		}
+	}
+	return nil
+}
+
+// Send posts s to the notifications it triggers, stateDir keeps the failed tasks of the last run for the change
+// trigger. Failed posts are logged and do not fail the run
+func Send(ns []*Notification, s *Summary, stateDir string) {
+	if len(ns) == 0 {
+		return
+	}
+	for _, n := range ns {
+		if n.On == OnChange {
+			changed, err := updateState(filepath.Join(stateDir, stateFile), s)
+			if err != nil {
+				logrus.Warnf("update notification state failed: %s", err)
+			}
+			s.Changed = changed
+			break
+		}
+	}
+
+	for _, n := range ns {
+		if !n.triggered(s) {
+			continue
+		}
+		if err := n.post(s); err != nil {
+			logrus.Warnf("send notification of %s to %s failed: %s", s.Name, n.host(), err)
+		} else {
+			logrus.Infof("notification of %s sent", s.Name)
+		}
+	}
+}
+
+// updateState records the failed tasks of s and reports whether they differ from the last run, the first run is
+// compared with a run without failures
+func updateState(file string, s *Summary) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	failed := make([]string, 0, len(s.Failures))
+	for _, f := range s.Failures {
+		failed = append(failed, f.Task)
+	}
+	if s.Error != "" {
+		failed = append(failed, "error: "+s.Error)
+	}
+	key := s.Domain + " " + s.Name
+
+	state := make(map[string][]string)
+	if bs, err := os.ReadFile(file); err == nil {
+		if err := json.Unmarshal(bs, &state); err != nil {
+			return len(failed) > 0, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return len(failed) > 0, err
+	}
+	changed := strings.Join(state[key], "\n") != strings.Join(failed, "\n")
+
+	state[key] = failed
+	bs, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return changed, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return changed, err
+	}
+	return changed, os.WriteFile(file, bs, 0644)
+}
+
+// host is the host of the url for the logs, the path and query of the webhooks usually contain the token
+func (n *Notification) host() string {
+	if u, err := url.Parse(n.URL.Value()); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return n.URL.String()
+}
+
+func (n *Notification) triggered(s *Summary) bool {
+	switch n.On {
+	case OnFailure:
+		return s.Status == StatusFailed
+	case OnChange:
+		return s.Changed
+	}
+	return true
+}
+
+func (n *Notification) template() (*template.Template, error) {
+	if n.Template == "" {
+		return nil, nil
+	}
+	return template.New("notification").Funcs(template.FuncMap{
+		// json 转义字符串，用于在模板中拼接 json
+		"json": func(v any) (string, error) {
+			bs, err := json.Marshal(v)
+			return string(bs), err
+		},
+	}).Parse(n.Template)
+}
+
+// url returns the url to post to, the dingtalk signature is added to the query
+func (n *Notification) url() (string, error) {
+	if n.Type != TypeDingtalk || n.Secret == "" {
+		return n.URL.Value(), nil
+	}
+	u, err := url.Parse(n.URL.Value())
+	if err != nil {
+		// 地址中包含 token，不返回原始错误
+		return "", errors.New("invalid url")
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", hmacSign(n.Secret.Value(), timestamp+"\n"+n.Secret.Value()))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// body returns the request body of s, the template or the message of the type
+func (n *Notification) body(s *Summary) ([]byte, error) {
+	tpl, err := n.template()
+	if err != nil {
+		return nil, err
+	}
+	if tpl != nil {
+		b := new(bytes.Buffer)
+		if err := tpl.Execute(b, s); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	var body any
+	switch n.Type {
+	case TypeSlack:
+		body = map[string]any{"text": s.Text()}
+	case TypeDingtalk, TypeWecom:
+		body = map[string]any{"msgtype": "text", "text": map[string]string{"content": s.Text()}}
+	case TypeFeishu:
+		msg := map[string]any{"msg_type": "text", "content": map[string]string{"text": s.Text()}}
+		if n.Secret != "" {
+			// 飞书以时间戳与密钥作为 hmac 的密钥，签名空字符串
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			msg["timestamp"] = timestamp
+			msg["sign"] = hmacSign(timestamp+"\n"+n.Secret.Value(), "")
+		}
+		body = msg
+	default:
+		body = s
+	}
+	return json.Marshal(body)
+}
+
+func hmacSign(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notification) post(s *Summary) error {
+	u, err := n.url()
+	if err != nil {
+		return err
+	}
+	body, err := n.body(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v.Value())
+	}
+
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(bs)))
+	}
+
+	// 机器人在 http 状态码为 200 时通过 errcode 或 code 返回错误
+	switch n.Type {
+	case TypeDingtalk, TypeFeishu, TypeWecom:
+		res := struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+			Code    int    `json:"code"`
+			Msg     string `json:"msg"`
+		}{}
+		if err := json.Unmarshal(bs, &res); err != nil {
+			return fmt.Errorf("unexpected response: %s", strings.TrimSpace(string(bs)))
+		}
+		if res.ErrCode != 0 {
+			return fmt.Errorf("error %d: %s", res.ErrCode, res.ErrMsg)
+		}
+		if res.Code != 0 {
+			return fmt.Errorf("error %d: %s", res.Code, res.Msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/MR5356/syncer/pkg/utils/configutil"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSummary(failures ...string) *Summary {
+	s := &Summary{Domain: "image", Name: "config.yaml", Total: 3, Start: time.Now().Add(-time.Minute), End: time.Now(), Failures: make([]*Failure, 0)}
+	for _, f := range failures {
+		s.Failures = append(s.Failures, &Failure{Task: f, Error: "unauthorized"})
+	}
+	s.Complete()
+	return s
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       *Notification
+		wantErr bool
+	}{
+		{name: "test json", n: &Notification{URL: "http://example.com/hook"}},
+		{name: "test dingtalk on change", n: &Notification{URL: "http://example.com/hook", Type: TypeDingtalk, On: OnChange}},
+		{name: "test template", n: &Notification{URL: "http://example.com/hook", Template: `{"text": {{json .Text}}}`}},
+		{name: "test empty url", n: &Notification{}, wantErr: true},
+		{name: "test invalid type", n: &Notification{URL: "http://example.com/hook", Type: "telegram"}, wantErr: true},
+		{name: "test invalid trigger", n: &Notification{URL: "http://example.com/hook", On: "success"}, wantErr: true},
+		{name: "test invalid template", n: &Notification{URL: "http://example.com/hook", Template: "{{.Text"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate([]*Notification{tt.n}); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNotification_Post(t *testing.T) {
+	var request string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := io.ReadAll(r.Body)
+		request = r.URL.String() + " " + string(bs)
+		switch r.URL.Path {
+		case "/robot/invalid":
+			_, _ = w.Write([]byte(`{"errcode":310000,"errmsg":"keywords not in content"}`))
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	s := newSummary("nginx:latest -> test/nginx:latest")
+	tests := []struct {
+		name    string
+		path    string
+		n       *Notification
+		want    []string
+		wantErr bool
+	}{
+		{name: "test json", path: "/hook", n: &Notification{}, want: []string{`"status":"failed"`, `"succeeded":2`, `"task":"nginx:latest -\u003e test/nginx:latest"`}},
+		{name: "test slack", path: "/hook", n: &Notification{Type: TypeSlack}, want: []string{`{"text":"syncer image config.yaml failed: 2/3 succeeded, 1 failed, cost 1m0s\n- nginx:latest`}},
+		{name: "test dingtalk", path: "/robot/send?access_token=token", n: &Notification{Type: TypeDingtalk, Secret: "secret"}, want: []string{`"msgtype":"text"`, "access_token=token", "sign=", "timestamp="}},
+		{name: "test feishu", path: "/hook", n: &Notification{Type: TypeFeishu, Secret: "secret"}, want: []string{`"msg_type":"text"`, `"sign":"`}},
+		{name: "test template", path: "/hook", n: &Notification{Type: TypeWecom, Template: `{"markdown": {"content": {{json .Text}}}, "failed": {{.Failed}}}`}, want: []string{`{"markdown": {"content": "syncer image`, `"failed": 1}`}},
+		{name: "test robot error", path: "/robot/invalid", n: &Notification{Type: TypeWecom}, wantErr: true},
+		{name: "test http error", path: "/error", n: &Notification{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.n.URL = configutil.Secret(server.URL + tt.path)
+			err := tt.n.post(s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(request, want) {
+					t.Errorf("request does not contain %s:\n%s", want, request)
+				}
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	lock := sync.Mutex{}
+	received := make([]*Summary, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := new(Summary)
+		_ = json.NewDecoder(r.Body).Decode(s)
+		lock.Lock()
+		received = append(received, s)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	ns := []*Notification{
+		{URL: configutil.Secret(server.URL + "/failure"), On: OnFailure},
+		{URL: configutil.Secret(server.URL + "/change"), On: OnChange},
+	}
+	// 第一次成功不算变化，失败后发送两次，相同的失败只发送 failure，恢复后只发送 change
+	runs := [][]string{nil, {"a"}, {"a"}, {"a", "b"}, nil}
+	want := []int{0, 2, 1, 2, 1}
+	for i, failures := range runs {
+		lock.Lock()
+		received = received[:0]
+		lock.Unlock()
+		Send(ns, newSummary(failures...), dir)
+		if len(received) != want[i] {
+			t.Errorf("run %d: %d notifications sent, want %d", i, len(received), want[i])
+		}
+	}
+	if !received[0].Changed || received[0].Status != StatusSucceeded {
+		t.Errorf("unexpected summary: %+v", received[0])
+	}
+}
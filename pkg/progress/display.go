@@ -0,0 +1,205 @@
+package progress
+
+import (
+	"fmt"
+	"github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBlobs limits the blob lines of the view, the others are counted in the last line
+	maxBlobs = 10
+	// rateWeight is the weight of the last interval in the smoothed throughput
+	rateWeight = 0.3
+)
+
+// Display renders the progress of a tracker on a terminal every interval, the lines written to it are printed
+// above the view
+type Display struct {
+	w       io.Writer
+	t       *Tracker
+	width   int
+	verbose bool
+
+	lock sync.Mutex
+	// lines is the number of lines of the view on the terminal
+	lines     int
+	live      bool
+	rate      float64
+	lastBytes int64
+	lastTime  time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDisplay returns a display of t on w, the lines of the view are cut to width if it is positive
+func NewDisplay(w io.Writer, t *Tracker, width int) *Display {
+	return &Display{w: w, t: t, width: width, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Start renders the view every interval until Stop
+func (d *Display) Start(interval time.Duration) {
+	d.lock.Lock()
+	d.live = true
+	d.lastTime = time.Now()
+	d.lock.Unlock()
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.lock.Lock()
+				d.redraw()
+				d.lock.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop renders the view a last time and leaves it on the terminal, the lines written afterward are printed as is
+func (d *Display) Stop() {
+	close(d.stop)
+	<-d.done
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.redraw()
+	d.live = false
+	d.lines = 0
+}
+
+// Write prints p above the view
+func (d *Display) Write(p []byte) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.live {
+		return d.w.Write(p)
+	}
+	d.clear()
+	n, err := d.w.Write(p)
+	d.draw()
+	return n, err
+}
+
+// Live reports whether the view is shown
+func (d *Display) Live() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.live
+}
+
+// redraw updates the throughput and replaces the view on the terminal
+func (d *Display) redraw() {
+	s := d.t.Snapshot()
+	now := time.Now()
+	if dt := now.Sub(d.lastTime).Seconds(); dt > 0 {
+		rate := float64(s.Bytes-d.lastBytes) / dt
+		if d.lastBytes == 0 && d.rate == 0 {
+			d.rate = rate
+		} else {
+			d.rate = rateWeight*rate + (1-rateWeight)*d.rate
+		}
+	}
+	d.lastBytes, d.lastTime = s.Bytes, now
+	d.clear()
+	d.drawSnapshot(s)
+}
+
+func (d *Display) draw() {
+	d.drawSnapshot(d.t.Snapshot())
+}
+
+func (d *Display) drawSnapshot(s *Snapshot) {
+	lines := render(s, d.rate, d.width)
+	_, _ = io.WriteString(d.w, strings.Join(lines, "\n")+"\n")
+	d.lines = len(lines)
+}
+
+// clear moves the cursor to the first line of the view and erases the view
+func (d *Display) clear() {
+	if d.lines > 0 {
+		_, _ = fmt.Fprintf(d.w, "\x1b[%dA\x1b[J", d.lines)
+		d.lines = 0
+	}
+}
+
+// render returns the lines of the view: the tasks done, the throughput and the ETA, then a line per active blob
+func render(s *Snapshot, rate float64, width int) []string {
+	eta := "-"
+	if d := s.ETA(rate); d > 0 {
+		eta = d.Round(time.Second).String()
+	}
+	lines := []string{fmt.Sprintf("[%d/%d tasks] %s copied, %s/s, elapsed %s, eta %s",
+		s.Done, s.Total, units.BytesSize(float64(s.Bytes)), units.BytesSize(rate), s.Elapsed.Round(time.Second), eta)}
+	for i, b := range s.Blobs {
+		if i == maxBlobs {
+			lines = append(lines, fmt.Sprintf("  ... %d more blobs", len(s.Blobs)-maxBlobs))
+			break
+		}
+		lines = append(lines, "  "+renderBlob(b))
+	}
+	if width > 0 {
+		for i, line := range lines {
+			lines[i] = cut(line, width)
+		}
+	}
+	return lines
+}
+
+func renderBlob(b BlobSnapshot) string {
+	digest := b.Digest
+	// sha256: 后只保留 12 位
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		digest = digest[i+1 : i+13]
+	}
+	if b.Size <= 0 {
+		return fmt.Sprintf("%s %s %s", digest, units.BytesSize(float64(b.Copied)), b.Task)
+	}
+	return fmt.Sprintf("%s %s/%s %3d%% %s", digest, units.BytesSize(float64(b.Copied)), units.BytesSize(float64(b.Size)),
+		b.Copied*100/b.Size, b.Task)
+}
+
+// cut cuts line to width runes, a line as wide as the terminal would wrap and break the clearing of the view
+func cut(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) < width {
+		return line
+	}
+	return string(runes[:width-1])
+}
+
+// LogHook prints the log entries above the view of a display. While the view is shown only the warnings and the
+// errors are printed unless verbose, the output of the logger should be discarded
+type LogHook struct {
+	d       *Display
+	verbose bool
+}
+
+func NewLogHook(d *Display, verbose bool) *LogHook {
+	return &LogHook{d: d, verbose: verbose}
+}
+
+func (h *LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *LogHook) Fire(e *logrus.Entry) error {
+	if !h.verbose && e.Level > logrus.WarnLevel && h.d.Live() {
+		return nil
+	}
+	bs, err := e.Logger.Formatter.Format(e)
+	if err != nil {
+		return err
+	}
+	_, err = h.d.Write(bs)
+	return err
+}
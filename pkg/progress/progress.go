@@ -0,0 +1,171 @@
+package progress
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracker collects the progress of a run: the tasks done and the bytes of the blobs being copied, a nil tracker
+// ignores the updates
+type Tracker struct {
+	start time.Time
+
+	total, done atomic.Int64
+	// bytes is the number of bytes copied by all the blobs, including the finished ones
+	bytes atomic.Int64
+
+	lock  sync.Mutex
+	blobs map[*Blob]struct{}
+}
+
+// Blob is a blob being copied, a nil blob ignores the updates
+type Blob struct {
+	t      *Tracker
+	task   string
+	digest string
+	// size is the total bytes of the blob, -1 when unknown
+	size   int64
+	start  time.Time
+	copied atomic.Int64
+}
+
+// Snapshot is the progress of a tracker at a time
+type Snapshot struct {
+	Elapsed     time.Duration
+	Total, Done int64
+	Bytes       int64
+	Blobs       []BlobSnapshot
+}
+
+type BlobSnapshot struct {
+	Task, Digest string
+	Size, Copied int64
+}
+
+func New() *Tracker {
+	return &Tracker{start: time.Now(), blobs: make(map[*Blob]struct{})}
+}
+
+// AddTasks adds n tasks to the total
+func (t *Tracker) AddTasks(n int) {
+	if t == nil {
+		return
+	}
+	t.total.Add(int64(n))
+}
+
+// TaskDone marks a task as finished, succeeded or failed
+func (t *Tracker) TaskDone() {
+	if t == nil {
+		return
+	}
+	t.done.Add(1)
+}
+
+// StartBlob starts tracking the copy of a blob of task
+func (t *Tracker) StartBlob(task, digest string, size int64) *Blob {
+	if t == nil {
+		return nil
+	}
+	b := &Blob{t: t, task: task, digest: digest, size: size, start: time.Now()}
+	t.lock.Lock()
+	t.blobs[b] = struct{}{}
+	t.lock.Unlock()
+	return b
+}
+
+// Add adds n copied bytes
+func (b *Blob) Add(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.copied.Add(int64(n))
+	b.t.bytes.Add(int64(n))
+}
+
+// Done stops tracking the blob, whether the copy succeeded or not
+func (b *Blob) Done() {
+	if b == nil {
+		return
+	}
+	b.t.lock.Lock()
+	delete(b.t.blobs, b)
+	b.t.lock.Unlock()
+}
+
+// Snapshot returns the current progress, the blobs are in the order they started
+func (t *Tracker) Snapshot() *Snapshot {
+	s := &Snapshot{Elapsed: time.Since(t.start), Total: t.total.Load(), Done: t.done.Load(), Bytes: t.bytes.Load()}
+	t.lock.Lock()
+	blobs := make([]*Blob, 0, len(t.blobs))
+	for b := range t.blobs {
+		blobs = append(blobs, b)
+	}
+	t.lock.Unlock()
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].start.Before(blobs[j].start)
+	})
+	for _, b := range blobs {
+		s.Blobs = append(s.Blobs, BlobSnapshot{Task: b.task, Digest: b.digest, Size: b.size, Copied: b.copied.Load()})
+	}
+	return s
+}
+
+// ETA estimates the remaining time from the average time of the finished tasks, before any task finishes it is
+// the time to copy the remaining bytes of the active blobs at rate bytes per second. It is 0 when unknown
+func (s *Snapshot) ETA(rate float64) time.Duration {
+	if s.Done > 0 {
+		return time.Duration(float64(s.Elapsed) / float64(s.Done) * float64(s.Total-s.Done))
+	}
+	var remaining int64
+	for _, b := range s.Blobs {
+		if b.Size < 0 {
+			return 0
+		}
+		remaining += b.Size - b.Copied
+	}
+	if rate <= 0 || remaining <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second))
+}
+
+type scopeKey struct{}
+
+// scope is the tracker and the task carried by a context
+type scope struct {
+	t    *Tracker
+	task string
+}
+
+// WithTracker returns a copy of ctx carrying the tracker updated by the tasks run with it
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{t: t})
+}
+
+// FromContext returns the tracker carried by ctx, nil if none
+func FromContext(ctx context.Context) *Tracker {
+	if s, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		return s.t
+	}
+	return nil
+}
+
+// WithTask returns a copy of ctx whose blobs belong to the task named name
+func WithTask(ctx context.Context, name string) context.Context {
+	if s, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		return context.WithValue(ctx, scopeKey{}, &scope{t: s.t, task: name})
+	}
+	return ctx
+}
+
+// StartBlob starts tracking a blob of the task of ctx, it returns nil when ctx carries no tracker
+func StartBlob(ctx context.Context, digest string, size int64) *Blob {
+	if s, ok := ctx.Value(scopeKey{}).(*scope); ok {
+		return s.t.StartBlob(s.task, digest, size)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	// 没有 tracker 时忽略更新
+	ctx := context.Background()
+	FromContext(ctx).AddTasks(1)
+	b := StartBlob(WithTask(ctx, "a"), "sha256:1234", 10)
+	b.Add(10)
+	b.Done()
+
+	tracker := New()
+	ctx = WithTracker(ctx, tracker)
+	FromContext(ctx).AddTasks(2)
+	first := StartBlob(WithTask(ctx, "a"), "sha256:1234", 100)
+	second := StartBlob(WithTask(ctx, "b"), "sha256:5678", -1)
+	first.Add(40)
+	second.Add(10)
+	s := tracker.Snapshot()
+	if s.Total != 2 || s.Done != 0 || s.Bytes != 50 || len(s.Blobs) != 2 {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+	if s.Blobs[0] != (BlobSnapshot{Task: "a", Digest: "sha256:1234", Size: 100, Copied: 40}) || s.Blobs[1].Task != "b" {
+		t.Errorf("unexpected blobs: %+v", s.Blobs)
+	}
+
+	second.Done()
+	tracker.TaskDone()
+	s = tracker.Snapshot()
+	if s.Done != 1 || s.Bytes != 50 || len(s.Blobs) != 1 {
+		t.Errorf("unexpected snapshot: %+v", s)
+	}
+}
+
+func TestSnapshot_ETA(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *Snapshot
+		rate float64
+		want time.Duration
+	}{
+		{name: "test by tasks", s: &Snapshot{Elapsed: time.Minute, Total: 4, Done: 1}, want: 3 * time.Minute},
+		{name: "test by blobs", s: &Snapshot{Total: 1, Blobs: []BlobSnapshot{{Size: 100, Copied: 40}, {Size: 40}}}, rate: 10, want: 10 * time.Second},
+		{name: "test unknown size", s: &Snapshot{Total: 1, Blobs: []BlobSnapshot{{Size: -1}}}, rate: 10},
+		{name: "test no rate", s: &Snapshot{Total: 1, Blobs: []BlobSnapshot{{Size: 100}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.ETA(tt.rate); got != tt.want {
+				t.Errorf("ETA() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := &Snapshot{Elapsed: time.Minute, Total: 4, Done: 1, Bytes: 3 << 20}
+	for i := 0; i < maxBlobs+2; i++ {
+		s.Blobs = append(s.Blobs, BlobSnapshot{Task: "nginx:latest -> test/nginx:latest", Digest: "sha256:8a1f3c2e9b7d4f6a0c5e", Size: 4 << 20, Copied: 1 << 20})
+	}
+	lines := render(s, 1<<20, 0)
+	if len(lines) != maxBlobs+2 {
+		t.Fatalf("unexpected lines:\n%s", strings.Join(lines, "\n"))
+	}
+	if lines[0] != "[1/4 tasks] 3MiB copied, 1MiB/s, elapsed 1m0s, eta 3m0s" {
+		t.Errorf("unexpected header: %s", lines[0])
+	}
+	if lines[1] != "  8a1f3c2e9b7d 1MiB/4MiB  25% nginx:latest -> test/nginx:latest" {
+		t.Errorf("unexpected blob: %s", lines[1])
+	}
+	if lines[maxBlobs+1] != "  ... 2 more blobs" {
+		t.Errorf("unexpected last line: %s", lines[maxBlobs+1])
+	}
+	for _, line := range render(s, 0, 20) {
+		if len(line) >= 20 {
+			t.Errorf("line not cut: %s", line)
+		}
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	out := new(bytes.Buffer)
+	tracker := New()
+	tracker.AddTasks(1)
+	d := NewDisplay(out, tracker, 0)
+	d.Start(time.Hour)
+
+	logger := logrus.New()
+	logger.SetOutput(new(bytes.Buffer))
+	logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	logger.AddHook(NewLogHook(d, false))
+	logger.Infof("trans blob")
+	logger.Warnf("retry")
+	d.Stop()
+	logger.Infof("finished")
+
+	// 显示期间只输出警告，每条日志前清除进度并在之后重绘
+	want := "level=warning msg=retry\n" +
+		"[0/1 tasks] 0B copied, 0B/s, elapsed 0s, eta -\n" +
+		"\x1b[1A\x1b[J[0/1 tasks] 0B copied, 0B/s, elapsed 0s, eta -\n" +
+		"level=info msg=finished\n"
+	if got := out.String(); got != want {
+		t.Errorf("unexpected output:\n%q\nwant\n%q", got, want)
+	}
+}
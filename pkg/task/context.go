@@ -0,0 +1,55 @@
+package task
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+)
+
+// ContextTask is a task which stops when its context is canceled and logs through the logger of its context
+type ContextTask interface {
+	Task
+	RunContext(ctx context.Context) error
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying the logger of the tasks run with it
+func WithLogger(ctx context.Context, log *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// Logger returns the logger carried by ctx, falling back to the standard logger
+func Logger(ctx context.Context) *logrus.Entry {
+	if log, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return log
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// Run runs t with ctx if it supports it
+func Run(ctx context.Context, t Task) error {
+	if ct, ok := t.(ContextTask); ok {
+		return ct.RunContext(ctx)
+	}
+	return t.Run()
+}
+
+// the fields of the log entries emitted within a task
+const (
+	FieldDomain      = "domain"
+	FieldTask        = "task"
+	FieldSource      = "source"
+	FieldDestination = "destination"
+	FieldTag         = "tag"
+	FieldDigest      = "digest"
+	FieldAttempt     = "attempt"
+)
+
+// IsField reports whether key is one of the task fields
+func IsField(key string) bool {
+	switch key {
+	case FieldDomain, FieldTask, FieldSource, FieldDestination, FieldTag, FieldDigest, FieldAttempt:
+		return true
+	}
+	return false
+}
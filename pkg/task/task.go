@@ -1,12 +1,43 @@
 package task
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 type Task interface {
 	Name() string
 	Run() error
 }
 
+// Mapped is a task generated from a mapping of the config
+type Mapped interface {
+	// Mapping returns the source of the mapping in the config
+	Mapping() string
+}
+
+// SucceededMappings returns the sorted mappings of the tasks in all, except the mappings with a task in failed
+func SucceededMappings(all, failed *List) []string {
+	failedMappings := make(map[string]bool)
+	for t := range failed.Iterator() {
+		if m, ok := t.(Mapped); ok {
+			failedMappings[m.Mapping()] = true
+		}
+	}
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for t := range all.Iterator() {
+		m, ok := t.(Mapped)
+		if !ok || failedMappings[m.Mapping()] || seen[m.Mapping()] {
+			continue
+		}
+		seen[m.Mapping()] = true
+		res = append(res, m.Mapping())
+	}
+	sort.Strings(res)
+	return res
+}
+
 type List struct {
 	lock sync.RWMutex
 	list []Task
@@ -0,0 +1,36 @@
+package task
+
+import (
+	"reflect"
+	"testing"
+)
+
+type mappedTask struct {
+	name, mapping string
+}
+
+func (t *mappedTask) Name() string { return t.name }
+
+func (t *mappedTask) Run() error { return nil }
+
+func (t *mappedTask) Mapping() string { return t.mapping }
+
+func TestSucceededMappings(t *testing.T) {
+	all, failed := NewTaskList(), NewTaskList()
+	// forge 映射生成多个任务，其中一个失败则映射失败
+	for _, task := range []*mappedTask{
+		{name: "nginx:latest -> a", mapping: "nginx:latest"},
+		{name: "nginx:latest -> b", mapping: "nginx:latest"},
+		{name: "github:MR5356/syncer -> a", mapping: "github:MR5356/*"},
+		{name: "github:MR5356/aurora -> a", mapping: "github:MR5356/*"},
+		{name: "busybox -> a", mapping: "busybox"},
+	} {
+		all.Add(task)
+		if task.name == "github:MR5356/aurora -> a" {
+			failed.Add(task)
+		}
+	}
+	if got := SucceededMappings(all, failed); !reflect.DeepEqual(got, []string{"busybox", "nginx:latest"}) {
+		t.Errorf("SucceededMappings() = %v", got)
+	}
+}
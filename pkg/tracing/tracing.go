@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/MR5356/syncer/pkg/version"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"time"
+)
+
+const (
+	ExporterOtlp = "otlp"
+	ExporterFile = "file"
+
+	shutdownTimeout = 10 * time.Second
+)
+
+// tracer creates the spans of syncer, it is a no-op until Setup installs the tracer provider
+var tracer = otel.Tracer("github.com/MR5356/syncer")
+
+// Setup installs the global tracer provider exporting the spans to exporter, the otlp exporter is configured by the
+// OTEL_EXPORTER_OTLP_* environment variables, the file exporter appends a json object per span to file.
+// The returned shutdown flushes the pending spans
+func Setup(exporter, file string) (shutdown func(), err error) {
+	var exp sdktrace.SpanExporter
+	var out *os.File
+	switch exporter {
+	case "":
+		return func() {}, nil
+	case ExporterOtlp:
+		exp, err = otlptracehttp.New(context.Background())
+	case ExporterFile:
+		if file == "" {
+			return nil, fmt.Errorf("file of the %s exporter can not be empty", ExporterFile)
+		}
+		out, err = os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(out))
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %s, should be %s or %s", exporter, ExporterOtlp, ExporterFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("syncer"),
+		semconv.ServiceVersion(version.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	// 导出失败等错误记录到日志中
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.Warnf("export traces failed: %s", err)
+	}))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logrus.Warnf("flush traces failed: %s", err)
+		}
+		if out != nil {
+			_ = out.Close()
+		}
+	}, nil
+}
+
+// Start starts a span of ctx named name
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
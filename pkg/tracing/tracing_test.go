@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	if _, err := Setup("jaeger", ""); err == nil {
+		t.Errorf("unsupported exporter accepted")
+	}
+	if _, err := Setup(ExporterFile, ""); err == nil {
+		t.Errorf("file exporter without file accepted")
+	}
+
+	file := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(ExporterFile, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := Start(context.Background(), "image.SyncTask.Run", attribute.String("source", "nginx:latest"))
+	_, child := Start(ctx, "image.transBlob", attribute.String("digest", "sha256:1234"), attribute.Int64("size", 1024))
+	End(child, errors.New("unauthorized"))
+	End(parent, nil)
+	shutdown()
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每个 span 一行 json，子 span 先结束
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected spans:\n%s", content)
+	}
+	for _, want := range []string{`"Name":"image.transBlob"`, `"Key":"digest"`, `"Code":"Error"`, `"Description":"unauthorized"`, `"service.name"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("span does not contain %s:\n%s", want, lines[0])
+		}
+	}
+	var gotChild, gotParent struct {
+		Name        string
+		SpanContext struct{ SpanID string }
+		Parent      struct{ SpanID string }
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &gotChild); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &gotParent); err != nil {
+		t.Fatal(err)
+	}
+	if gotParent.Name != "image.SyncTask.Run" || gotChild.Parent.SpanID != gotParent.SpanContext.SpanID {
+		t.Errorf("unexpected parent span:\n%s", lines[1])
+	}
+}
@@ -0,0 +1,68 @@
+package cmdutil
+
+import (
+	"github.com/MR5356/syncer/pkg/log"
+	"github.com/MR5356/syncer/pkg/metrics"
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/MR5356/syncer/pkg/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Flags are the log, trace and metrics flags shared by the image, git and daemon commands
+type Flags struct {
+	Debug         bool
+	LogFormat     string
+	TaskLogDir    string
+	TraceExporter string
+	TraceFile     string
+	// MetricsFile is only added to the commands running once, the daemon serves its metrics
+	MetricsFile string
+}
+
+// AddFlags adds the flags to the persistent flags of cmd, withMetrics adds the metrics file flag
+func (f *Flags) AddFlags(cmd *cobra.Command, withMetrics bool) {
+	cmd.PersistentFlags().BoolVarP(&f.Debug, "debug", "d", false, "enable debug mode")
+	cmd.PersistentFlags().StringVar(&f.LogFormat, "log-format", log.FormatText, "log format, text or json")
+	cmd.PersistentFlags().StringVar(&f.TaskLogDir, "task-log-dir", "", "also write the logs of each task to its own file in the dir")
+	if withMetrics {
+		cmd.PersistentFlags().StringVar(&f.MetricsFile, "metrics-file", "", "write prometheus metrics to the file for the node exporter textfile collector")
+	}
+	cmd.PersistentFlags().StringVar(&f.TraceExporter, "trace-exporter", "", "export the traces of the sync to otlp or file")
+	cmd.PersistentFlags().StringVar(&f.TraceFile, "trace-file", "", "file of the file trace exporter, a json object per span")
+}
+
+// Setup sets up the logs and the trace exporter of the flags, the returned function flushes the pending spans
+// and closes the task log files
+func (f *Flags) Setup() func() {
+	closeLog, err := log.Setup(f.LogFormat, f.TaskLogDir)
+	if err != nil {
+		logrus.Fatalf("setup log failed: %s", err)
+	}
+	if f.Debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	stopTracing, err := tracing.Setup(f.TraceExporter, f.TraceFile)
+	if err != nil {
+		closeLog()
+		logrus.Fatalf("setup tracing failed: %s", err)
+	}
+	return func() {
+		stopTracing()
+		closeLog()
+	}
+}
+
+// WriteMetrics writes the metrics of the run to the metrics file if set, with the last success of the mappings
+// whose tasks all succeeded
+func (f *Flags) WriteMetrics(domain string, tasks, failed *task.List) {
+	if f.MetricsFile == "" {
+		return
+	}
+	for _, mapping := range task.SucceededMappings(tasks, failed) {
+		metrics.ObserveMapping(domain, mapping, nil)
+	}
+	if err := metrics.WriteTextfile(f.MetricsFile); err != nil {
+		logrus.Warnf("write metrics to %s failed: %s", f.MetricsFile, err)
+	}
+}
@@ -0,0 +1,57 @@
+package cmdutil
+
+import (
+	"github.com/MR5356/syncer/pkg/task"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type mappedTask struct {
+	name, mapping string
+}
+
+func (t *mappedTask) Name() string { return t.name }
+
+func (t *mappedTask) Run() error { return nil }
+
+func (t *mappedTask) Mapping() string { return t.mapping }
+
+func TestFlags(t *testing.T) {
+	f := new(Flags)
+	cmd := &cobra.Command{Use: "test"}
+	f.AddFlags(cmd, true)
+	file := filepath.Join(t.TempDir(), "syncer.prom")
+	if err := cmd.ParseFlags([]string{"-d", "--task-log-dir", t.TempDir(), "--metrics-file", file}); err != nil {
+		t.Fatal(err)
+	}
+
+	level := logrus.GetLevel()
+	defer logrus.SetLevel(level)
+	stop := f.Setup()
+	defer stop()
+	if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+		t.Errorf("debug level is not enabled")
+	}
+
+	tasks := task.NewTaskList()
+	tasks.Add(&mappedTask{name: "nginx:latest -> a", mapping: "nginx:latest"})
+	f.WriteMetrics("image", tasks, task.NewTaskList())
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `domain="image",mapping="nginx:latest"`) {
+		t.Errorf("last success of nginx:latest is not written:\n%s", content)
+	}
+
+	// daemon 不添加 metrics-file
+	cmd = &cobra.Command{Use: "daemon"}
+	new(Flags).AddFlags(cmd, false)
+	if cmd.PersistentFlags().Lookup("metrics-file") != nil {
+		t.Errorf("metrics-file is added without metrics")
+	}
+}
@@ -0,0 +1,181 @@
+package gitutil
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SchemeSSH   = "ssh"
+	SchemeHttp  = "http"
+	SchemeHttps = "https"
+	SchemeGit   = "git"
+	SchemeFile  = "file"
+)
+
+var (
+	// isScpUrl matches [user@]host:path where no slash comes before the colon
+	isScpUrl = regexp.MustCompile(`^(?:([^@/]+)@)?([^@/:]+|\[[^\]]+\]):(.*)$`)
+	// scpPort matches the legacy git@host:2222:path form
+	scpPort = regexp.MustCompile(`^(\d+):(.+)$`)
+	// isDrive matches windows paths like C:\repo or C:/repo
+	isDrive = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
+)
+
+// Url is a parsed git remote url. The supported forms are:
+//
+//	ssh://[user@]host[:port]/path
+//	[user@]host:path (scp-like)
+//	http[s]://[user[:password]@]host[:port]/path
+//	git://host[:port]/path
+//	file:///path and local paths
+type Url struct {
+	Scheme   string
+	User     string
+	Password string
+	Host     string
+	// Port is 0 if the url does not specify one
+	Port int
+	// Path is relative to the host for remote urls and a filesystem path for local urls
+	Path string
+	// Scp is true for the scp-like form
+	Scp bool
+}
+
+func ParseUrl(raw string) (*Url, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("empty git url")
+	}
+
+	if strings.Contains(raw, "://") {
+		return parseStandardUrl(raw)
+	}
+
+	if m := isScpUrl.FindStringSubmatch(raw); m != nil && !isDrive.MatchString(raw) {
+		u := &Url{
+			Scheme: SchemeSSH,
+			User:   m[1],
+			Host:   strings.Trim(m[2], "[]"),
+			Path:   m[3],
+			Scp:    true,
+		}
+		if pm := scpPort.FindStringSubmatch(u.Path); pm != nil {
+			u.Port, _ = strconv.Atoi(pm[1])
+			u.Path = pm[2]
+		}
+		u.Path = strings.TrimPrefix(u.Path, "/")
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid git url %s: empty path", raw)
+		}
+		return u, nil
+	}
+
+	return &Url{Scheme: SchemeFile, Path: raw}, nil
+}
+
+func parseStandardUrl(raw string) (*Url, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git url: %s", err)
+	}
+	res := &Url{Scheme: strings.ToLower(u.Scheme)}
+	switch res.Scheme {
+	case SchemeSSH, "git+ssh", "ssh+git":
+		res.Scheme = SchemeSSH
+	case SchemeHttp, SchemeHttps, SchemeGit:
+	case SchemeFile:
+		res.Path = u.Path
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("invalid git url %s: file url with remote host", raw)
+		}
+		if res.Path == "" {
+			return nil, fmt.Errorf("invalid git url %s: empty path", raw)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("invalid git url %s: unsupported scheme %s", raw, u.Scheme)
+	}
+
+	if u.User != nil {
+		res.User = u.User.Username()
+		res.Password, _ = u.User.Password()
+	}
+	res.Host = u.Hostname()
+	if res.Host == "" {
+		return nil, fmt.Errorf("invalid git url %s: empty host", raw)
+	}
+	if port := u.Port(); port != "" {
+		res.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid git url %s: invalid port %s", raw, port)
+		}
+	}
+	res.Path = strings.TrimPrefix(u.Path, "/")
+	if res.Path == "" {
+		return nil, fmt.Errorf("invalid git url %s: empty path", raw)
+	}
+	return res, nil
+}
+
+// IsLocal reports whether the url points to the local filesystem
+func (u *Url) IsLocal() bool {
+	return u.Scheme == SchemeFile
+}
+
+// GetPort returns the port of the url or the default port of its scheme
+func (u *Url) GetPort() int {
+	if u.Port != 0 {
+		return u.Port
+	}
+	switch u.Scheme {
+	case SchemeSSH:
+		return 22
+	case SchemeHttp:
+		return 80
+	case SchemeHttps:
+		return 443
+	case SchemeGit:
+		return 9418
+	}
+	return 0
+}
+
+// HostWithPort returns host:port if the url specifies a port, otherwise the host
+func (u *Url) HostWithPort() string {
+	if u.Port == 0 {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// RepoPath returns the path without the .git suffix and surrounding slashes, e.g. MR5356/syncer
+func (u *Url) RepoPath() string {
+	return strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+}
+
+// String returns the url without credentials in a form go-git understands, scp-like urls
+// are kept unless they use the legacy host:port:path form
+func (u *Url) String() string {
+	switch {
+	case u.IsLocal():
+		return u.Path
+	case u.Scp && u.Port == 0:
+		host := u.Host
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if u.User != "" {
+			return fmt.Sprintf("%s@%s:%s", u.User, host, u.Path)
+		}
+		return fmt.Sprintf("%s:%s", host, u.Path)
+	}
+	res := &url.URL{Scheme: u.Scheme, Host: u.HostWithPort(), Path: "/" + u.Path}
+	if u.Scheme == SchemeSSH && u.User != "" {
+		res.User = url.User(u.User)
+	}
+	return res.String()
+}